@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"bitbucket.org/hilmarp/price-scraper/metrics"
+	"bitbucket.org/hilmarp/price-scraper/notify"
 	"bitbucket.org/hilmarp/price-scraper/scraper"
 	"bitbucket.org/hilmarp/price-scraper/web"
 	"github.com/go-redis/redis/v8"
@@ -20,7 +21,6 @@ import (
 	"github.com/olivere/elastic/v7"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
@@ -46,14 +46,19 @@ func main() {
 	// Metrics
 	metrics.InitMetrics()
 
-	// Init MySQL
+	// Init SQL database, MySQL by default
+	dbDriver := os.Getenv("PRICE_SQL_DRIVER")
 	dbUser := os.Getenv("PRICE_SQL_USER")
 	dbPass := os.Getenv("PRICE_SQL_PASSWORD")
 	dbDb := os.Getenv("PRICE_SQL_DB")
 	dbPort := os.Getenv("PRICE_SQL_PORT")
-	connStr := fmt.Sprintf("%s:%s@tcp(127.0.0.1:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", dbUser, dbPass, dbPort, dbDb)
 
-	scraperDB, err := gorm.Open(mysql.Open(connStr), &gorm.Config{
+	scraperDialector, err := scraper.NewDialector(dbDriver, dbUser, dbPass, dbDb, dbPort)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scraperDB, err := gorm.Open(scraperDialector, &gorm.Config{
 		SkipDefaultTransaction: true,
 		Logger:                 logger.Default.LogMode(logger.Silent),
 	})
@@ -61,7 +66,12 @@ func main() {
 		log.Fatal(err)
 	}
 
-	webDB, err := gorm.Open(mysql.Open(connStr), &gorm.Config{
+	webDialector, err := scraper.NewDialector(dbDriver, dbUser, dbPass, dbDb, dbPort)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	webDB, err := gorm.Open(webDialector, &gorm.Config{
 		SkipDefaultTransaction: true,
 		Logger:                 logger.Default.LogMode(logger.Silent),
 	})
@@ -69,6 +79,15 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Migrations only, ex. scraper migrate status
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrate(&scraper.SQL{DB: scraperDB}, os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Init Elasticsearch
 	scraperES, err := elastic.NewClient()
 	if err != nil {
@@ -80,6 +99,20 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Reindex only, ex. after fields were added to the search index
+	if len(os.Args) > 1 && os.Args[1] == "reindex" {
+		reindexService := scraper.Scraper{
+			DB: &scraper.SQL{DB: scraperDB},
+			ES: &scraper.Elasticsearch{Client: scraperES},
+		}
+		indexed, err := reindexService.ReindexSearchProducts()
+		fmt.Printf("Indexed %d products\n", indexed)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	// Init redis
 	redisPort := os.Getenv("PRICE_REDIS_PORT")
 
@@ -100,6 +133,28 @@ func main() {
 	}
 	defer scraperMongo.Disconnect(ctx)
 
+	// Batch writer for scraped products
+	writerBatchSize := 50
+	writerFlushInterval := 2 * time.Second
+
+	num, err := strconv.Atoi(os.Getenv("PRICE_WRITER_BATCH_SIZE"))
+	if err == nil {
+		writerBatchSize = num
+	}
+
+	num, err = strconv.Atoi(os.Getenv("PRICE_WRITER_FLUSH_SECONDS"))
+	if err == nil {
+		writerFlushInterval = time.Duration(num) * time.Second
+	}
+
+	productWriter := &scraper.ProductWriter{
+		DB:            &scraper.SQL{DB: scraperDB},
+		ES:            &scraper.Elasticsearch{Client: scraperES},
+		BatchSize:     writerBatchSize,
+		FlushInterval: writerFlushInterval,
+	}
+	productWriter.Start()
+
 	// Handle CTRL^C
 	sigChan := make(chan os.Signal, 1)
 	stopChan := make(chan struct{}, 1)
@@ -107,6 +162,7 @@ func main() {
 	go func() {
 		<-sigChan
 		stopChan <- struct{}{}
+		productWriter.Stop()
 		os.Exit(0)
 	}()
 
@@ -121,7 +177,7 @@ func main() {
 	scrapeStackParallel := 2
 	scrapeRandomUserAgent := false
 
-	num, err := strconv.Atoi(scrapeQueueWorkersStr)
+	num, err = strconv.Atoi(scrapeQueueWorkersStr)
 	if err == nil {
 		scrapeQueueWorkers = num
 	}
@@ -147,6 +203,50 @@ func main() {
 		scrapeRandomUserAgent = true
 	}
 
+	// Watch verify links and unverified watches, in hours and days
+	verifyTTL := scraper.DefaultVerifyTTL
+	num, err = strconv.Atoi(os.Getenv("PRICE_VERIFY_TTL_HOURS"))
+	if err == nil && num > 0 {
+		verifyTTL = time.Duration(num) * time.Hour
+	}
+
+	unverifiedTTL := scraper.DefaultUnverifiedWatchTTL
+	num, err = strconv.Atoi(os.Getenv("PRICE_UNVERIFIED_WATCH_DAYS"))
+	if err == nil && num > 0 {
+		unverifiedTTL = time.Duration(num) * 24 * time.Hour
+	}
+
+	// Currency rates, shared by the scraper and the API server
+	currencyRatesPath := os.Getenv("PRICE_CURRENCY_RATES_PATH")
+	if currencyRatesPath == "" {
+		currencyRatesPath = os.Getenv("PRICE_ABS_PATH") + "/currency-rates.json"
+	}
+	currencyRates := &scraper.CurrencyRates{Path: currencyRatesPath}
+	err = currencyRates.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Notifiers, ex. PRICE_ALERT_NOTIFIERS=sendgrid,webhook, SendGrid by default.
+	// Alerts go through the outbox, which delivers with each channel on its own
+	alertChannels, err := notify.ChannelsFromEnv(os.Getenv("PRICE_ALERT_NOTIFIERS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Browsers subscribe with the VAPID public key when alerts are pushed
+	pushKey := ""
+	for _, channel := range alertChannels {
+		if key := notify.PushKey(channel.Notifier); key != "" {
+			pushKey = key
+		}
+	}
+
+	emailNotifier, err := notify.FromEnv(os.Getenv("PRICE_NOTIFIERS"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	scraperDBInit := &scraper.SQL{DB: scraperDB}
 	scraperService := scraper.Scraper{
 		DB:              scraperDBInit,
@@ -161,9 +261,13 @@ func main() {
 		Storage:         scrapeStorage,
 		QueueStorage:    scrapeQueueStorage,
 		RandomUserAgent: scrapeRandomUserAgent,
+		Currencies:      currencyRates,
+		Writer:          productWriter,
+		Channels:        alertChannels,
+		UnverifiedTTL:   unverifiedTTL,
 	}
 
-	// Run db migration
+	// Apply pending db migrations
 	err = scraperDBInit.Migrate()
 	if err != nil {
 		log.Fatal(err)
@@ -171,16 +275,30 @@ func main() {
 
 	go scraperService.StartScraper()
 	go scraperService.StartCleaner()
+	go scraperService.StartWatchCleaner()
 	go scraperService.StartWatcher()
+	go scraperService.StartNotificationSender()
+	go scraperService.StartDigestSender()
 	go scraperService.StartViewCounter()
 	go scraperService.StartPriceChangeWatcher()
+	go scraperService.StartSaleChecker()
+	go scraperService.StartShippingUpdater()
 
-	// Start API server
+	// Start API server, Redis also has the rate limits
+	apiRedis := &web.Redis{Client: webRedis}
 	apiServer := web.APIServer{
-		DB:    &scraper.SQL{DB: webDB},
-		ES:    &scraper.Elasticsearch{Client: webES},
-		Redis: &web.Redis{Client: webRedis},
-		Port:  os.Getenv("PRICE_WEB_SERVER_PORT"),
+		DB:         &scraper.SQL{DB: webDB},
+		ES:         &scraper.Elasticsearch{Client: webES},
+		Redis:      apiRedis,
+		Currencies: currencyRates,
+		Port:       os.Getenv("PRICE_WEB_SERVER_PORT"),
+		AdminToken: os.Getenv("PRICE_ADMIN_TOKEN"),
+		Notifier:   emailNotifier,
+		Limiter:    apiRedis,
+		TrustProxy: os.Getenv("PRICE_TRUST_PROXY") == "true",
+		VerifyTTL:  verifyTTL,
+		Dev:        os.Getenv("PRICE_APP_ENV") == "dev",
+		PushKey:    pushKey,
 	}
 	err = apiServer.StartServer()
 	if err != nil {
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"bitbucket.org/hilmarp/price-scraper/scraper"
+)
+
+const migrateUsage string = "usage: scraper migrate apply|rollback [steps]|status"
+
+// runMigrate runs the migrate subcommand with args
+func runMigrate(db *scraper.SQL, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	switch args[0] {
+	case "apply":
+		applied, err := db.MigrateUp()
+		for _, m := range applied {
+			fmt.Printf("Applied %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Nothing to apply")
+		}
+	case "rollback":
+		steps := 1
+		if len(args) > 1 {
+			num, err := strconv.Atoi(args[1])
+			if err != nil || num < 1 {
+				return fmt.Errorf(migrateUsage)
+			}
+			steps = num
+		}
+
+		rolledBack, err := db.MigrateDown(steps)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back %d %s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+	case "status":
+		statuses, err := db.GetMigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, status := range *statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%-6d %-40s %s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+
+	return nil
+}
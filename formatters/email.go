@@ -0,0 +1,36 @@
+package formatters
+
+import (
+	"net/mail"
+	"strings"
+)
+
+// IsValidEmail returns if email is a plain email address, ex. a@b.is,
+// without a name or anything around it and with a domain that has a dot
+func IsValidEmail(email string) bool {
+	if len(email) > 254 {
+		return false
+	}
+
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return false
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 1 {
+		return false
+	}
+
+	domain := email[at+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return false
+	}
+
+	return true
+}
+
+// NormalizeEmail returns email trimmed and lowercase, so the same address is counted once
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
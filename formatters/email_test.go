@@ -0,0 +1,30 @@
+package formatters
+
+import (
+	"testing"
+)
+
+func TestIsValidEmail(t *testing.T) {
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{"a@b.is", true},
+		{"jon.jonsson+verd@mail.verdfra.is", true},
+		{"", false},
+		{"a", false},
+		{"a@b", false},
+		{"a@b.", false},
+		{"@b.is", false},
+		{"Jón <a@b.is>", false},
+		{" a@b.is", false},
+		{"a@b.is, c@d.is", false},
+		{"a@b.is\r\nBcc: c@d.is", false},
+	}
+
+	for _, test := range tests {
+		if got := IsValidEmail(test.email); got != test.want {
+			t.Errorf("IsValidEmail(%q) = %v, want %v", test.email, got, test.want)
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package formatters
 
+import (
+	"strconv"
+	"strings"
+)
+
 // GetNumbersDiff returns the absolute diff between two numbers
 func GetNumbersDiff(a, b int) int {
 	if a < b {
@@ -7,3 +12,27 @@ func GetNumbersDiff(a, b int) int {
 	}
 	return a - b
 }
+
+// GetDiscountPercent returns how many percent lower price is than listPrice, rounded down
+func GetDiscountPercent(listPrice, price uint) uint {
+	if listPrice == 0 || price >= listPrice {
+		return 0
+	}
+
+	return (listPrice - price) * 100 / listPrice
+}
+
+// GroupThousands formats n with sep between every three digits, ex. 12990 with "." is 12.990
+func GroupThousands(n uint, sep string) string {
+	digits := strconv.FormatUint(uint64(n), 10)
+
+	var b strings.Builder
+	for i, digit := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(sep)
+		}
+		b.WriteRune(digit)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,80 @@
+package formatters
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PriceError is returned when a price can't be parsed from a string
+type PriceError struct {
+	Input  string
+	Reason string
+}
+
+func (e *PriceError) Error() string {
+	return fmt.Sprintf("could not parse price from %q: %s", e.Input, e.Reason)
+}
+
+// priceNumberRegex matches Icelandic formatted numbers, ex. 12.990, 12.990,50 and 12990,
+// and 12990.50 which some stores use in data attributes
+var priceNumberRegex = regexp.MustCompile(`\d{1,3}(?:\.\d{3})+(?:,\d+)?|\d+\.\d{1,2}\b|\d+(?:,\d+)?`)
+
+// ParsePrice parses an ISK price from s, ex. "12.990 kr.", "Verð frá 12.990 kr.", "12.990,50 kr"
+// and "ISK 12.990", for ranges like "12.990 - 15.990 kr." the first price is used.
+// Decimals are rounded to the nearest krona
+func ParsePrice(s string) (uint, error) {
+	return ParseMinorPrice(s, 0)
+}
+
+// ParseMinorPrice parses a price like ParsePrice, in minor units of a currency with exponent decimals,
+// ex. "12,49 €" is 1249 cents with exponent 2
+func ParseMinorPrice(s string, exponent int) (uint, error) {
+	input := s
+	s = strings.ReplaceAll(s, "\u00A0", " ") // nbsp
+	s = strings.TrimSpace(s)
+
+	if s == "" {
+		return 0, &PriceError{Input: input, Reason: "empty"}
+	}
+
+	match := priceNumberRegex.FindString(s)
+	if match == "" {
+		return 0, &PriceError{Input: input, Reason: "no number found"}
+	}
+
+	num := match
+	if strings.Contains(num, ",") {
+		// Thousand dots and decimal comma
+		num = strings.ReplaceAll(num, ".", "")
+		num = strings.ReplaceAll(num, ",", ".")
+	} else if dot := strings.LastIndex(num, "."); dot != -1 && len(num)-dot-1 == 3 {
+		// Only thousand dots
+		num = strings.ReplaceAll(num, ".", "")
+	}
+
+	price, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, &PriceError{Input: input, Reason: err.Error()}
+	}
+
+	price = price * math.Pow10(exponent)
+	if price > math.MaxUint32 {
+		return 0, &PriceError{Input: input, Reason: "too large"}
+	}
+
+	return uint(math.Round(price)), nil
+}
+
+// StringToListPrice returns the regular price before discount from string,
+// falls back to price if there's no list price or it's not higher than price
+func StringToListPrice(s string, price uint) uint {
+	listPrice, err := ParsePrice(s)
+	if err != nil || listPrice <= price {
+		return price
+	}
+
+	return listPrice
+}
@@ -0,0 +1,154 @@
+package formatters
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func TestParsePrice(t *testing.T) {
+	tests := []struct {
+		store string
+		in    string
+		price uint
+	}{
+		{"elko.is", "159.995 kr.", 159995},
+		{"heimkaup.is", "12.990 kr", 12990},
+		{"heimkaup.is", "12990", 12990},
+		{"rafha.is", "24.990 kr.", 24990},
+		{"ht.is", " 89.995 kr. ", 89995},
+		{"rafland.is", "39.995 kr. Setja í körfu", 39995},
+		{"computer.is", "14.990 kr.", 14990},
+		{"ormsson.is", "199.900 kr.", 199900},
+		{"utilif.is", "5.990 kr.", 5990},
+		{"epal.is", "45.900 kr", 45900},
+		{"byko.is", "1.595 kr./stk", 1595},
+		{"tl.is", "29.990 kr.", 29990},
+		{"nexus.is", "4.999 kr.", 4999},
+		{"rumfatalagerinn.is", "2.495 kr.", 2495},
+		{"penninn.is", "ISK 3.499", 3499},
+		{"byko.is", "Verð frá 12.990 kr.", 12990},
+		{"byko.is", "12.990,50 kr", 12991},
+		{"heimkaup.is", "12990.50", 12991},
+		{"epal.is", "12.990 kr. - 15.990 kr.", 12990},
+		{"elko.is", "1.299.995 kr.", 1299995},
+		{"nexus.is", "990 kr.", 990},
+		{"ht.is", "89.995\u00A0kr.", 89995},
+	}
+
+	for _, test := range tests {
+		price, err := ParsePrice(test.in)
+		if err != nil {
+			t.Errorf("Got error %s for %s", err.Error(), test.store)
+			continue
+		}
+		if price != test.price {
+			t.Errorf("Got %v, want %v for %s %q", price, test.price, test.store, test.in)
+		}
+	}
+}
+
+func TestParseMinorPrice(t *testing.T) {
+	tests := []struct {
+		in       string
+		exponent int
+		price    uint
+	}{
+		{"12,49 €", 2, 1249},
+		{"€12.49", 2, 1249},
+		{"1.249,90 EUR", 2, 124990},
+		{"99 kr.", 2, 9900},
+		{"12.990 kr.", 0, 12990},
+	}
+
+	for _, test := range tests {
+		price, err := ParseMinorPrice(test.in, test.exponent)
+		if err != nil {
+			t.Errorf("Got error %s for %q", err.Error(), test.in)
+			continue
+		}
+		if price != test.price {
+			t.Errorf("Got %v, want %v for %q", price, test.price, test.in)
+		}
+	}
+}
+
+// TestParsePriceSnippets parses the price markup of each store in testdata/prices with the selectors its scraper uses
+func TestParsePriceSnippets(t *testing.T) {
+	tests := []struct {
+		store     string
+		price     string // Selector of the price
+		strip     string // Selector of children that aren't part of the price
+		listPrice string // Selector of the list price
+		want      uint
+		wantList  uint
+	}{
+		{"byko.is", ".productDetails_MainInformation_Price .priceTag_Price", "", ".productDetails_MainInformation_Price .crashOverOldPrice", 12990, 14995},
+		{"computer.is", ".pantavoru .displayPrice", "", ".pantavoru .oldPrice", 14990, 16990},
+		{"elko.is", ".product-price-content .product-price", "", ".product-price-content .product-price-before", 159995, 179995},
+		{"epal.is", ".price-wrapper ins .amount", "", ".price-wrapper del .amount", 45900, 52900},
+		{"heimkaup.is", ".SideDetails-basket .Price-price .Price", "s", ".SideDetails-basket .Price-price .Price s", 12990, 14990},
+		{"ht.is", ".product-price", "", "#product .old-price", 89995, 99995},
+		{"nexus.is", ".summary.entry-summary ins .woocommerce-Price-amount.amount", "", ".summary.entry-summary del .woocommerce-Price-amount.amount", 4999, 5999},
+		{"ormsson.is", ".thisprice:not(.oldPrice)", "", ".thisprice.oldPrice", 199900, 219900},
+		{"penninn.is", ".commerce-price-savings-formatter-price .price-amount", "", ".commerce-price-savings-formatter-list .price-amount", 3499, 3999},
+		{"rafha.is", ".electro-price ins .amount", "", ".electro-price del .amount", 24990, 29990},
+		{"rafland.is", ".addtocartform .btn-cart", "", "#product .old-price", 39995, 44995},
+		{"rumfatalagerinn.is", ".new-product-price__price", "", ".new-product-price__offer-price strike", 2495, 3495},
+		{"tl.is", ".addtocartform .btn-cart", "", ".old-price", 29990, 34990},
+		{"utilif.is", ".product-info-price .normal-price .price", "", ".product-info-price .old-price .price", 5990, 7990},
+	}
+
+	for _, test := range tests {
+		file, err := os.Open(filepath.Join("testdata", "prices", test.store+".html"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc, err := goquery.NewDocumentFromReader(file)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		listPrice, err := ParsePrice(doc.Find(test.listPrice).Text())
+		if err != nil {
+			t.Errorf("Got error %s for %s list price", err.Error(), test.store)
+		} else if listPrice != test.wantList {
+			t.Errorf("Got list price %v, want %v for %s", listPrice, test.wantList, test.store)
+		}
+
+		if test.strip != "" {
+			doc.Find(test.price).Find(test.strip).Remove()
+		}
+		price, err := ParsePrice(doc.Find(test.price).Text())
+		if err != nil {
+			t.Errorf("Got error %s for %s", err.Error(), test.store)
+			continue
+		}
+		if price != test.want {
+			t.Errorf("Got %v, want %v for %s", price, test.want, test.store)
+		}
+	}
+}
+
+func TestParsePriceError(t *testing.T) {
+	for _, in := range []string{"", "   ", "kr.", "Uppselt"} {
+		price, err := ParsePrice(in)
+		if err == nil {
+			t.Errorf("Got %v, want error for %q", price, in)
+			continue
+		}
+
+		var priceErr *PriceError
+		if !errors.As(err, &priceErr) {
+			t.Errorf("Got %T, want *PriceError", err)
+			continue
+		}
+		if priceErr.Input != in {
+			t.Errorf("Got %q, want %q", priceErr.Input, in)
+		}
+	}
+}
@@ -9,17 +9,6 @@ import (
 	"github.com/gosimple/slug"
 )
 
-func StringToPrice(s string) uint {
-	price := strings.TrimSpace(s)
-	price = strings.ReplaceAll(price, "\u00A0", " ") // nbsp
-	price = strings.Split(price, " ")[0]
-	price = strings.ReplaceAll(price, ".", "")
-
-	priceInt := StringToInt(price)
-
-	return uint(priceInt)
-}
-
 func StringToInt(s string) int {
 	sInt, err := strconv.Atoi(s)
 	if err != nil {
@@ -0,0 +1,28 @@
+package formatters
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GetRandomToken returns a URL safe token of 32 random bytes from crypto/rand,
+// for links and sessions that must not be guessable
+func GetRandomToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hex SHA-256 of token. Tokens are stored hashed,
+// so the database doesn't have what's needed to use them
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,27 @@
+package formatters
+
+import (
+	"testing"
+)
+
+func TestGetRandomToken(t *testing.T) {
+	a, err := GetRandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GetRandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(a) != 43 || a == b {
+		t.Errorf("Got tokens %q and %q, want two different 43 character tokens", a, b)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	hash := HashToken("abc")
+	if hash != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("Got %s, want the SHA-256 of abc", hash)
+	}
+}
@@ -0,0 +1,116 @@
+package formatters
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// unit is a base unit and how many of the base unit one of this unit is
+type unit struct {
+	base   string
+	factor float64
+}
+
+// units maps Icelandic and common unit names to base units kg, l, m, m2 and stk
+var units = map[string]unit{
+	"kg":        {"kg", 1},
+	"kíló":      {"kg", 1},
+	"g":         {"kg", 0.001},
+	"gr":        {"kg", 0.001},
+	"gramm":     {"kg", 0.001},
+	"grömm":     {"kg", 0.001},
+	"l":         {"l", 1},
+	"ltr":       {"l", 1},
+	"lítri":     {"l", 1},
+	"lítrar":    {"l", 1},
+	"lítra":     {"l", 1},
+	"dl":        {"l", 0.1},
+	"cl":        {"l", 0.01},
+	"ml":        {"l", 0.001},
+	"m":         {"m", 1},
+	"metri":     {"m", 1},
+	"metrar":    {"m", 1},
+	"metra":     {"m", 1},
+	"cm":        {"m", 0.01},
+	"mm":        {"m", 0.001},
+	"m2":        {"m2", 1},
+	"m²":        {"m2", 1},
+	"fm":        {"m2", 1},
+	"fermetri":  {"m2", 1},
+	"fermetrar": {"m2", 1},
+	"fermetra":  {"m2", 1},
+	"stk":       {"stk", 1},
+	"stykki":    {"stk", 1},
+}
+
+// quantityRegex matches ex. "25 kg", "1,5l", "6 x 330 ml" and "10 stk."
+var quantityRegex = regexp.MustCompile(`(?i)(?:^|[^\p{L}\d])(?:(\d+)\s*[x×]\s*)?(\d+(?:[.,]\d+)*)\s*(fermetrar|fermetra|fermetri|lítrar|lítra|lítri|metrar|metra|metri|stykki|gramm|grömm|kíló|ltr|stk|kg|gr|dl|cl|ml|cm|mm|m2|m²|fm|g|l|m)(?:$|[^\p{L}\d])`)
+
+// ParseQuantity finds the first package quantity in s and returns it in base units kg, l, m, m2 or stk,
+// ex. "Sement 25 kg" => 25, "kg" and "Kók 6 x 330 ml" => 1.98, "l", returns false if nothing was found
+func ParseQuantity(s string) (float64, string, bool) {
+	s = strings.ReplaceAll(s, "\u00A0", " ") // nbsp
+
+	for _, match := range quantityRegex.FindAllStringSubmatch(s, -1) {
+		u, ok := units[strings.ToLower(match[3])]
+		if !ok {
+			continue
+		}
+
+		// Multiplier is only used for packs, ex. 6 x 330 ml, for lengths it's dimensions, ex. 200x300 cm
+		multiplier := 1.0
+		if match[1] != "" {
+			if u.base != "kg" && u.base != "l" {
+				continue
+			}
+			multiplier = float64(StringToInt(match[1]))
+		}
+
+		num, ok := parseDecimal(match[2])
+		if !ok || num <= 0 {
+			continue
+		}
+
+		return num * u.factor * multiplier, u.base, true
+	}
+
+	return 0, "", false
+}
+
+// parseDecimal parses Icelandic formatted numbers, comma is the decimal separator and dot the
+// thousand separator, a dot is treated as decimal separator if it's not followed by exactly 3 digits
+func parseDecimal(s string) (float64, bool) {
+	if strings.Contains(s, ",") {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	} else if strings.Contains(s, ".") {
+		parts := strings.Split(s, ".")
+		thousands := true
+		for _, p := range parts[1:] {
+			if len(p) != 3 {
+				thousands = false
+			}
+		}
+		if thousands {
+			s = strings.Join(parts, "")
+		}
+	}
+
+	num, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return num, true
+}
+
+// GetUnitPrice returns the price of one base unit, rounded to nearest
+func GetUnitPrice(price uint, quantity float64) uint {
+	if quantity <= 0 {
+		return 0
+	}
+
+	return uint(math.Round(float64(price) / quantity))
+}
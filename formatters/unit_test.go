@@ -0,0 +1,58 @@
+package formatters
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		in       string
+		quantity float64
+		unit     string
+	}{
+		{"Sement 25 kg", 25, "kg"},
+		{"Flísalím 5kg grátt", 5, "kg"},
+		{"Kaffi 500 g", 0.5, "kg"},
+		{"Pallaolía 2,5 ltr", 2.5, "l"},
+		{"Málning 1,5 lítrar", 1.5, "l"},
+		{"Gos 6 x 330 ml", 1.98, "l"},
+		{"Garðslanga 25 metrar", 25, "m"},
+		{"Kapall 3x1,5 mm2 50m", 50, "m"},
+		{"Parket 2,13 m²", 2.13, "m2"},
+		{"Skrúfur 100 stk.", 100, "stk"},
+		{"Vatn 1.000 ml", 1, "l"},
+	}
+
+	for _, test := range tests {
+		quantity, unit, ok := ParseQuantity(test.in)
+		if !ok {
+			t.Errorf("Got no quantity for %s", test.in)
+			continue
+		}
+		if math.Abs(quantity-test.quantity) > 0.0001 || unit != test.unit {
+			t.Errorf("Got %v %s, want %v %s for %s", quantity, unit, test.quantity, test.unit, test.in)
+		}
+	}
+}
+
+func TestParseQuantityNotFound(t *testing.T) {
+	for _, in := range []string{"Sjónvarp 55\"", "Motta 200x300 cm", "Skrúfur 4x40 mm", ""} {
+		quantity, unit, ok := ParseQuantity(in)
+		if ok {
+			t.Errorf("Got %v %s, want nothing for %s", quantity, unit, in)
+		}
+	}
+}
+
+func TestGetUnitPrice(t *testing.T) {
+	price := GetUnitPrice(1990, 25)
+	if price != 80 {
+		t.Errorf("Got %v, want %v", price, 80)
+	}
+
+	price = GetUnitPrice(1990, 0)
+	if price != 0 {
+		t.Errorf("Got %v, want %v", price, 0)
+	}
+}
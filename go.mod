@@ -1,6 +1,6 @@
 module bitbucket.org/hilmarp/price-scraper
 
-go 1.15
+go 1.16
 
 require (
 	github.com/PuerkitoBio/goquery v1.8.0
@@ -50,5 +50,7 @@ require (
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
 	gorm.io/driver/mysql v1.1.3
+	gorm.io/driver/postgres v1.2.2
+	gorm.io/driver/sqlite v1.2.3
 	gorm.io/gorm v1.22.3
 )
@@ -40,6 +40,24 @@ var WatcherEmailsSent = prometheus.NewCounter(prometheus.CounterOpts{
 	Help:      "Number of watcher emails sent",
 })
 
+var WatcherUnverifiedDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "watchers_unverified_deleted",
+	Help:      "Number of watches deleted because they weren't verified in time",
+})
+
+var WatcherDigestsSent = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "watchers_digests_sent",
+	Help:      "Number of watcher digest emails sent",
+})
+
+var DigestSendersRunning = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "digest_senders_running",
+	Help:      "Number of digest senders currently running",
+})
+
 var WatcherSignups = prometheus.NewCounter(prometheus.CounterOpts{
 	Namespace: namespace,
 	Name:      "watchers_signups",
@@ -76,6 +94,24 @@ var PriceChangeWatchersRunning = prometheus.NewGauge(prometheus.GaugeOpts{
 	Help:      "Number of price change watchers currently running",
 })
 
+var SaleCheckersRunning = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "sale_checkers_running",
+	Help:      "Number of sale checkers currently running",
+})
+
+var ShippingUpdatersRunning = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "shipping_updaters_running",
+	Help:      "Number of shipping cost updaters currently running",
+})
+
+var SuspiciousSalesFound = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "suspicious_sales_found",
+	Help:      "Total products flagged with a suspicious sale",
+})
+
 var ScraperResponses = prometheus.NewCounter(prometheus.CounterOpts{
 	Namespace: namespace,
 	Name:      "scraper_responses",
@@ -94,6 +130,38 @@ var ProductStoredCount = prometheus.NewCounter(prometheus.CounterOpts{
 	Help:      "Total products stored",
 })
 
+var WriterBatchSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "writer_batch_size",
+	Help:      "Number of products in each batch written by the product writer",
+	Buckets:   []float64{1, 5, 10, 25, 50, 100, 250},
+})
+
+var WriterFlushSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "writer_flush_seconds",
+	Help:      "Time it takes the product writer to write a batch to MySQL and Elasticsearch",
+	Buckets:   prometheus.DefBuckets,
+})
+
+var WriterBuffered = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "writer_buffered",
+	Help:      "Number of products waiting in the product writer buffer",
+})
+
+var WriterRetries = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "writer_retries",
+	Help:      "Total times the product writer tried a failed batch again",
+})
+
+var WriterDropped = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "writer_dropped",
+	Help:      "Total products the product writer couldn't store",
+})
+
 var ProductStoredESCount = prometheus.NewCounter(prometheus.CounterOpts{
 	Namespace: namespace,
 	Name:      "product_stored_es_count",
@@ -130,6 +198,36 @@ var ProductClickCount = prometheus.NewCounter(prometheus.CounterOpts{
 	Help:      "Click on product URL",
 })
 
+var NotificationSendersRunning = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "notification_senders_running",
+	Help:      "Number of notification senders currently running",
+})
+
+var NotificationsSent = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "notifications_sent",
+	Help:      "Number of notifications delivered from the outbox",
+})
+
+var NotificationRetries = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "notification_retries",
+	Help:      "Number of failed notification deliveries that will be retried",
+})
+
+var NotificationsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "notifications_failed",
+	Help:      "Number of notifications given up on after all retries",
+})
+
+var RejectedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "rejected_requests",
+	Help:      "Number of requests to endpoints that send email rejected, by reason",
+}, []string{"endpoint", "reason"})
+
 // InitMetrics will register all metrics in the registry
 func InitMetrics() {
 	prometheus.MustRegister(ScrapersRunning)
@@ -138,19 +236,35 @@ func InitMetrics() {
 	prometheus.MustRegister(CleanerDeleteCount)
 	prometheus.MustRegister(WatchersRunning)
 	prometheus.MustRegister(WatcherEmailsSent)
+	prometheus.MustRegister(WatcherDigestsSent)
+	prometheus.MustRegister(WatcherUnverifiedDeleted)
+	prometheus.MustRegister(DigestSendersRunning)
 	prometheus.MustRegister(WatcherSignups)
 	prometheus.MustRegister(WatcherVerifies)
 	prometheus.MustRegister(WatcherUnsubscribes)
 	prometheus.MustRegister(TotalSearches)
 	prometheus.MustRegister(ViewCountersRunning)
 	prometheus.MustRegister(PriceChangeWatchersRunning)
+	prometheus.MustRegister(SaleCheckersRunning)
+	prometheus.MustRegister(SuspiciousSalesFound)
+	prometheus.MustRegister(ShippingUpdatersRunning)
 	prometheus.MustRegister(ScraperResponses)
 	prometheus.MustRegister(ScraperErrorResponses)
 	prometheus.MustRegister(ProductStoredCount)
 	prometheus.MustRegister(ProductStoredESCount)
+	prometheus.MustRegister(WriterBatchSize)
+	prometheus.MustRegister(WriterFlushSeconds)
+	prometheus.MustRegister(WriterBuffered)
+	prometheus.MustRegister(WriterRetries)
+	prometheus.MustRegister(WriterDropped)
 	prometheus.MustRegister(ScraperRedisEnqueues)
 	prometheus.MustRegister(ScraperRedisEnqueuesError)
 	prometheus.MustRegister(ScraperRedisDequeues)
 	prometheus.MustRegister(ScraperRedisDequeuesError)
 	prometheus.MustRegister(ProductClickCount)
+	prometheus.MustRegister(NotificationSendersRunning)
+	prometheus.MustRegister(NotificationsSent)
+	prometheus.MustRegister(NotificationRetries)
+	prometheus.MustRegister(NotificationsFailed)
+	prometheus.MustRegister(RejectedRequests)
 }
@@ -0,0 +1,187 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"time"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+)
+
+// fromName is the sender name of all emails
+const fromName string = "Verð frá"
+
+// SendGrid sends messages as emails with the SendGrid API
+type SendGrid struct {
+	APIKey string
+	From   string // Email address
+}
+
+// Notify sends message to message.To
+func (n *SendGrid) Notify(ctx context.Context, message Message) error {
+	toName := message.ToName
+	if toName == "" {
+		toName = message.To
+	}
+
+	html := message.HTML
+	if html == "" {
+		html = message.PlainText()
+	}
+
+	from := mail.NewEmail(fromName, n.From)
+	to := mail.NewEmail(toName, message.To)
+	email := mail.NewSingleEmail(from, message.Subject, to, message.PlainText(), html)
+	client := sendgrid.NewSendClient(n.APIKey)
+	res, err := client.SendWithContext(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid returned %d: %s", res.StatusCode, res.Body)
+	}
+
+	return nil
+}
+
+// SMTP sends messages as emails with an SMTP server
+type SMTP struct {
+	Addr     string // host:port
+	Username string // No auth when empty
+	Password string
+	From     string // Email address
+}
+
+// Notify sends message to message.To, like smtp.SendMail but it gives up when ctx is done
+func (n *SMTP) Notify(ctx context.Context, message Message) error {
+	body, err := n.email(message)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(n.Addr)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", n.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err := c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if n.Username != "" {
+		err := c.Auth(smtp.PlainAuth("", n.Username, n.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(n.From)
+	if err != nil {
+		return err
+	}
+
+	err = c.Rcpt(message.To)
+	if err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(body)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+// email returns message as a multipart email with plain text and HTML versions
+func (n *SMTP) email(message Message) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	to := message.To
+	if message.ToName != "" {
+		to = fmt.Sprintf("%s <%s>", mime.QEncoding.Encode("utf-8", message.ToName), message.To)
+	}
+
+	headers := []struct{ key, value string }{
+		{"From", fmt.Sprintf("%s <%s>", mime.QEncoding.Encode("utf-8", fromName), n.From)},
+		{"To", to},
+		{"Subject", mime.QEncoding.Encode("utf-8", message.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%s", writer.Boundary())},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%s: %s\r\n", h.key, h.value)
+	}
+	buf.WriteString("\r\n")
+
+	parts := []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", message.PlainText()},
+		{"text/html; charset=utf-8", message.HTML},
+	}
+	for _, p := range parts {
+		if p.body == "" {
+			continue
+		}
+
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = part.Write([]byte(p.body))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
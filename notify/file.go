@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+)
+
+// Log writes messages to the log instead of sending them, for development
+type Log struct{}
+
+// Notify logs who message is to, the subject and plain text
+func (n *Log) Notify(ctx context.Context, message Message) error {
+	log.Printf("notify %s: %s\n%s", message.To, message.Subject, message.PlainText())
+	return nil
+}
+
+// File appends messages to a file as JSON lines instead of sending them, for development
+type File struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Notify appends message to the file
+func (n *File) Notify(ctx context.Context, message Message) error {
+	line, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,160 @@
+// Package notify sends messages to people, by email, webhooks or Web Push
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultClient sends the requests of webhooks and Web Push when they have no client,
+// so a receiver that hangs doesn't hold up other messages
+var defaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// Message is a notification to one person
+type Message struct {
+	To      string // Email address
+	ToName  string // Defaults to To
+	Subject string
+	HTML    string
+	Text    string             // Plain text version, Subject and URL are used when it's empty
+	URL     string             // Page the message is about, ex. the product
+	Push    []PushSubscription // Browsers of the recipient, Web Push is only sent when there are any
+}
+
+// PlainText returns the plain text version of the message
+func (m Message) PlainText() string {
+	if m.Text != "" {
+		return m.Text
+	}
+
+	if m.URL != "" {
+		return m.Subject + "\n" + m.URL
+	}
+
+	return m.Subject
+}
+
+// Notifier sends messages
+type Notifier interface {
+	Notify(ctx context.Context, message Message) error
+}
+
+// Multi sends every message with all of its notifiers,
+// one failing doesn't stop the others
+type Multi []Notifier
+
+// Notify sends message with all notifiers
+func (m Multi) Notify(ctx context.Context, message Message) error {
+	var errs []string
+	for _, n := range m {
+		err := n.Notify(ctx, message)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d notifiers failed: %s", len(errs), len(m), strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Channel is a notifier and the name it's configured with, ex. webhook
+type Channel struct {
+	Name string
+	Notifier
+}
+
+// FromEnv returns the notifiers in names, comma separated, configured with PRICE_* environment variables.
+// Names are sendgrid, smtp, webhook, webpush, log and file, sendgrid is used when names is empty
+func FromEnv(names string) (Notifier, error) {
+	channels, err := ChannelsFromEnv(names)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(channels) == 1 {
+		return channels[0].Notifier, nil
+	}
+
+	notifiers := make(Multi, len(channels))
+	for i, c := range channels {
+		notifiers[i] = c.Notifier
+	}
+
+	return notifiers, nil
+}
+
+// ChannelsFromEnv returns the notifiers in names like FromEnv, each as a channel of its own
+func ChannelsFromEnv(names string) ([]Channel, error) {
+	if names == "" {
+		names = "sendgrid"
+	}
+
+	var channels []Channel
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		for _, c := range channels {
+			if c.Name == name {
+				return nil, fmt.Errorf("notifier %q is listed twice", name)
+			}
+		}
+
+		n, err := fromEnv(name)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, Channel{Name: name, Notifier: n})
+	}
+
+	return channels, nil
+}
+
+func fromEnv(name string) (Notifier, error) {
+	switch name {
+	case "sendgrid":
+		return &SendGrid{
+			APIKey: os.Getenv("PRICE_EMAIL_API_KEY"),
+			From:   os.Getenv("PRICE_EMAIL_FROM"),
+		}, nil
+	case "smtp":
+		addr := os.Getenv("PRICE_SMTP_ADDR")
+		if addr == "" {
+			return nil, fmt.Errorf("smtp notifier needs PRICE_SMTP_ADDR")
+		}
+		return &SMTP{
+			Addr:     addr,
+			Username: os.Getenv("PRICE_SMTP_USER"),
+			Password: os.Getenv("PRICE_SMTP_PASSWORD"),
+			From:     os.Getenv("PRICE_EMAIL_FROM"),
+		}, nil
+	case "webhook":
+		url := os.Getenv("PRICE_WEBHOOK_URL")
+		if url == "" {
+			return nil, fmt.Errorf("webhook notifier needs PRICE_WEBHOOK_URL")
+		}
+		return &Webhook{URL: url, Secret: os.Getenv("PRICE_WEBHOOK_SECRET")}, nil
+	case "webpush":
+		ttl, err := strconv.Atoi(os.Getenv("PRICE_VAPID_TTL"))
+		if err != nil {
+			ttl = 0
+		}
+		return NewWebPush(os.Getenv("PRICE_VAPID_PRIVATE_KEY"), os.Getenv("PRICE_VAPID_SUBJECT"), ttl)
+	case "log":
+		return &Log{}, nil
+	case "file":
+		path := os.Getenv("PRICE_NOTIFY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("file notifier needs PRICE_NOTIFY_FILE")
+		}
+		return &File{Path: path}, nil
+	}
+
+	return nil, fmt.Errorf("unknown notifier %q, use sendgrid, smtp, webhook, webpush, log or file", name)
+}
@@ -0,0 +1,270 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testMessage = Message{
+	To:      "a@b.is",
+	Subject: "Verðlækkun - Sjónvarp",
+	HTML:    "<p>Sjónvarp hefur lækkað</p>",
+	URL:     "https://verdfra.is/product/elko-1",
+}
+
+type failingNotifier struct{}
+
+func (n failingNotifier) Notify(ctx context.Context, message Message) error {
+	return errors.New("down")
+}
+
+func TestMulti(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.jsonl")
+	err := Multi{failingNotifier{}, &File{Path: path}}.Notify(context.Background(), testMessage)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Errorf("Got error %v, want 1 of 2 failed", err)
+	}
+
+	// The file notifier still got it
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []Message
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m Message
+		err := json.Unmarshal(scanner.Bytes(), &m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, m)
+	}
+	if len(lines) != 1 || lines[0].Subject != testMessage.Subject {
+		t.Errorf("Got %v in file", lines)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	n, err := FromEnv("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.(*SendGrid); !ok {
+		t.Errorf("Got %T by default, want *SendGrid", n)
+	}
+
+	n, err = FromEnv("log, sendgrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := n.(Multi); !ok || len(m) != 2 {
+		t.Errorf("Got %T, want Multi of 2", n)
+	}
+
+	_, err = FromEnv("pigeon")
+	if err == nil {
+		t.Error("Got no error for an unknown notifier")
+	}
+
+	channels, err := ChannelsFromEnv("log, sendgrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(channels) != 2 || channels[0].Name != "log" || channels[1].Name != "sendgrid" {
+		t.Errorf("Got channels %v, want log and sendgrid", channels)
+	}
+
+	_, err = ChannelsFromEnv("log,log")
+	if err == nil {
+		t.Error("Got no error for a notifier listed twice")
+	}
+}
+
+func TestSMTPEmail(t *testing.T) {
+	n := &SMTP{Addr: "localhost:25", From: "contact@mail.verdfra.is"}
+	email, err := n.email(testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"To: a@b.is\r\n",
+		"Subject: =?utf-8?q?Ver=C3=B0l=C3=A6kkun_-_Sj=C3=B3nvarp?=\r\n",
+		"Content-Type: multipart/alternative;",
+		"Content-Type: text/plain; charset=utf-8",
+		"Verðlækkun - Sjónvarp\nhttps://verdfra.is/product/elko-1",
+		"Content-Type: text/html; charset=utf-8",
+		testMessage.HTML,
+	} {
+		if !strings.Contains(string(email), want) {
+			t.Errorf("Email has no %q:\n%s", want, email)
+		}
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var body []byte
+	var payload webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		signature := WebhookSignature("secret", r.Header.Get("X-Signature-Timestamp"), body)
+		if r.Header.Get("X-Signature") != signature {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.Unmarshal(body, &payload)
+	}))
+	defer server.Close()
+
+	err := (&Webhook{URL: server.URL, Secret: "secret"}).Notify(context.Background(), testMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Text != testMessage.PlainText() || payload.Content != payload.Text {
+		t.Errorf("Got payload %+v", payload)
+	}
+	if strings.Contains(string(body), testMessage.To) {
+		t.Errorf("Got the recipient in the webhook body %s", body)
+	}
+
+	err = (&Webhook{URL: server.URL, Secret: "wrong"}).Notify(context.Background(), testMessage)
+	if err == nil {
+		t.Error("Got no error for a rejected webhook")
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := (&Webhook{URL: server.URL}).Notify(ctx, testMessage)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Got %v from a webhook that hangs, want the deadline exceeded", err)
+	}
+}
+
+func TestWebPush(t *testing.T) {
+	// Browser keys
+	curve := elliptic.P256()
+	uaPrivate, uaX, uaY, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uaPublic := elliptic.Marshal(curve, uaX, uaY)
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	vapidKey := make([]byte, 32)
+	rand.Read(vapidKey)
+	n, err := NewWebPush(base64.RawURLEncoding.EncodeToString(vapidKey), "mailto:a@b.is", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body []byte
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		authorization = r.Header.Get("Authorization")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	subscription := &PushSubscription{Endpoint: server.URL + "/push/1"}
+	subscription.Keys.P256dh = base64.RawURLEncoding.EncodeToString(uaPublic)
+	subscription.Keys.Auth = base64.RawURLEncoding.EncodeToString(authSecret)
+
+	if PushKey(Multi{&Log{}, n}) != n.PublicKey() || PushKey(&Log{}) != "" {
+		t.Error("Got the wrong push key")
+	}
+
+	// Skipped without a subscription
+	err = n.Notify(context.Background(), testMessage)
+	if err != nil || body != nil {
+		t.Fatalf("Got %v and %d bytes without a subscription", err, len(body))
+	}
+
+	message := testMessage
+	message.Push = []PushSubscription{*subscription}
+	err = n.Notify(context.Background(), message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decrypt as the browser would
+	salt := body[:16]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != uint32(pushRecordSize) {
+		t.Errorf("Got record size %d", rs)
+	}
+	asPublic := body[21 : 21+int(body[20])]
+	asX, asY := elliptic.Unmarshal(curve, asPublic)
+	sharedX, _ := curve.ScalarMult(asX, asY, uaPrivate)
+	ecdhSecret := make([]byte, 32)
+	sharedX.FillBytes(ecdhSecret)
+	cek, nonce := pushKeys(ecdhSecret, authSecret, salt, uaPublic, asPublic)
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, body[21+len(asPublic):], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload map[string]string
+	err = json.Unmarshal(plaintext[:len(plaintext)-1], &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload["title"] != testMessage.Subject || payload["url"] != testMessage.URL {
+		t.Errorf("Got payload %v", payload)
+	}
+
+	// VAPID token is signed by the key in k
+	parts := strings.Split(strings.TrimPrefix(authorization, "vapid t="), ", k=")
+	if len(parts) != 2 || parts[1] != n.PublicKey() {
+		t.Fatalf("Got Authorization %q", authorization)
+	}
+	token := strings.Split(parts[0], ".")
+	signature, _ := base64.RawURLEncoding.DecodeString(token[2])
+	hash := sha256.Sum256([]byte(token[0] + "." + token[1]))
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(&n.key.PublicKey, hash[:], r, s) {
+		t.Error("VAPID signature doesn't verify")
+	}
+
+	var claims map[string]interface{}
+	claimsJSON, _ := base64.RawURLEncoding.DecodeString(token[1])
+	json.Unmarshal(claimsJSON, &claims)
+	if claims["aud"] != server.URL || claims["sub"] != "mailto:a@b.is" {
+		t.Errorf("Got claims %v", claims)
+	}
+}
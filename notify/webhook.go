@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Webhook posts messages as JSON to a URL. The body has text for Slack and content for Discord,
+// other receivers can check the signature of the timestamp and body with the shared secret
+type Webhook struct {
+	URL    string
+	Secret string       // Requests aren't signed when it's empty
+	Client *http.Client // One with a 30 second timeout when nil
+}
+
+// webhookPayload is the body of webhook requests. The webhook gets the messages of every recipient,
+// so who a message is to is left out
+type webhookPayload struct {
+	Subject string `json:"subject"`
+	URL     string `json:"url,omitempty"`
+	Text    string `json:"text"`
+	Content string `json:"content"`
+}
+
+// WebhookSignature returns the X-Signature header of a request with timestamp and body,
+// "sha256=" and the hex HMAC-SHA256 of "timestamp.body" with secret
+func WebhookSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify posts message to the webhook URL
+func (n *Webhook) Notify(ctx context.Context, message Message) error {
+	text := message.PlainText()
+	body, err := json.Marshal(webhookPayload{
+		Subject: message.Subject,
+		URL:     message.URL,
+		Text:    text,
+		Content: text,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if n.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Signature-Timestamp", timestamp)
+		req.Header.Set("X-Signature", WebhookSignature(n.Secret, timestamp, body))
+	}
+
+	client := n.Client
+	if client == nil {
+		client = defaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		resBody, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("webhook returned %d: %s", res.StatusCode, resBody)
+	}
+
+	return nil
+}
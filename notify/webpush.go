@@ -0,0 +1,289 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PushSubscription is a browser push subscription, as returned by PushManager.subscribe
+type PushSubscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"` // Base64 URL
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// pushRecordSize is the record size of encrypted push messages, payloads have to fit in one record
+const pushRecordSize int = 4096
+
+// WebPush sends messages that have a push subscription with the Web Push protocol,
+// encrypted with RFC 8291 and authenticated with VAPID, RFC 8292
+type WebPush struct {
+	key     *ecdsa.PrivateKey
+	subject string       // mailto: or https: URL the push service can contact
+	ttl     int          // Seconds the push service keeps undelivered messages
+	Client  *http.Client // One with a 30 second timeout when nil
+}
+
+// NewWebPush returns a Web Push notifier with the VAPID private key, base64 URL encoded,
+// subject is a mailto: or https: URL and ttl defaults to 1 day when 0
+func NewWebPush(privateKey, subject string, ttl int) (*WebPush, error) {
+	d, err := base64.RawURLEncoding.DecodeString(privateKey)
+	if err != nil || len(d) != 32 {
+		return nil, fmt.Errorf("webpush notifier needs a base64 URL encoded 32 byte VAPID private key")
+	}
+
+	if subject == "" {
+		return nil, fmt.Errorf("webpush notifier needs a VAPID subject")
+	}
+
+	if ttl <= 0 {
+		ttl = 86400
+	}
+
+	curve := elliptic.P256()
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d)
+
+	return &WebPush{key: key, subject: subject, ttl: ttl}, nil
+}
+
+// PublicKey returns the VAPID public key browsers subscribe with, base64 URL encoded
+func (n *WebPush) PublicKey() string {
+	return base64.RawURLEncoding.EncodeToString(elliptic.Marshal(n.key.Curve, n.key.X, n.key.Y))
+}
+
+// PushKey returns the VAPID public key of the Web Push notifier in n, empty when it has none
+func PushKey(n Notifier) string {
+	switch n := n.(type) {
+	case *WebPush:
+		return n.PublicKey()
+	case Multi:
+		for _, notifier := range n {
+			if key := PushKey(notifier); key != "" {
+				return key
+			}
+		}
+	}
+
+	return ""
+}
+
+// Notify pushes message to every subscription in message.Push, messages without one are skipped
+func (n *WebPush) Notify(ctx context.Context, message Message) error {
+	if len(message.Push) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(map[string]string{
+		"title": message.Subject,
+		"body":  message.PlainText(),
+		"url":   message.URL,
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for i := range message.Push {
+		err := n.push(ctx, payload, &message.Push[i])
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d pushes failed: %s", len(errs), len(message.Push), strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// push sends payload to subscription
+func (n *WebPush) push(ctx context.Context, payload []byte, subscription *PushSubscription) error {
+	body, err := encryptPush(payload, subscription)
+	if err != nil {
+		return err
+	}
+
+	authorization, err := n.vapid(subscription.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(n.ttl))
+	req.Header.Set("Authorization", authorization)
+
+	client := n.Client
+	if client == nil {
+		client = defaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		resBody, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("push service returned %d: %s", res.StatusCode, resBody)
+	}
+
+	return nil
+}
+
+// vapid returns the Authorization header for a push to endpoint
+func (n *WebPush) vapid(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	header, err := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": n.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, n.key, hash[:])
+	if err != nil {
+		return "", err
+	}
+
+	// ES256 signatures are r and s as 32 bytes each
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	return fmt.Sprintf("vapid t=%s, k=%s", token, n.PublicKey()), nil
+}
+
+// encryptPush encrypts payload for subscription as one aes128gcm record
+func encryptPush(payload []byte, subscription *PushSubscription) ([]byte, error) {
+	if len(payload)+17 > pushRecordSize {
+		return nil, fmt.Errorf("push payload is %d bytes, max is %d", len(payload), pushRecordSize-17)
+	}
+
+	uaPublic, err := base64.RawURLEncoding.DecodeString(subscription.Keys.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid push subscription key: %w", err)
+	}
+	authSecret, err := base64.RawURLEncoding.DecodeString(subscription.Keys.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("invalid push subscription auth: %w", err)
+	}
+
+	curve := elliptic.P256()
+	uaX, uaY := elliptic.Unmarshal(curve, uaPublic)
+	if uaX == nil {
+		return nil, fmt.Errorf("invalid push subscription key")
+	}
+
+	// A new key and salt for every message
+	asPrivate, asX, asY, err := elliptic.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := elliptic.Marshal(curve, asX, asY)
+
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	sharedX, _ := curve.ScalarMult(uaX, uaY, asPrivate)
+	ecdhSecret := make([]byte, 32)
+	sharedX.FillBytes(ecdhSecret)
+
+	cek, nonce := pushKeys(ecdhSecret, authSecret, salt, uaPublic, asPublic)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2 is the padding delimiter of the last record
+	ciphertext := gcm.Seal(nil, nonce, append(payload, 2), nil)
+
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(pushRecordSize))
+	body.WriteByte(byte(len(asPublic)))
+	body.Write(asPublic)
+	body.Write(ciphertext)
+
+	return body.Bytes(), nil
+}
+
+// pushKeys derives the content encryption key and nonce of a push message, RFC 8291 section 3.4
+func pushKeys(ecdhSecret, authSecret, salt, uaPublic, asPublic []byte) ([]byte, []byte) {
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdfExpand(hkdfExtract(authSecret, ecdhSecret), keyInfo, 32)
+
+	prk := hkdfExtract(salt, ikm)
+	cek := hkdfExpand(prk, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdfExpand(prk, []byte("Content-Encoding: nonce\x00"), 12)
+
+	return cek, nonce
+}
+
+// hkdfExtract is HKDF-Extract with SHA-256, RFC 5869
+func hkdfExtract(salt, ikm []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+// hkdfExpand is HKDF-Expand with SHA-256 for up to 32 bytes, RFC 5869
+func hkdfExpand(prk, info []byte, length int) []byte {
+	mac := hmac.New(sha256.New, prk)
+	mac.Write(info)
+	mac.Write([]byte{1})
+	return mac.Sum(nil)[:length]
+}
@@ -0,0 +1,28 @@
+package scraper
+
+import "strings"
+
+// brandSpecKeys are spec keys that might have the brand, lowercase
+var brandSpecKeys = []string{
+	"framleiðandi",
+	"vörumerki",
+	"merki",
+	"brand",
+}
+
+// setProductBrand sets the brand from the first spec with a brand key, if the store didn't set it
+func setProductBrand(product *Product) {
+	if product.Brand != "" {
+		product.Brand = strings.TrimSpace(product.Brand)
+		return
+	}
+
+	for _, key := range brandSpecKeys {
+		for _, spec := range product.Specs {
+			if strings.ToLower(strings.TrimSpace(spec.Key)) == key && strings.TrimSpace(spec.Value) != "" {
+				product.Brand = strings.TrimSpace(spec.Value)
+				return
+			}
+		}
+	}
+}
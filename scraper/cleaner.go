@@ -17,10 +17,10 @@ func (s *Scraper) StartCleaner() error {
 		defer metrics.CleanersRunning.Dec()
 
 		limit := 100
-		offset := 0
+		var cursor *Cursor
 
 		for {
-			products, err := s.DB.GetProducts(limit, offset, 0, 0, "id asc", "", []string{}, []string{})
+			products, next, err := s.DB.GetProducts(ProductQuery{Limit: limit, Cursor: cursor})
 			if err != nil {
 				log.Print(err)
 				break
@@ -72,10 +72,48 @@ func (s *Scraper) StartCleaner() error {
 				}
 			}
 
-			offset = offset + limit
+			// Keyset, so deleted or added products don't shift the pages
+			if next == nil {
+				break
+			}
+			cursor = next
 		}
 	})
 	c.Start()
 
 	return nil
 }
+
+// StartWatchCleaner will delete watches that weren't verified in time, every night
+func (s *Scraper) StartWatchCleaner() error {
+	c := cron.New()
+	c.AddFunc("30 3 * * *", func() { // At 03:30
+		metrics.CleanersRunning.Inc()
+		defer metrics.CleanersRunning.Dec()
+
+		err := s.CleanUnverifiedWatches(time.Now())
+		if err != nil {
+			log.Print(err)
+		}
+	})
+	c.Start()
+
+	return nil
+}
+
+// CleanUnverifiedWatches deletes unverified watches with verify links sent more than UnverifiedTTL before now
+func (s *Scraper) CleanUnverifiedWatches(now time.Time) error {
+	ttl := s.UnverifiedTTL
+	if ttl <= 0 {
+		ttl = DefaultUnverifiedWatchTTL
+	}
+
+	deleted, err := s.DB.DeleteUnverifiedWatchProducts(now.Add(-ttl))
+	if err != nil {
+		return err
+	}
+
+	metrics.WatcherUnverifiedDeleted.Add(float64(deleted))
+
+	return nil
+}
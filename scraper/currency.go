@@ -0,0 +1,187 @@
+package scraper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+// BaseCurrency is the currency all prices are converted to for comparison
+const BaseCurrency string = "ISK"
+
+// vatRate is the standard Icelandic VAT rate, used for prices without VAT
+const vatRate float64 = 0.24
+
+// currencyExponents are the ISO 4217 minor unit decimals of currencies that don't have 2
+var currencyExponents = map[string]int{
+	BaseCurrency: 0,
+	"JPY":        0,
+	"KRW":        0,
+}
+
+// CurrencyExponent returns the decimals of the minor unit of currency, prices are stored in minor units,
+// ex. 2 for EUR where 12.49 is 1249 cents and 0 for ISK
+func CurrencyExponent(currency string) int {
+	currency = strings.ToUpper(currency)
+	if currency == "" {
+		currency = BaseCurrency
+	}
+
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return 2
+	}
+
+	return exponent
+}
+
+// CurrencyRates is a conversion table, how many ISK one unit of a currency is,
+// loaded from and saved to a JSON file, ex. {"EUR": 145.5, "DKK": 19.5}
+type CurrencyRates struct {
+	Path  string
+	mu    sync.RWMutex
+	rates map[string]float64
+}
+
+// Load reads the rates from file, a missing file means only ISK is supported
+func (c *CurrencyRates) Load() error {
+	rates := make(map[string]float64)
+
+	file, err := ioutil.ReadFile(c.Path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err == nil {
+		err = json.Unmarshal(file, &rates)
+		if err != nil {
+			return fmt.Errorf("error reading currency rates from %s: %w", c.Path, err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rates = normalizeRates(rates)
+
+	return nil
+}
+
+// Set replaces all rates and saves them to file
+func (c *CurrencyRates) Set(rates map[string]float64) error {
+	rates = normalizeRates(rates)
+	for currency, rate := range rates {
+		if rate <= 0 {
+			return fmt.Errorf("invalid rate %v for %s", rate, currency)
+		}
+	}
+
+	file, err := json.MarshalIndent(rates, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = ioutil.WriteFile(c.Path, file, 0644)
+	if err != nil {
+		return err
+	}
+
+	c.rates = rates
+
+	return nil
+}
+
+// Rates returns a copy of all rates
+func (c *CurrencyRates) Rates() map[string]float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rates := make(map[string]float64, len(c.rates))
+	for currency, rate := range c.rates {
+		rates[currency] = rate
+	}
+
+	return rates
+}
+
+// Rate returns how many ISK one unit of currency is
+func (c *CurrencyRates) Rate(currency string) (float64, error) {
+	currency = strings.ToUpper(currency)
+	if currency == BaseCurrency {
+		return 1, nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	rate, ok := c.rates[currency]
+	if !ok {
+		return 0, fmt.Errorf("no currency rate for %s", currency)
+	}
+
+	return rate, nil
+}
+
+// ToISK converts price in minor units of currency to ISK with VAT included, rounded to nearest
+func (c *CurrencyRates) ToISK(price uint, currency string, vatIncluded bool) (uint, error) {
+	rate, err := c.Rate(currency)
+	if err != nil {
+		return 0, err
+	}
+
+	isk := float64(price) / math.Pow10(CurrencyExponent(currency)) * rate
+	if !vatIncluded {
+		isk = isk * (1 + vatRate)
+	}
+
+	return uint(math.Round(isk)), nil
+}
+
+// FromISK converts an ISK price to currency, rounded to 2 decimals
+func (c *CurrencyRates) FromISK(price uint, currency string) (float64, error) {
+	rate, err := c.Rate(currency)
+	if err != nil {
+		return 0, err
+	}
+
+	return math.Round(float64(price)/rate*100) / 100, nil
+}
+
+// SetDisplayPrice sets the product price in currency, from the ISK price
+func (c *CurrencyRates) SetDisplayPrice(product *Product, currency string) error {
+	if currency == "" {
+		currency = BaseCurrency
+	}
+
+	price, err := c.FromISK(product.PriceISK, currency)
+	if err != nil {
+		return err
+	}
+
+	product.DisplayPrice = price
+	product.DisplayCurrency = strings.ToUpper(currency)
+
+	return nil
+}
+
+// normalizeRates uppercases currency codes and removes ISK since it's always 1
+func normalizeRates(rates map[string]float64) map[string]float64 {
+	normalized := make(map[string]float64, len(rates))
+	for currency, rate := range rates {
+		currency = strings.ToUpper(strings.TrimSpace(currency))
+		if currency == BaseCurrency {
+			continue
+		}
+		normalized[currency] = rate
+	}
+
+	return normalized
+}
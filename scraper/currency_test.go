@@ -0,0 +1,67 @@
+package scraper
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCurrencyRates(t *testing.T) {
+	c := &CurrencyRates{Path: filepath.Join(t.TempDir(), "currency-rates.json")}
+
+	// Missing file only supports ISK
+	err := c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ToISK(100, "EUR", true); err == nil {
+		t.Error("Got no error for EUR without rate")
+	}
+
+	err = c.Set(map[string]float64{"eur": 150, "ISK": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Saved rates are loaded again
+	c = &CurrencyRates{Path: c.Path}
+	err = c.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		price       uint
+		currency    string
+		vatIncluded bool
+		isk         uint
+	}{
+		{12990, "ISK", true, 12990},
+		{1000, "ISK", false, 1240},
+		{10000, "EUR", true, 15000},
+		{10000, "eur", false, 18600},
+		{1249, "EUR", true, 1874},
+	}
+
+	for _, test := range tests {
+		isk, err := c.ToISK(test.price, test.currency, test.vatIncluded)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if isk != test.isk {
+			t.Errorf("Got %v, want %v for %v %s", isk, test.isk, test.price, test.currency)
+		}
+	}
+
+	price, err := c.FromISK(15000, "EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price != 100 {
+		t.Errorf("Got %v, want 100", price)
+	}
+
+	if err := c.Set(map[string]float64{"EUR": 0}); err == nil {
+		t.Error("Got no error for zero rate")
+	}
+}
@@ -0,0 +1,114 @@
+package scraper
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidCursor is returned for a cursor that can't be decoded or is for another sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrCursorOffset is returned when a page is asked for with both a cursor and an offset
+var ErrCursorOffset = errors.New("offset can't be used with a cursor")
+
+// Cursor is a keyset pagination position, the sort value and ID of the last row on a page.
+// The next page starts right after it, so rows added or deleted while paging aren't skipped or repeated
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value int64  `json:"v,omitempty"` // number sorts, and times as Unix nanoseconds
+	Text  string `json:"t,omitempty"` // text sorts
+	ID    uint   `json:"id"`
+}
+
+// String returns the cursor as an opaque URL safe string
+func (c *Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor decodes a cursor from its String, empty is no cursor, the first page
+func ParseCursor(s string) (*Cursor, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor Cursor
+	err = json.Unmarshal(b, &cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &cursor, nil
+}
+
+// keysetStmt returns the WHERE condition, and its args, for rows after the one with value and id
+// when ordered by expr and then idColumn, both in the same direction, or only by idColumn when expr is idColumn
+func keysetStmt(expr, idColumn string, desc bool, value interface{}, id uint) (string, []interface{}) {
+	op := ">"
+	if desc {
+		op = "<"
+	}
+
+	if expr == idColumn {
+		return fmt.Sprintf("%s %s ?", idColumn, op), []interface{}{id}
+	}
+
+	stmt := fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", expr, op, expr, idColumn, op)
+	return stmt, []interface{}{value, value, id}
+}
+
+// pageStmt returns the LIMIT clause, and its args, with OFFSET only for the pages without a cursor
+func pageStmt(limit, offset int, cursor *Cursor) (string, []interface{}) {
+	if cursor != nil {
+		return "LIMIT ?", []interface{}{limit}
+	}
+
+	return "LIMIT ? OFFSET ?", []interface{}{limit, offset}
+}
+
+// keysetOrderStmt returns the ORDER BY expression that keysetStmt pages through
+func keysetOrderStmt(expr, idColumn string, desc bool) string {
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+
+	// The ID is already unique, so there's nothing to break ties
+	if expr == idColumn {
+		return fmt.Sprintf("%s %s", idColumn, dir)
+	}
+
+	return fmt.Sprintf("%s %s, %s %s", expr, dir, idColumn, dir)
+}
+
+// keysetPage returns the cursor to the page after products,
+// nil when products isn't a full page since there are no more
+func keysetPage(products []ProductPriceDiff, limit int, sort string) *Cursor {
+	if limit <= 0 || len(products) < limit {
+		return nil
+	}
+
+	last := products[len(products)-1]
+	return &Cursor{Sort: sort, Value: last.CursorValue, ID: last.ID}
+}
+
+// checkCursor returns ErrInvalidCursor if cursor was made for another sort,
+// and ErrCursorOffset if there's an offset too since pages after a cursor are only found by the keyset
+func checkCursor(cursor *Cursor, sort string, offset int) error {
+	if cursor != nil && cursor.Sort != sort {
+		return fmt.Errorf("%w, it's for %s and not %s", ErrInvalidCursor, cursor.Sort, sort)
+	}
+
+	if cursor != nil && offset != 0 {
+		return ErrCursorOffset
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package scraper
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Supported databases, same as gorm Dialector names
+const (
+	DialectMySQL    string = "mysql"
+	DialectPostgres string = "postgres"
+	DialectSQLite   string = "sqlite"
+)
+
+// NewDialector returns the gorm dialector for driver, mysql, postgres or sqlite,
+// connecting to 127.0.0.1:port. For sqlite name is the database file path
+func NewDialector(driver, user, password, name, port string) (gorm.Dialector, error) {
+	switch driver {
+	case DialectMySQL, "":
+		dsn := fmt.Sprintf("%s:%s@tcp(127.0.0.1:%s)/%s?charset=utf8mb4&parseTime=True&loc=Local", user, password, port, name)
+		return mysql.Open(dsn), nil
+	case DialectPostgres:
+		dsn := fmt.Sprintf("host=127.0.0.1 user=%s password=%s dbname=%s port=%s sslmode=disable", user, password, name, port)
+		return postgres.Open(dsn), nil
+	case DialectSQLite:
+		return sqlite.Open(name), nil
+	}
+
+	return nil, fmt.Errorf("unsupported SQL driver %s", driver)
+}
+
+// dialect returns which database db is
+func (db *SQL) dialect() string {
+	return db.Dialector.Name()
+}
+
+// orderByIDsStmt returns an ORDER BY expression, and its args, that keeps the order of ids
+func (db *SQL) orderByIDsStmt(ids []uint) (string, []interface{}) {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+
+	// ex. FIELD(id, 5,4,3,1,6)
+	if db.dialect() == DialectMySQL {
+		params := strings.Repeat("?,", len(ids))
+		return fmt.Sprintf("FIELD(id, %s)", strings.TrimSuffix(params, ",")), args
+	}
+
+	// ex. CASE id WHEN 5 THEN 0 WHEN 4 THEN 1 ... END
+	var sb strings.Builder
+	sb.WriteString("CASE id")
+	for i := range ids {
+		sb.WriteString(fmt.Sprintf(" WHEN ? THEN %d", i))
+	}
+	sb.WriteString(" END")
+
+	return sb.String(), args
+}
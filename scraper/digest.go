@@ -0,0 +1,171 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"bitbucket.org/hilmarp/price-scraper/metrics"
+	"bitbucket.org/hilmarp/price-scraper/notify"
+	"bitbucket.org/hilmarp/price-scraper/templates"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+// Digest preferences, how often an email gets watcher alerts
+const (
+	DigestImmediate string = "immediate"
+	DigestDaily     string = "daily"
+	DigestWeekly    string = "weekly"
+)
+
+// NotificationDigest is the notification kind of digest emails
+const NotificationDigest string = "digest"
+
+// SetDigest sets how often the email gets watcher alerts, immediately when digest is empty
+func (p *EmailPreference) SetDigest(digest string) error {
+	switch digest {
+	case "":
+		p.Digest = DigestImmediate
+	case DigestImmediate, DigestDaily, DigestWeekly:
+		p.Digest = digest
+	default:
+		return fmt.Errorf("unknown digest %s, use %s, %s or %s", digest, DigestImmediate, DigestDaily, DigestWeekly)
+	}
+
+	return nil
+}
+
+// PriceDiff returns how much the price dropped in a price drop event
+func (e WatchEvent) PriceDiff() uint {
+	if e.PriceNew > e.PriceOld {
+		return 0
+	}
+
+	return e.PriceOld - e.PriceNew
+}
+
+// emailDigest returns the digest preference of email, immediate when it has none
+func (s *Scraper) emailDigest(email string) (string, error) {
+	preference, err := s.DB.GetEmailPreference(email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return DigestImmediate, nil
+		}
+		return "", err
+	}
+
+	return preference.Digest, nil
+}
+
+// StartDigestSender sends daily digests every morning and weekly digests on Monday mornings
+func (s *Scraper) StartDigestSender() error {
+	c := cron.New()
+	c.AddFunc("0 8 * * *", func() { // At 08:00
+		metrics.DigestSendersRunning.Inc()
+		defer metrics.DigestSendersRunning.Dec()
+
+		// Immediate are alerts left waiting when an email changed from a digest
+		for _, digest := range []string{DigestDaily, DigestImmediate} {
+			err := s.SendDigests(digest)
+			if err != nil {
+				log.Print(err)
+			}
+		}
+	})
+	c.AddFunc("0 8 * * 1", func() { // At 08:00 on Monday
+		metrics.DigestSendersRunning.Inc()
+		defer metrics.DigestSendersRunning.Dec()
+
+		err := s.SendDigests(DigestWeekly)
+		if err != nil {
+			log.Print(err)
+		}
+	})
+	c.Start()
+
+	return nil
+}
+
+// SendDigests adds one email with all waiting alerts to the outbox for every email with the digest preference,
+// an email that fails is logged and tried again with the next digest
+func (s *Scraper) SendDigests(digest string) error {
+	emails, err := s.DB.GetDigestEmails(digest)
+	if err != nil {
+		return err
+	}
+
+	for _, email := range *emails {
+		events, err := s.DB.GetWatchEventsByEmail(email)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		if len(*events) == 0 {
+			continue
+		}
+
+		notifications, err := s.digestNotifications(digest, email, *events)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		var ids []uint
+		for _, event := range *events {
+			ids = append(ids, event.ID)
+		}
+
+		err = s.DB.CreateDigestNotifications(notifications, ids)
+		if err != nil {
+			log.Printf("Error sending %s digest to %s: %s\n", digest, email, err)
+			continue
+		}
+
+		metrics.WatcherDigestsSent.Inc()
+	}
+
+	return nil
+}
+
+// digestNotifications renders the digest of events for email in the locale of the latest event
+// and returns it as a notification for each channel, with a new unsubscribe token for each watch
+func (s *Scraper) digestNotifications(digest, email string, events []WatchEvent) ([]*Notification, error) {
+	type digestEvent struct {
+		WatchEvent
+		UnsubscribeToken string
+	}
+	type digestEmail struct {
+		Digest string
+		Events []digestEvent
+	}
+
+	tokens := make(map[uint]string)
+	digestEvents := make([]digestEvent, len(events))
+	for i, event := range events {
+		token, ok := tokens[event.WatchProductID]
+		if !ok {
+			var err error
+			token, err = s.unsubscribeToken(event.WatchProductID)
+			if err != nil {
+				return nil, err
+			}
+			tokens[event.WatchProductID] = token
+		}
+		digestEvents[i] = digestEvent{WatchEvent: event, UnsubscribeToken: token}
+	}
+
+	rendered, err := templates.Render(events[len(events)-1].Locale, "watch-digest", digestEmail{Digest: digest, Events: digestEvents})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.newNotifications(NotificationDigest, notify.Message{
+		To:      email,
+		Subject: rendered.Subject,
+		HTML:    rendered.HTML,
+		Text:    rendered.Text,
+		URL:     "https://verdfra.is",
+	}), nil
+}
@@ -0,0 +1,196 @@
+package scraper
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+	"bitbucket.org/hilmarp/price-scraper/notify"
+)
+
+func TestEmailPreferenceSetDigest(t *testing.T) {
+	tests := []struct {
+		digest string
+		want   string
+		err    bool
+	}{
+		{"", DigestImmediate, false},
+		{DigestDaily, DigestDaily, false},
+		{DigestWeekly, DigestWeekly, false},
+		{"monthly", "", true},
+	}
+
+	for _, test := range tests {
+		var p EmailPreference
+		err := p.SetDigest(test.digest)
+		if (err != nil) != test.err {
+			t.Errorf("SetDigest(%q) error = %v, want error %v", test.digest, err, test.err)
+			continue
+		}
+		if p.Digest != test.want {
+			t.Errorf("SetDigest(%q) = %q, want %q", test.digest, p.Digest, test.want)
+		}
+	}
+}
+
+func TestSendDigests(t *testing.T) {
+	db := newTestSQL(t)
+	s := &Scraper{DB: db, Channels: []notify.Channel{{Name: "email", Notifier: &notify.Log{}}}}
+
+	for email, digest := range map[string]string{"a@b.is": DigestDaily, "c@d.is": DigestWeekly} {
+		err := db.UpdateOrCreateEmailPreference(&EmailPreference{Email: email, Digest: digest})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	for _, event := range []WatchEvent{
+		{Email: "a@b.is", WatchProductID: 1, ProductID: 1, Kind: AlertPriceDrop, ProductTitle: "Sjónvarp", PriceOld: 5000, PriceNew: 4000, Date: now},
+		{Email: "a@b.is", WatchProductID: 2, ProductID: 2, Kind: AlertBackInStock, ProductTitle: "Ofn", Location: "Lindir", Date: now},
+		{Email: "c@d.is", WatchProductID: 3, ProductID: 1, Kind: AlertPriceDrop, ProductTitle: "Sjónvarp", PriceOld: 5000, PriceNew: 4000, Locale: "en", Date: now},
+		{Email: "e@f.is", WatchProductID: 4, ProductID: 1, Kind: AlertPriceDrop, ProductTitle: "Sjónvarp", PriceOld: 5000, PriceNew: 4000, Date: now},
+	} {
+		event := event
+		err := db.CreateWatchEvent(&event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No preference is immediate
+	emails, err := db.GetDigestEmails(DigestImmediate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*emails) != 1 || (*emails)[0] != "e@f.is" {
+		t.Errorf("Got immediate emails %v, want e@f.is", *emails)
+	}
+
+	err = s.SendDigests(DigestDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifications, err := db.GetDueNotifications(10, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*notifications) != 1 {
+		t.Fatalf("Got %d notifications, want 1 daily digest", len(*notifications))
+	}
+	n := (*notifications)[0]
+	if n.Recipient != "a@b.is" || n.Kind != NotificationDigest || n.Subject != "Dagleg samantekt - 2 tilkynningar" {
+		t.Errorf("Got %s %s %q", n.Recipient, n.Kind, n.Subject)
+	}
+	for _, want := range []string{"Sjónvarp", "Lækkun um 1.000 kr.", "Ofn", "í Lindir"} {
+		if !strings.Contains(n.HTML, want) {
+			t.Errorf("Digest has no %q", want)
+		}
+	}
+
+	// Each watch gets its own unsubscribe link, only the token hashes are stored
+	links := regexp.MustCompile(`/watch/unsubscribe/([\w-]+)`).FindAllStringSubmatch(n.Text, -1)
+	if len(links) != 2 {
+		t.Fatalf("Got unsubscribe links %v, want 2", links)
+	}
+	for i, link := range links {
+		var token UnsubscribeToken
+		err := db.Where("hash = ?", formatters.HashToken(link[1])).First(&token).Error
+		if err != nil || token.WatchProductID != uint(i+1) {
+			t.Errorf("Got token %+v, %v for link %d, want watch %d", token, err, i, i+1)
+		}
+	}
+
+	// Sent events are gone, the weekly digest still waits
+	events, err := db.GetWatchEventsByEmail("a@b.is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*events) != 0 {
+		t.Errorf("Got %d events after the digest, want none", len(*events))
+	}
+	events, err = db.GetWatchEventsByEmail("c@d.is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*events) != 1 {
+		t.Errorf("Got %d weekly events, want 1", len(*events))
+	}
+
+	// The weekly digest is in the locale of the event
+	err = s.SendDigests(DigestWeekly)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifications, err = db.GetDueNotifications(10, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*notifications) != 2 {
+		t.Fatalf("Got %d notifications, want the weekly digest too", len(*notifications))
+	}
+	n = (*notifications)[1]
+	if n.Recipient != "c@d.is" || n.Subject != "Weekly summary - 1 notifications" {
+		t.Errorf("Got %s %q", n.Recipient, n.Subject)
+	}
+	if !strings.Contains(n.HTML, "Down by ISK 1,000.") {
+		t.Errorf("Weekly digest has no English price drop")
+	}
+}
+
+// digestDB fails to add the digest of fail to the outbox
+type digestDB struct {
+	*SQL
+	fail string
+}
+
+func (db *digestDB) CreateDigestNotifications(notifications []*Notification, eventIDs []uint) error {
+	if len(notifications) > 0 && notifications[0].Recipient == db.fail {
+		return errors.New("database down")
+	}
+	return db.SQL.CreateDigestNotifications(notifications, eventIDs)
+}
+
+func TestSendDigestsFailure(t *testing.T) {
+	db := newTestSQL(t)
+	s := &Scraper{DB: &digestDB{SQL: db, fail: "a@b.is"}, Channels: []notify.Channel{{Name: "email", Notifier: &notify.Log{}}}}
+
+	for _, email := range []string{"a@b.is", "c@d.is"} {
+		err := db.UpdateOrCreateEmailPreference(&EmailPreference{Email: email, Digest: DigestDaily})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.CreateWatchEvent(&WatchEvent{Email: email, ProductID: 1, Kind: AlertPriceDrop, ProductTitle: "Sjónvarp", PriceOld: 5000, PriceNew: 4000, Date: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One email failing doesn't stop the others
+	err := s.SendDigests(DigestDaily)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifications, err := db.GetDueNotifications(10, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*notifications) != 1 || (*notifications)[0].Recipient != "c@d.is" {
+		t.Fatalf("Got notifications %v, want the digest to c@d.is", *notifications)
+	}
+
+	// The events of the failed digest wait for the next one
+	events, err := db.GetWatchEventsByEmail("a@b.is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*events) != 1 {
+		t.Errorf("Got %d events of the failed digest, want 1", len(*events))
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"sync"
 
 	"bitbucket.org/hilmarp/price-scraper/formatters"
 	"github.com/olivere/elastic/v7"
@@ -12,20 +13,80 @@ import (
 
 const searchIndex string = "price_search"
 
+// stockMapping is the mapping of the stock fields
+const stockMapping string = `{
+	"properties": {
+		"InStock": {
+			"type": "boolean"
+		},
+		"InStockAt": {
+			"type": "keyword"
+		}
+	}
+}`
+
+// SearchFilter narrows search results, the zero value matches everything
+type SearchFilter struct {
+	InStock  *bool  // In stock at any location, or at Location when it's set
+	Location string // In stock at this location, unless InStock is false
+}
+
+// query wraps query with the filter
+func (f SearchFilter) query(query elastic.Query) elastic.Query {
+	if f.InStock == nil && f.Location == "" {
+		return query
+	}
+
+	var stock elastic.Query = elastic.NewTermQuery("InStock", true)
+	if f.Location != "" {
+		stock = elastic.NewTermQuery("InStockAt", f.Location)
+	}
+
+	boolQuery := elastic.NewBoolQuery().Must(query)
+	if f.InStock != nil && !*f.InStock {
+		return boolQuery.MustNot(stock)
+	}
+	return boolQuery.Filter(stock)
+}
+
 // Elasticsearch handles ES operations
 type Elasticsearch struct {
-	Client *elastic.Client
+	Client       *elastic.Client
+	indexMu      sync.Mutex
+	indexCreated bool
 }
 
-// CreateSearchIndex will only create the index if it doesn't exist
+// CreateSearchIndex will only create the index if it doesn't exist,
+// it's only checked once per Elasticsearch instance
 func (es *Elasticsearch) CreateSearchIndex() error {
+	es.indexMu.Lock()
+	defer es.indexMu.Unlock()
+
+	if es.indexCreated {
+		return nil
+	}
+
+	err := es.createSearchIndex()
+	if err != nil {
+		return err
+	}
+
+	es.indexCreated = true
+
+	return nil
+}
+
+func (es *Elasticsearch) createSearchIndex() error {
 	exists, err := es.Client.IndexExists(searchIndex).Do(context.TODO())
 	if err != nil {
 		return err
 	}
 
+	// Fields added after the index was created, ES can only add new fields to a mapping.
+	// Documents indexed before don't have them until they are scraped again, run scraper reindex to set them now
 	if exists {
-		return nil
+		_, err := es.Client.PutMapping().Index(searchIndex).BodyString(stockMapping).Do(context.TODO())
+		return err
 	}
 
 	mapping := `{
@@ -68,8 +129,29 @@ func (es *Elasticsearch) CreateSearchIndex() error {
 				"Price": {
 					"type": "long"
 				},
+				"PriceISK": {
+					"type": "long"
+				},
+				"Currency": {
+					"type": "keyword"
+				},
+				"ListPrice": {
+					"type": "long"
+				},
+				"ShippingCost": {
+					"type": "long"
+				},
+				"TotalPrice": {
+					"type": "long"
+				},
 				"OnSale": {
 					"type": "boolean"
+				},
+				"InStock": {
+					"type": "boolean"
+				},
+				"InStockAt": {
+					"type": "keyword"
 				}
 			}
 		}
@@ -106,6 +188,40 @@ func (es *Elasticsearch) UpdateOrIndexSearchProduct(product *SearchProduct) erro
 	return nil
 }
 
+// BulkIndexSearchProducts adds or updates many products with one bulk request
+func (es *Elasticsearch) BulkIndexSearchProducts(products []*SearchProduct) error {
+	if len(products) == 0 {
+		return nil
+	}
+
+	err := es.CreateSearchIndex()
+	if err != nil {
+		return err
+	}
+
+	bulk := es.Client.Bulk().Index(searchIndex)
+	for _, product := range products {
+		bulk.Add(elastic.NewBulkIndexRequest().
+			Id(strconv.Itoa(int(product.ID))).
+			Doc(product))
+	}
+
+	res, err := bulk.Do(context.TODO())
+	if err != nil {
+		return err
+	}
+
+	if failed := res.Failed(); len(failed) > 0 {
+		reason := "unknown"
+		if failed[0].Error != nil {
+			reason = failed[0].Error.Reason
+		}
+		return fmt.Errorf("%d of %d products failed to index, first error: %s", len(failed), len(products), reason)
+	}
+
+	return nil
+}
+
 // DeleteSearchProductByID deletes a product from ES
 func (es *Elasticsearch) DeleteSearchProductByID(id uint) error {
 	_, err := es.Client.Delete().
@@ -119,9 +235,9 @@ func (es *Elasticsearch) DeleteSearchProductByID(id uint) error {
 	return nil
 }
 
-// SearchByURL returns products that match URL
-func (es *Elasticsearch) SearchByURL(URL string) (*[]SearchProduct, error) {
-	query := elastic.NewTermQuery("URL", URL)
+// SearchByURL returns products that match URL and filter
+func (es *Elasticsearch) SearchByURL(URL string, filter SearchFilter) (*[]SearchProduct, error) {
+	query := filter.query(elastic.NewTermQuery("URL", URL))
 
 	searchResult, err := es.Client.Search().
 		Index(searchIndex).
@@ -142,10 +258,10 @@ func (es *Elasticsearch) SearchByURL(URL string) (*[]SearchProduct, error) {
 	return &esProducts, nil
 }
 
-// SearchForProduct will return products from ES which match value
-func (es *Elasticsearch) SearchForProduct(value string, limit, offset int) (*[]SearchProduct, error) {
+// SearchForProduct will return products from ES which match value and filter
+func (es *Elasticsearch) SearchForProduct(value string, filter SearchFilter, limit, offset int) (*[]SearchProduct, error) {
 	if formatters.IsValidURL(value) {
-		products, err := es.SearchByURL(value)
+		products, err := es.SearchByURL(value, filter)
 		if err != nil {
 			return nil, err
 		}
@@ -153,7 +269,7 @@ func (es *Elasticsearch) SearchForProduct(value string, limit, offset int) (*[]S
 		return products, nil
 	}
 
-	query := elastic.NewMultiMatchQuery(value, "ProductCode", "Title", "Description")
+	query := filter.query(elastic.NewMultiMatchQuery(value, "ProductCode", "Title", "Description"))
 
 	searchResult, err := es.Client.Search().
 		Index(searchIndex).
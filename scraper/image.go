@@ -10,7 +10,7 @@ import (
 	"bitbucket.org/hilmarp/price-scraper/formatters"
 )
 
-func saveAndSetProductImages(db *SQL, scrapedProduct *Product) error {
+func saveAndSetProductImages(db ProductRepository, scrapedProduct *Product) error {
 	if len(scrapedProduct.AllImgURLs) > 0 {
 		scrapedProduct.MainImgURL = scrapedProduct.AllImgURLs[0].URL
 	}
@@ -0,0 +1,221 @@
+package scraper
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a versioned schema change, Down is nil when it can't be rolled back.
+// Migrations must not use the current models, since they change, but their own copy
+type Migration struct {
+	Version  uint
+	Name     string
+	Up       func(tx *gorm.DB) error
+	Down     func(tx *gorm.DB) error
+	Dialects []string // Only run on these databases, empty for all, ex. MySQL data fixes
+}
+
+// runsOn returns if m should run on dialect
+func (m Migration) runsOn(dialect string) bool {
+	if len(m.Dialects) == 0 {
+		return true
+	}
+
+	for _, d := range m.Dialects {
+		if d == dialect {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SchemaMigration is an applied migration
+type SchemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+// MigrationStatus is a migration and when it was applied, AppliedAt is nil if pending
+type MigrationStatus struct {
+	Version   uint
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrate will apply all pending migrations
+func (db *SQL) Migrate() error {
+	_, err := db.MigrateUp()
+	return err
+}
+
+// MigrateUp applies pending migrations in order and returns the ones applied
+func (db *SQL) MigrateUp() ([]Migration, error) {
+	applied, err := db.getAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range getMigrations() {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		// Migrations for other databases are recorded as applied without running
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if m.runsOn(db.dialect()) {
+				err := m.Up(tx)
+				if err != nil {
+					return err
+				}
+			}
+
+			result := tx.Create(&SchemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			})
+			return result.Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("error applying migration %d %s: %w", m.Version, m.Name, err)
+		}
+
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// MigrateDown rolls back the last steps applied migrations and returns the ones rolled back
+func (db *SQL) MigrateDown(steps int) ([]Migration, error) {
+	applied, err := db.getAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := getMigrations()
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		if m.Down == nil && m.runsOn(db.dialect()) {
+			return done, fmt.Errorf("migration %d %s can't be rolled back", m.Version, m.Name)
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if m.runsOn(db.dialect()) {
+				err := m.Down(tx)
+				if err != nil {
+					return err
+				}
+			}
+
+			result := tx.Where("version = ?", m.Version).Delete(&SchemaMigration{})
+			return result.Error
+		})
+		if err != nil {
+			return done, fmt.Errorf("error rolling back migration %d %s: %w", m.Version, m.Name, err)
+		}
+
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// GetMigrationStatus returns all migrations, oldest first, and when they were applied
+func (db *SQL) GetMigrationStatus() (*[]MigrationStatus, error) {
+	applied, err := db.getAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range getMigrations() {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			appliedAt := a.AppliedAt
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+
+	return &statuses, nil
+}
+
+// getAppliedMigrations returns applied migrations by version,
+// and creates the schema_migrations table if it doesn't exist
+func (db *SQL) getAppliedMigrations() (map[uint]SchemaMigration, error) {
+	err := db.AutoMigrate(&SchemaMigration{})
+	if err != nil {
+		return nil, err
+	}
+
+	var schemaMigrations []SchemaMigration
+	result := db.Find(&schemaMigrations)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[uint]SchemaMigration, len(schemaMigrations))
+	for _, m := range schemaMigrations {
+		applied[m.Version] = m
+	}
+
+	return applied, nil
+}
+
+// getMigrations returns all migrations sorted by version
+func getMigrations() []Migration {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+
+	return sorted
+}
+
+// execSQL runs statements separated by semicolons at line end, since the driver
+// doesn't allow multiple statements in one query
+func execSQL(tx *gorm.DB, sql string) error {
+	for _, stmt := range splitSQL(sql) {
+		result := tx.Exec(stmt)
+		if err := result.Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitSQL splits sql into statements, without comments and empty statements
+func splitSQL(sql string) []string {
+	var lines []string
+	for _, line := range strings.Split(sql, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	var stmts []string
+	for _, stmt := range strings.Split(strings.Join(lines, "\n"), ";\n") {
+		stmt = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(stmt), ";"))
+		if stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+
+	return stmts
+}
@@ -0,0 +1,326 @@
+package scraper
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+)
+
+func TestMigrations(t *testing.T) {
+	names := make(map[string]bool)
+	for i, m := range migrations {
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			t.Errorf("Migration %d %s should have a higher version than %d", m.Version, m.Name, migrations[i-1].Version)
+		}
+		if names[m.Name] {
+			t.Errorf("Migration name %s is not unique", m.Name)
+		}
+		names[m.Name] = true
+		if m.Up == nil {
+			t.Errorf("Migration %d %s has no Up", m.Version, m.Name)
+		}
+	}
+}
+
+func TestSplitSQL(t *testing.T) {
+	sql := `
+-- Comment; with semicolon
+
+UPDATE products SET url = 'a;b' WHERE id = 1;
+
+DELETE FROM products
+WHERE id = 2;
+`
+	want := []string{
+		"UPDATE products SET url = 'a;b' WHERE id = 1",
+		"DELETE FROM products\nWHERE id = 2",
+	}
+
+	got := splitSQL(sql)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+
+	counts := map[string]int{
+		sqlDeleteOrphanRows:          8,
+		sqlRemoveWWWFromURLs:         10,
+		sqlRemoveVidFromHeimkaupURLs: 10,
+	}
+	for sql, count := range counts {
+		if got := len(splitSQL(sql)); got != count {
+			t.Errorf("Got %d statements, want %d", got, count)
+		}
+	}
+}
+
+func TestMigrateDownUp(t *testing.T) {
+	db := newTestSQL(t)
+
+	statuses, err := db.GetMigrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range *statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("Migration %d %s is pending", status.Version, status.Name)
+		}
+	}
+
+	// Rolls back all the way, MySQL data fixes are skipped on SQLite
+	rolledBack, err := db.MigrateDown(len(migrations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rolledBack) != len(migrations) {
+		t.Errorf("Got %d rolled back, want %d", len(rolledBack), len(migrations))
+	}
+	if db.Migrator().HasTable("products") {
+		t.Error("Got products table after rollback")
+	}
+
+	applied, err := db.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Errorf("Got %d applied, want %d", len(applied), len(migrations))
+	}
+	if !db.Migrator().HasTable("products") {
+		t.Error("Got no products table after apply")
+	}
+}
+
+func TestHashTokensUp(t *testing.T) {
+	db := newTestSQL(t)
+
+	// Back to when the unsubscribe token was on the watch
+	_, err := db.MigrateDown(len(migrations) - 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CreateWatchProduct(&WatchProduct{Email: "a@b.is", VerifyHash: "verify"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CreateWatchEvent(&WatchEvent{Email: "a@b.is", WatchProductID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"watch_products", "watch_events"} {
+		err = db.Table(table).Where("id = ?", 1).Update("unsubscribe_hash", "unsubscribe").Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = db.CreateSession(&Session{UserID: 1, Token: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens from before the migration are plain
+	err = hashTokensUp(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.GetWatchProductByVerifyHash(formatters.HashToken("verify"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var watchTokens, eventTokens []string
+	err = db.Table("watch_products").Pluck("unsubscribe_hash", &watchTokens).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(watchTokens) != 1 || watchTokens[0] == "unsubscribe" || len(watchTokens[0]) != 43 {
+		t.Errorf("Got unsubscribe tokens %q, want a new random token", watchTokens)
+	}
+	err = db.Table("watch_events").Pluck("unsubscribe_hash", &eventTokens).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(eventTokens, watchTokens) {
+		t.Errorf("Got event tokens %q, want the new token of the watch %q", eventTokens, watchTokens)
+	}
+
+	var session Session
+	err = db.Where("token = ?", formatters.HashToken("token")).First(&session).Error
+	if err != nil {
+		t.Errorf("Got %v, want the session token hashed", err)
+	}
+}
+
+func TestCreateUnsubscribeTokensUp(t *testing.T) {
+	db := newTestSQL(t)
+
+	_, err := db.MigrateDown(len(migrations) - 19)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CreateWatchProduct(&WatchProduct{Email: "a@b.is", VerifyHash: "verify"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Table("watch_products").Where("id = ?", 1).Update("unsubscribe_hash", "unsubscribe").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = db.MigrateUp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Links in alerts that were already sent still work
+	watch, err := db.GetWatchProductByUnsubscribeHash(formatters.HashToken("unsubscribe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watch.ID != 1 {
+		t.Errorf("Got watch %d, want 1", watch.ID)
+	}
+
+	for _, model := range []interface{}{&watchProductUnsubscribeHash{}, &watchEventUnsubscribeHash{}} {
+		if db.Migrator().HasColumn(model, "UnsubscribeHash") {
+			t.Errorf("Got the plain unsubscribe_hash column in %T", model)
+		}
+	}
+}
+
+func TestAddProductBrandUp(t *testing.T) {
+	db := newTestSQL(t)
+
+	specs := [][]Spec{
+		{{Key: "Litur", Value: "Svartur"}, {Key: " Framleiðandi ", Value: " Apple "}},
+		{{Key: "Merki", Value: ""}, {Key: "Brand", Value: "LG"}},
+		{{Key: "Litur", Value: "Hvítur"}},
+		{{Key: "Vörumerki", Value: "IBM"}},
+	}
+	brands := []string{"", "", "", "Lenovo"}
+	var products []*Product
+	for i := range specs {
+		product := testProduct("elko.is", fmt.Sprint(i), 1000)
+		product.Specs = specs[i]
+		product.Brand = brands[i]
+		err := db.Create(product).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		products = append(products, product)
+	}
+
+	// Products from before the migration have no brand
+	err := addProductBrandUp(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"Apple", "LG", "", "Lenovo"}
+	for i, product := range products {
+		var brand string
+		err := db.Model(&Product{}).Where("id = ?", product.ID).Pluck("brand", &brand).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if brand != want[i] {
+			t.Errorf("Got brand %q for product %d, want %q", brand, i, want[i])
+		}
+	}
+}
+
+func TestLowercaseEmailsUp(t *testing.T) {
+	db := newTestSQL(t)
+
+	// Stored before emails were normalized
+	err := db.CreateWatchProduct(&WatchProduct{Email: " A@b.is", ProductID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, preference := range []EmailPreference{{Email: "a@b.is", Digest: DigestDaily}, {Email: "A@B.is", Digest: DigestWeekly}, {Email: "C@d.is", Digest: DigestWeekly}} {
+		preference := preference
+		err := db.UpdateOrCreateEmailPreference(&preference)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = lowercaseEmailsUp(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	watches, err := db.GetWatchProductsByEmail("a@b.is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*watches) != 1 {
+		t.Errorf("Got %d watches of a@b.is, want 1", len(*watches))
+	}
+
+	// The preference that was normalized already is kept
+	for email, want := range map[string]string{"a@b.is": DigestDaily, "c@d.is": DigestWeekly} {
+		preference, err := db.GetEmailPreference(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if preference.Digest != want {
+			t.Errorf("Got %s digest of %s, want %s", preference.Digest, email, want)
+		}
+	}
+
+	var count int64
+	err = db.Model(&EmailPreference{}).Count(&count).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("Got %d email preferences, want 2", count)
+	}
+}
+
+func TestStoreMinorUnitsUp(t *testing.T) {
+	db := newTestSQL(t)
+
+	// Stored in whole euros before minor units
+	eur := testProduct("amazon.de", "vara", 12)
+	eur.Currency = "EUR"
+	isk := testProduct("elko.is", "vara", 12990)
+	for _, product := range []*Product{eur, isk} {
+		_, err := db.UpdateOrCreateProduct(product)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	result := db.Model(&Price{}).Where("product_id = ?", eur.ID).Update("currency", "EUR")
+	if err := result.Error; err != nil {
+		t.Fatal(err)
+	}
+
+	err := storeMinorUnitsUp(db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		url   string
+		price uint
+	}{{eur.URL, 1200}, {isk.URL, 12990}} {
+		product, err := db.GetProductByURL(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var price Price
+		err = db.Where("product_id = ?", product.ID).First(&price).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if product.Price != test.price || product.ListPrice != test.price || price.Price != test.price {
+			t.Errorf("Got %s prices %d, %d, %d, want %d", test.url, product.Price, product.ListPrice, price.Price, test.price)
+		}
+	}
+}
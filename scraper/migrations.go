@@ -0,0 +1,974 @@
+package scraper
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// migrations are all schema changes, add new ones at the end with a higher version
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "initial_schema",
+		Up:      initialSchemaUp,
+		Down:    initialSchemaDown,
+	},
+	{
+		Version: 2,
+		Name:    "delete_orphan_rows",
+		Up: func(tx *gorm.DB) error {
+			return execSQL(tx, sqlDeleteOrphanRows)
+		},
+		Dialects: []string{DialectMySQL},
+	},
+	{
+		Version: 3,
+		Name:    "remove_www_from_urls",
+		Up: func(tx *gorm.DB) error {
+			return execSQL(tx, sqlRemoveWWWFromURLs)
+		},
+		Dialects: []string{DialectMySQL},
+	},
+	{
+		Version: 4,
+		Name:    "remove_vid_from_heimkaup_urls",
+		Up: func(tx *gorm.DB) error {
+			return execSQL(tx, sqlRemoveVidFromHeimkaupURLs)
+		},
+		Dialects: []string{DialectMySQL},
+	},
+	{
+		Version: 5,
+		Name:    "remove_add_to_cart_from_nexus_urls",
+		Up: func(tx *gorm.DB) error {
+			return execSQL(tx, sqlRemoveAddToCartFromNexusURLs)
+		},
+		Dialects: []string{DialectMySQL},
+	},
+	{
+		Version: 6,
+		Name:    "backfill_isk_currency",
+		Up: func(tx *gorm.DB) error {
+			return execSQL(tx, sqlBackfillISKCurrency)
+		},
+		Dialects: []string{DialectMySQL},
+	},
+	{
+		Version: 7,
+		Name:    "add_product_brand",
+		Up:      addProductBrandUp,
+		Down:    addProductBrandDown,
+	},
+	{
+		Version: 8,
+		Name:    "create_stock_changes",
+		Up:      createStockChangesUp,
+		Down:    createStockChangesDown,
+	},
+	{
+		Version: 9,
+		Name:    "add_watch_product_alerts",
+		Up:      addWatchProductAlertsUp,
+		Down:    addWatchProductAlertsDown,
+	},
+	{
+		Version: 10,
+		Name:    "add_watch_product_thresholds",
+		Up:      addWatchProductThresholdsUp,
+		Down:    addWatchProductThresholdsDown,
+	},
+	{
+		Version: 11,
+		Name:    "create_notifications",
+		Up:      createNotificationsUp,
+		Down:    createNotificationsDown,
+	},
+	{
+		Version: 12,
+		Name:    "create_watch_digests",
+		Up:      createWatchDigestsUp,
+		Down:    createWatchDigestsDown,
+	},
+	{
+		Version: 13,
+		Name:    "create_users",
+		Up:      createUsersUp,
+		Down:    createUsersDown,
+	},
+	{
+		Version: 14,
+		Name:    "hash_tokens",
+		Up:      hashTokensUp,
+		Down:    hashTokensDown,
+	},
+	{
+		Version: 15,
+		Name:    "add_locales",
+		Up:      addLocalesUp,
+		Down:    addLocalesDown,
+	},
+	{
+		Version: 16,
+		Name:    "create_push_subscriptions",
+		Up:      createPushSubscriptionsUp,
+		Down:    createPushSubscriptionsDown,
+	},
+	{
+		Version: 17,
+		Name:    "add_notification_channel",
+		Up:      addNotificationChannelUp,
+		Down:    addNotificationChannelDown,
+	},
+	{
+		Version: 18,
+		Name:    "lowercase_emails",
+		Up:      lowercaseEmailsUp,
+		Down:    lowercaseEmailsDown,
+	},
+	{
+		Version: 19,
+		Name:    "store_minor_units",
+		Up:      storeMinorUnitsUp,
+		Down:    storeMinorUnitsDown,
+	},
+	{
+		Version: 20,
+		Name:    "create_unsubscribe_tokens",
+		Up:      createUnsubscribeTokensUp,
+		Down:    createUnsubscribeTokensDown,
+	},
+}
+
+// initialSchemaUp creates the schema as it was when migrations were added,
+// on databases created by AutoMigrate before that it only adds what's missing
+func initialSchemaUp(tx *gorm.DB) error {
+	type Price struct {
+		gorm.Model
+		Price       uint
+		ListPrice   uint
+		PriceISK    uint
+		Currency    string `gorm:"size:3"`
+		VATIncluded bool
+		Date        time.Time
+		ProductID   uint `gorm:"index"`
+	}
+
+	type Image struct {
+		gorm.Model
+		URL         string
+		OriginalURL string
+		ProductID   uint `gorm:"index"`
+	}
+
+	type Stock struct {
+		gorm.Model
+		Location  string
+		InStock   bool
+		ProductID uint `gorm:"index"`
+	}
+
+	type Spec struct {
+		gorm.Model
+		Key       string
+		Value     string
+		ProductID uint `gorm:"index"`
+	}
+
+	type Category struct {
+		gorm.Model
+		Name      string
+		Slug      string
+		Parent    string
+		ProductID uint `gorm:"index"`
+	}
+
+	type Product struct {
+		gorm.Model
+		Source         string `gorm:"index:idx_products_source_product_code"`
+		ProductCode    string `gorm:"index:idx_products_source_product_code"`
+		Slug           string `gorm:"unique;size:255"`
+		URL            string `gorm:"unique"`
+		Title          string
+		Description    string `gorm:"type:text"`
+		MainImgURL     string
+		Price          uint
+		PriceISK       uint   `gorm:"index"`
+		Currency       string `gorm:"size:3"`
+		VATIncluded    bool
+		ListPrice      uint
+		Discount       uint
+		Unit           string `gorm:"size:8;index"`
+		UnitQuantity   float64
+		UnitPrice      uint `gorm:"index"`
+		OnSale         bool
+		SuspiciousSale bool `gorm:"index"`
+		ReferencePrice uint
+		ShippingCost   uint
+		TotalPrice     uint `gorm:"index"`
+		FreePickup     bool
+		Specs          []Spec
+		Stocks         []Stock
+		AllImgURLs     []Image
+		Prices         []Price
+		Categories     []Category
+	}
+
+	type UniqueCategory struct {
+		gorm.Model
+		Name   string
+		Slug   string
+		Parent string
+	}
+
+	type WatchProduct struct {
+		gorm.Model
+		Email           string
+		ProductID       uint
+		Sent            *time.Time
+		PriceIDSent     *uint
+		Verified        bool   `gorm:"index"`
+		VerifyHash      string `gorm:"unique"`
+		UnsubscribeHash string `gorm:"unique"`
+	}
+
+	type ProductViewCount struct {
+		gorm.Model
+		ProductID uint `gorm:"index"`
+		Views     int
+	}
+
+	type ProductClickCount struct {
+		gorm.Model
+		ProductID uint `gorm:"index"`
+	}
+
+	type ProductPriceChange struct {
+		gorm.Model
+		ProductID     uint `gorm:"index"`
+		PriceDiff     int
+		PriceLower    bool
+		PrevPriceDate time.Time
+	}
+
+	type Bot struct {
+		gorm.Model
+		URL        string `gorm:"index"`
+		StartedAt  time.Time
+		FinishedAt *time.Time
+	}
+
+	type ShippingRule struct {
+		gorm.Model
+		Source     string `gorm:"unique;size:255"`
+		FlatFee    uint
+		FreeAbove  uint
+		FreePickup bool
+	}
+
+	return tx.AutoMigrate(
+		&Product{},
+		&Price{},
+		&Image{},
+		&Stock{},
+		&Spec{},
+		&Category{},
+		&UniqueCategory{},
+		&WatchProduct{},
+		&ProductViewCount{},
+		&ProductPriceChange{},
+		&ProductClickCount{},
+		&Bot{},
+		&ShippingRule{},
+	)
+}
+
+// initialSchemaDown drops all tables, children before products because of foreign keys
+func initialSchemaDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(
+		"prices",
+		"images",
+		"stocks",
+		"specs",
+		"categories",
+		"products",
+		"unique_categories",
+		"watch_products",
+		"product_view_counts",
+		"product_price_changes",
+		"product_click_counts",
+		"bots",
+		"shipping_rules",
+	)
+}
+
+// sqlDeleteOrphanRows was sql/2021-01-28.sql, run by hand before migrations
+const sqlDeleteOrphanRows string = `
+-- To be able to add foreign key constraints
+
+DELETE FROM categories WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM categories t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.id IS NULL) tt);
+
+DELETE FROM images WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM images t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.id IS NULL) tt);
+
+DELETE FROM prices WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM prices t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.id IS NULL) tt);
+
+DELETE FROM product_price_changes WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM product_price_changes t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.id IS NULL) tt);
+
+DELETE FROM product_view_counts WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM product_view_counts t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.id IS NULL) tt);
+
+DELETE FROM specs WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM specs t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.id IS NULL) tt);
+
+DELETE FROM stocks WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM stocks t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.id IS NULL) tt);
+
+DELETE FROM watch_products WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM watch_products t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.id IS NULL) tt);
+`
+
+// sqlRemoveWWWFromURLs was sql/2021-02-07.sql, run by hand before migrations
+const sqlRemoveWWWFromURLs string = `
+-- Stop using www in URLs
+
+UPDATE IGNORE products SET url = REPLACE (url, 'https://www.', 'https://') WHERE url LIKE 'https://www.%';
+
+DELETE FROM categories WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM categories t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.url LIKE 'https://www.%') tt);
+
+DELETE FROM images WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM images t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.url LIKE 'https://www.%') tt);
+
+DELETE FROM prices WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM prices t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.url LIKE 'https://www.%') tt);
+
+DELETE FROM product_price_changes WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM product_price_changes t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.url LIKE 'https://www.%') tt);
+
+DELETE FROM product_view_counts WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM product_view_counts t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.url LIKE 'https://www.%') tt);
+
+DELETE FROM specs WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM specs t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.url LIKE 'https://www.%') tt);
+
+DELETE FROM stocks WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM stocks t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.url LIKE 'https://www.%') tt);
+
+DELETE FROM watch_products WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM watch_products t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.url LIKE 'https://www.%') tt);
+
+DELETE FROM products WHERE url LIKE 'https://www.%';
+`
+
+// sqlRemoveVidFromHeimkaupURLs was sql/2021-02-08.sql, run by hand before migrations
+const sqlRemoveVidFromHeimkaupURLs string = `
+-- Remove ?vid=123 from heimkaup.is URLs
+
+UPDATE IGNORE products SET url = REGEXP_REPLACE(url, '\\?vid=[0-9]+', '') WHERE source = 'heimkaup.is' AND url LIKE '%?vid=%';
+
+DELETE FROM categories WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM categories t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.source = 'heimkaup.is' AND t2.url LIKE '%?vid=%') tt);
+
+DELETE FROM images WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM images t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.source = 'heimkaup.is' AND t2.url LIKE '%?vid=%') tt);
+
+DELETE FROM prices WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM prices t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.source = 'heimkaup.is' AND t2.url LIKE '%?vid=%') tt);
+
+DELETE FROM product_price_changes WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM product_price_changes t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.source = 'heimkaup.is' AND t2.url LIKE '%?vid=%') tt);
+
+DELETE FROM product_view_counts WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM product_view_counts t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.source = 'heimkaup.is' AND t2.url LIKE '%?vid=%') tt);
+
+DELETE FROM specs WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM specs t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.source = 'heimkaup.is' AND t2.url LIKE '%?vid=%') tt);
+
+DELETE FROM stocks WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM stocks t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.source = 'heimkaup.is' AND t2.url LIKE '%?vid=%') tt);
+
+DELETE FROM watch_products WHERE id IN (SELECT tt.id FROM (SELECT t1.id FROM watch_products t1 LEFT JOIN products t2 ON t2.id = t1.product_id WHERE t2.source = 'heimkaup.is' AND t2.url LIKE '%?vid=%') tt);
+
+DELETE FROM products WHERE source = 'heimkaup.is' AND url LIKE '%?vid=%';
+`
+
+// sqlRemoveAddToCartFromNexusURLs was sql/2021-02-09.sql, run by hand before migrations
+const sqlRemoveAddToCartFromNexusURLs string = `
+-- Remove ?add-to-cart from nexus.is
+
+UPDATE products SET url = REGEXP_REPLACE(url, '\\?add-to-cart=[0-9]+', '') WHERE source = 'nexus.is' AND url LIKE '%?add-to-cart=%';
+
+DELETE FROM products WHERE source = 'nexus.is' AND url LIKE '%?add-to-cart=%';
+`
+
+// sqlBackfillISKCurrency was sql/2026-10-19.sql, run by hand before migrations
+const sqlBackfillISKCurrency string = `
+-- Prices scraped before currencies were added are ISK with VAT
+
+UPDATE products SET currency = 'ISK', vat_included = 1, price_isk = price WHERE currency IS NULL OR currency = '';
+
+UPDATE prices SET currency = 'ISK', vat_included = 1, price_isk = price WHERE currency IS NULL OR currency = '';
+`
+
+// productBrand is the products table brand column as it was added
+type productBrand struct {
+	Brand string `gorm:"size:255;index"`
+}
+
+func (productBrand) TableName() string {
+	return "products"
+}
+
+// productBrandSpecKeys are the spec keys with the brand as they were when the column was added, lowercase
+var productBrandSpecKeys = []string{"framleiðandi", "vörumerki", "merki", "brand"}
+
+// addProductBrandUp adds the brand column and sets it from the specs of products that don't have one,
+// the same way setProductBrand does when a product is scraped
+func addProductBrandUp(tx *gorm.DB) error {
+	if !tx.Migrator().HasColumn(&productBrand{}, "Brand") {
+		err := tx.Migrator().AddColumn(&productBrand{}, "Brand")
+		if err != nil {
+			return err
+		}
+
+		err = tx.Migrator().CreateIndex(&productBrand{}, "Brand")
+		if err != nil {
+			return err
+		}
+	}
+
+	// key is a reserved word in MySQL
+	spec := fmt.Sprintf("FROM specs AS s WHERE s.product_id = products.id AND LOWER(TRIM(s.%s)) = ? AND TRIM(s.value) <> ''", tx.Statement.Quote("key"))
+	for _, key := range productBrandSpecKeys {
+		result := tx.Exec(
+			fmt.Sprintf("UPDATE products SET brand = (SELECT TRIM(s.value) %s ORDER BY s.id LIMIT 1) WHERE (brand IS NULL OR brand = '') AND EXISTS (SELECT 1 %s)", spec, spec),
+			key, key,
+		)
+		if err := result.Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addProductBrandDown(tx *gorm.DB) error {
+	if tx.Migrator().HasIndex(&productBrand{}, "Brand") {
+		err := tx.Migrator().DropIndex(&productBrand{}, "Brand")
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Migrator().DropColumn(&productBrand{}, "Brand")
+}
+
+// stockChange is the stock_changes table as it was created
+type stockChange struct {
+	gorm.Model
+	Location  string
+	InStock   bool
+	ProductID uint `gorm:"index"`
+}
+
+func (stockChange) TableName() string {
+	return "stock_changes"
+}
+
+func createStockChangesUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&stockChange{})
+}
+
+func createStockChangesDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&stockChange{})
+}
+
+// watchProductAlerts is the watch_products table alert columns as they were added,
+// watchers from before only had price drop alerts
+type watchProductAlerts struct {
+	PriceDrop         bool `gorm:"default:true"`
+	BackInStock       bool
+	Location          string
+	StockChangeIDSent *uint
+}
+
+func (watchProductAlerts) TableName() string {
+	return "watch_products"
+}
+
+func addWatchProductAlertsUp(tx *gorm.DB) error {
+	return addColumns(tx, &watchProductAlerts{}, "PriceDrop", "BackInStock", "Location", "StockChangeIDSent")
+}
+
+func addWatchProductAlertsDown(tx *gorm.DB) error {
+	return dropColumns(tx, &watchProductAlerts{}, "PriceDrop", "BackInStock", "Location", "StockChangeIDSent")
+}
+
+// watchProductThresholds is the watch_products table price alert threshold columns as they were added
+type watchProductThresholds struct {
+	TargetPrice    uint
+	MinDrop        uint
+	MinDropPercent uint
+}
+
+func (watchProductThresholds) TableName() string {
+	return "watch_products"
+}
+
+func addWatchProductThresholdsUp(tx *gorm.DB) error {
+	return addColumns(tx, &watchProductThresholds{}, "TargetPrice", "MinDrop", "MinDropPercent")
+}
+
+func addWatchProductThresholdsDown(tx *gorm.DB) error {
+	return dropColumns(tx, &watchProductThresholds{}, "TargetPrice", "MinDrop", "MinDropPercent")
+}
+
+// notification is the notifications table as it was created
+type notification struct {
+	gorm.Model
+	Kind          string `gorm:"size:32"`
+	Recipient     string // Email address
+	RecipientName string
+	Subject       string
+	HTML          string `gorm:"type:text"`
+	Text          string `gorm:"type:text"`
+	URL           string
+	Status        string `gorm:"size:16;index"`
+	Attempts      int
+	LastError     string    `gorm:"type:text"`
+	NextRetryAt   time.Time `gorm:"index"`
+	SentAt        *time.Time
+}
+
+func (notification) TableName() string {
+	return "notifications"
+}
+
+func createNotificationsUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&notification{})
+}
+
+func createNotificationsDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&notification{})
+}
+
+// emailPreference is the email_preferences table as it was created
+type emailPreference struct {
+	gorm.Model
+	Email  string `gorm:"size:191;unique"`
+	Digest string `gorm:"size:16"`
+}
+
+func (emailPreference) TableName() string {
+	return "email_preferences"
+}
+
+// watchEvent is the watch_events table as it was created
+type watchEvent struct {
+	gorm.Model
+	Email           string `gorm:"size:191;index"`
+	WatchProductID  uint   `gorm:"index"`
+	ProductID       uint   `gorm:"index"`
+	Kind            string `gorm:"size:32"`
+	ProductTitle    string
+	ProductURL      string
+	PriceOld        uint
+	PriceNew        uint
+	Location        string
+	UnsubscribeHash string
+	Date            time.Time
+}
+
+func (watchEvent) TableName() string {
+	return "watch_events"
+}
+
+func createWatchDigestsUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&emailPreference{}, &watchEvent{})
+}
+
+func createWatchDigestsDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&watchEvent{}, &emailPreference{})
+}
+
+// user is the users table as it was created
+type user struct {
+	gorm.Model
+	Email       string `gorm:"size:191;unique"`
+	LoginHash   string `gorm:"size:64;index"`
+	LoginSentAt *time.Time
+	LastLoginAt *time.Time
+}
+
+func (user) TableName() string {
+	return "users"
+}
+
+// session is the sessions table as it was created
+type session struct {
+	gorm.Model
+	UserID    uint      `gorm:"index"`
+	Token     string    `gorm:"size:64;unique"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+func (session) TableName() string {
+	return "sessions"
+}
+
+func createUsersUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&user{}, &session{})
+}
+
+func createUsersDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&session{}, &user{})
+}
+
+// watchProductVerifySent is the watch_products table verify sent column as it was added
+type watchProductVerifySent struct {
+	VerifySentAt *time.Time
+}
+
+func (watchProductVerifySent) TableName() string {
+	return "watch_products"
+}
+
+// hashTokensUp replaces verify hashes, login hashes and session tokens with their SHA-256,
+// so links and sessions from before keep working when they're hashed on lookup.
+// Unsubscribe tokens from before were from math/rand and could be guessed, so they are replaced,
+// unsubscribe links in alerts that were sent stop working and the next alerts have the new ones
+func hashTokensUp(tx *gorm.DB) error {
+	err := addColumns(tx, &watchProductVerifySent{}, "VerifySentAt")
+	if err != nil {
+		return err
+	}
+
+	for _, column := range []struct{ table, name string }{
+		{"watch_products", "verify_hash"},
+		{"users", "login_hash"},
+		{"sessions", "token"},
+	} {
+		var rows []struct {
+			ID    uint
+			Token string
+		}
+		result := tx.Table(column.table).Select(fmt.Sprintf("id, %s AS token", column.name)).Where(fmt.Sprintf("%s <> ''", column.name)).Find(&rows)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			result := tx.Table(column.table).Where("id = ?", row.ID).Update(column.name, formatters.HashToken(row.Token))
+			if err := result.Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	var watchIDs []uint
+	result := tx.Table("watch_products").Where("unsubscribe_hash <> ''").Pluck("id", &watchIDs)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	for _, id := range watchIDs {
+		token, err := formatters.GetRandomToken()
+		if err != nil {
+			return err
+		}
+
+		// Alerts waiting for a digest have the token of their watch
+		for _, table := range []struct{ name, id string }{
+			{"watch_products", "id"},
+			{"watch_events", "watch_product_id"},
+		} {
+			result := tx.Table(table.name).Where(fmt.Sprintf("%s = ?", table.id), id).Update("unsubscribe_hash", token)
+			if err := result.Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// hashTokensDown only drops the column, hashed tokens can't be unhashed
+func hashTokensDown(tx *gorm.DB) error {
+	return dropColumns(tx, &watchProductVerifySent{}, "VerifySentAt")
+}
+
+// watchProductLocale is the watch_products table locale column as it was added
+type watchProductLocale struct {
+	Locale string `gorm:"size:8"`
+}
+
+func (watchProductLocale) TableName() string {
+	return "watch_products"
+}
+
+// watchEventLocale is the watch_events table locale column as it was added
+type watchEventLocale struct {
+	Locale string `gorm:"size:8"`
+}
+
+func (watchEventLocale) TableName() string {
+	return "watch_events"
+}
+
+// addLocalesUp adds the email locale of watches and their events, existing ones stay Icelandic
+func addLocalesUp(tx *gorm.DB) error {
+	err := addColumns(tx, &watchProductLocale{}, "Locale")
+	if err != nil {
+		return err
+	}
+
+	return addColumns(tx, &watchEventLocale{}, "Locale")
+}
+
+func addLocalesDown(tx *gorm.DB) error {
+	err := dropColumns(tx, &watchEventLocale{}, "Locale")
+	if err != nil {
+		return err
+	}
+
+	return dropColumns(tx, &watchProductLocale{}, "Locale")
+}
+
+// pushSubscription is the push_subscriptions table as it was created
+type pushSubscription struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	Endpoint string `gorm:"type:text"`
+	P256dh   string
+	Auth     string
+}
+
+func (pushSubscription) TableName() string {
+	return "push_subscriptions"
+}
+
+func createPushSubscriptionsUp(tx *gorm.DB) error {
+	return tx.AutoMigrate(&pushSubscription{})
+}
+
+func createPushSubscriptionsDown(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(&pushSubscription{})
+}
+
+// notificationChannel is the notifications table channel column as it was added
+type notificationChannel struct {
+	Channel string `gorm:"size:16"`
+}
+
+func (notificationChannel) TableName() string {
+	return "notifications"
+}
+
+// addNotificationChannelUp adds the channel of notifications, ones queued before are delivered with every channel
+func addNotificationChannelUp(tx *gorm.DB) error {
+	return addColumns(tx, &notificationChannel{}, "Channel")
+}
+
+func addNotificationChannelDown(tx *gorm.DB) error {
+	return dropColumns(tx, &notificationChannel{}, "Channel")
+}
+
+// lowercaseEmailsUp normalizes emails stored before they were normalized on input, so they match
+// the emails of users who log in. Where the email is unique the row that already has the normalized email is kept
+func lowercaseEmailsUp(tx *gorm.DB) error {
+	for _, table := range []struct {
+		name   string
+		unique bool
+	}{
+		{"watch_products", false},
+		{"watch_events", false},
+		{"email_preferences", true},
+		{"users", true},
+	} {
+		// Compared here, MySQL compares strings case-insensitively
+		var rows []struct {
+			ID    uint
+			Email string
+		}
+		result := tx.Table(table.name).Select("id, email").Find(&rows)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			email := formatters.NormalizeEmail(row.Email)
+			if email == row.Email {
+				continue
+			}
+
+			if table.unique {
+				var count int64
+				result := tx.Table(table.name).Where("email = ? AND id <> ?", email, row.ID).Count(&count)
+				if err := result.Error; err != nil {
+					return err
+				}
+
+				if count > 0 {
+					result := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE id = ?", table.name), row.ID)
+					if err := result.Error; err != nil {
+						return err
+					}
+					continue
+				}
+			}
+
+			result := tx.Table(table.name).Where("id = ?", row.ID).Update("email", email)
+			if err := result.Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// lowercaseEmailsDown does nothing, the emails they had before are gone
+func lowercaseEmailsDown(tx *gorm.DB) error {
+	return nil
+}
+
+// minorUnitColumns are the price columns in the currency of each row
+var minorUnitColumns = map[string][]string{
+	"products": {"price", "list_price", "reference_price"},
+	"prices":   {"price", "list_price"},
+}
+
+// storeMinorUnitsUp converts prices in whole units of currencies with decimals to minor units, ex. euros to cents
+func storeMinorUnitsUp(tx *gorm.DB) error {
+	return scaleMinorUnits(tx, func(column string, factor float64) clause.Expr {
+		return gorm.Expr(tx.Statement.Quote(column)+" * ?", factor)
+	})
+}
+
+func storeMinorUnitsDown(tx *gorm.DB) error {
+	return scaleMinorUnits(tx, func(column string, factor float64) clause.Expr {
+		return gorm.Expr("ROUND("+tx.Statement.Quote(column)+" / ?)", factor)
+	})
+}
+
+// scaleMinorUnits updates the price columns of each currency with decimals to scale(column, 10^decimals)
+func scaleMinorUnits(tx *gorm.DB, scale func(column string, factor float64) clause.Expr) error {
+	for table, columns := range minorUnitColumns {
+		var currencies []string
+		result := tx.Table(table).Distinct("currency").Pluck("currency", &currencies)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		for _, currency := range currencies {
+			factor := math.Pow10(CurrencyExponent(currency))
+			if factor == 1 {
+				continue
+			}
+
+			updates := make(map[string]interface{}, len(columns))
+			for _, column := range columns {
+				updates[column] = scale(column, factor)
+			}
+			result := tx.Table(table).Where("currency = ?", currency).Updates(updates)
+			if err := result.Error; err != nil {
+				return fmt.Errorf("error converting %s prices in %s: %w", currency, table, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// unsubscribeToken is the unsubscribe_tokens table as it was created
+type unsubscribeToken struct {
+	gorm.Model
+	WatchProductID uint   `gorm:"index"`
+	Hash           string `gorm:"unique;size:64"`
+}
+
+func (unsubscribeToken) TableName() string {
+	return "unsubscribe_tokens"
+}
+
+// watchProductUnsubscribeHash is the watch_products table column that had the plain unsubscribe token
+type watchProductUnsubscribeHash struct {
+	UnsubscribeHash string
+}
+
+func (watchProductUnsubscribeHash) TableName() string {
+	return "watch_products"
+}
+
+// watchEventUnsubscribeHash is the watch_events table column that had the plain unsubscribe token of the watch
+type watchEventUnsubscribeHash struct {
+	UnsubscribeHash string
+}
+
+func (watchEventUnsubscribeHash) TableName() string {
+	return "watch_events"
+}
+
+// createUnsubscribeTokensUp moves the plain unsubscribe tokens of watches to hashes in their own table,
+// so the links in alerts that were already sent keep working
+func createUnsubscribeTokensUp(tx *gorm.DB) error {
+	err := tx.AutoMigrate(&unsubscribeToken{})
+	if err != nil {
+		return err
+	}
+
+	var rows []struct {
+		ID    uint
+		Token string
+	}
+	result := tx.Table("watch_products").Select("id, unsubscribe_hash AS token").Where("unsubscribe_hash <> ''").Find(&rows)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		result := tx.Create(&unsubscribeToken{WatchProductID: row.ID, Hash: formatters.HashToken(row.Token)})
+		if err := result.Error; err != nil {
+			return err
+		}
+	}
+
+	err = dropColumns(tx, &watchEventUnsubscribeHash{}, "UnsubscribeHash")
+	if err != nil {
+		return err
+	}
+
+	return dropColumns(tx, &watchProductUnsubscribeHash{}, "UnsubscribeHash")
+}
+
+// createUnsubscribeTokensDown adds the columns back empty, hashed tokens can't be unhashed
+func createUnsubscribeTokensDown(tx *gorm.DB) error {
+	err := addColumns(tx, &watchProductUnsubscribeHash{}, "UnsubscribeHash")
+	if err != nil {
+		return err
+	}
+
+	err = addColumns(tx, &watchEventUnsubscribeHash{}, "UnsubscribeHash")
+	if err != nil {
+		return err
+	}
+
+	return tx.Migrator().DropTable(&unsubscribeToken{})
+}
+
+// addColumns adds the fields of model that don't have a column yet
+func addColumns(tx *gorm.DB, model interface{}, fields ...string) error {
+	for _, field := range fields {
+		if tx.Migrator().HasColumn(model, field) {
+			continue
+		}
+
+		err := tx.Migrator().AddColumn(model, field)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropColumns drops the columns of the fields of model that exist
+func dropColumns(tx *gorm.DB, model interface{}, fields ...string) error {
+	for _, field := range fields {
+		if !tx.Migrator().HasColumn(model, field) {
+			continue
+		}
+
+		err := tx.Migrator().DropColumn(model, field)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/metrics"
+	"bitbucket.org/hilmarp/price-scraper/notify"
+	"github.com/robfig/cron/v3"
+)
+
+// Notification statuses
+const (
+	NotificationPending string = "pending"
+	NotificationSent    string = "sent"
+	NotificationFailed  string = "failed" // Gave up after notificationMaxAttempts
+)
+
+// notificationMaxAttempts is how often a notification is tried before it's failed,
+// with the backoff the last try is about 2 hours after the first
+const notificationMaxAttempts int = 8
+
+// notificationTimeout is how long a channel gets to deliver a notification, so one that hangs
+// doesn't hold up the rest of the outbox
+const notificationTimeout = 30 * time.Second
+
+// NewNotification returns message as a pending notification of kind to deliver with channel, due now
+func NewNotification(kind, channel string, message notify.Message) *Notification {
+	return &Notification{
+		Kind:          kind,
+		Channel:       channel,
+		Recipient:     message.To,
+		RecipientName: message.ToName,
+		Subject:       message.Subject,
+		HTML:          message.HTML,
+		Text:          message.Text,
+		URL:           message.URL,
+		Status:        NotificationPending,
+		NextRetryAt:   time.Now(),
+	}
+}
+
+// newNotifications returns message as a notification of kind for each channel,
+// so a channel that is down doesn't send the message again with the others
+func (s *Scraper) newNotifications(kind string, message notify.Message) []*Notification {
+	notifications := make([]*Notification, len(s.Channels))
+	for i, c := range s.Channels {
+		notifications[i] = NewNotification(kind, c.Name, message)
+	}
+
+	return notifications
+}
+
+// queueNotifications adds message to the outbox as a notification of kind for each channel
+func (s *Scraper) queueNotifications(kind string, message notify.Message) error {
+	return s.DB.CreateNotifications(s.newNotifications(kind, message))
+}
+
+// notifier returns the notifier of channel, all of them for notifications queued before they had a channel
+func (s *Scraper) notifier(channel string) (notify.Notifier, error) {
+	if channel == "" {
+		notifiers := make(notify.Multi, len(s.Channels))
+		for i, c := range s.Channels {
+			notifiers[i] = c.Notifier
+		}
+		return notifiers, nil
+	}
+
+	for _, c := range s.Channels {
+		if c.Name == channel {
+			return c.Notifier, nil
+		}
+	}
+
+	return nil, fmt.Errorf("notifier %q is not configured", channel)
+}
+
+// Message returns the message to deliver
+func (n *Notification) Message() notify.Message {
+	return notify.Message{
+		To:      n.Recipient,
+		ToName:  n.RecipientName,
+		Subject: n.Subject,
+		HTML:    n.HTML,
+		Text:    n.Text,
+		URL:     n.URL,
+	}
+}
+
+// Subscription returns the subscription to push to
+func (p *PushSubscription) Subscription() notify.PushSubscription {
+	var subscription notify.PushSubscription
+	subscription.Endpoint = p.Endpoint
+	subscription.Keys.P256dh = p.P256dh
+	subscription.Keys.Auth = p.Auth
+
+	return subscription
+}
+
+// notificationBackoff is how long to wait before the next try after attempts failed tries,
+// doubling from 1 minute
+func notificationBackoff(attempts int) time.Duration {
+	return time.Minute << uint(attempts-1)
+}
+
+// StartNotificationSender delivers notifications from the outbox every minute
+func (s *Scraper) StartNotificationSender() error {
+	c := cron.New(cron.WithChain(cron.SkipIfStillRunning(cron.DefaultLogger)))
+	c.AddFunc("* * * * *", func() { // Every minute
+		metrics.NotificationSendersRunning.Inc()
+		defer metrics.NotificationSendersRunning.Dec()
+
+		err := s.SendNotifications(time.Now())
+		if err != nil {
+			log.Print(err)
+		}
+	})
+	c.Start()
+
+	return nil
+}
+
+// SendNotifications delivers notifications due at now, also to the browsers the recipient subscribed to push in,
+// failed deliveries are retried later with backoff
+func (s *Scraper) SendNotifications(now time.Time) error {
+	limit := 100
+
+	for {
+		notifications, err := s.DB.GetDueNotifications(limit, now)
+		if err != nil {
+			return err
+		}
+
+		for _, n := range *notifications {
+			// Looked up when delivering, so browsers unsubscribed since aren't pushed to
+			subscriptions, err := s.DB.GetPushSubscriptionsByEmail(n.Recipient)
+			if err != nil {
+				return err
+			}
+
+			message := n.Message()
+			for _, subscription := range *subscriptions {
+				message.Push = append(message.Push, subscription.Subscription())
+			}
+
+			notifier, err := s.notifier(n.Channel)
+			if err == nil {
+				ctx, cancel := context.WithTimeout(context.Background(), notificationTimeout)
+				err = notifier.Notify(ctx, message)
+				cancel()
+			}
+			n.Attempts++
+			switch {
+			case err == nil:
+				n.Status = NotificationSent
+				n.SentAt = &now
+				n.LastError = ""
+				metrics.NotificationsSent.Inc()
+			case n.Attempts >= notificationMaxAttempts:
+				n.Status = NotificationFailed
+				n.LastError = err.Error()
+				metrics.NotificationsFailed.Inc()
+			default:
+				n.LastError = err.Error()
+				n.NextRetryAt = now.Add(notificationBackoff(n.Attempts))
+				metrics.NotificationRetries.Inc()
+			}
+
+			// Not due anymore either way, so the next page doesn't get it again
+			err = s.DB.UpdateNotification(&n)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(*notifications) < limit {
+			return nil
+		}
+	}
+}
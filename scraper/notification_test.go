@@ -0,0 +1,206 @@
+package scraper
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/notify"
+)
+
+// downNotifier fails to deliver to down, messages to others are recorded
+type downNotifier struct {
+	down     string
+	messages []notify.Message
+}
+
+func (n *downNotifier) Notify(ctx context.Context, message notify.Message) error {
+	if message.To == n.down {
+		return errors.New("mailbox down")
+	}
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+func TestNotificationBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 7: 64 * time.Minute} {
+		if got := notificationBackoff(attempts); got != want {
+			t.Errorf("Got %v after %d attempts, want %v", got, attempts, want)
+		}
+	}
+}
+
+func TestSendNotifications(t *testing.T) {
+	db := newTestSQL(t)
+	notifier := &downNotifier{down: "c@d.is"}
+	s := &Scraper{DB: db, Channels: []notify.Channel{{Name: "email", Notifier: notifier}}}
+
+	for _, to := range []string{"a@b.is", "c@d.is"} {
+		err := db.CreateNotifications([]*Notification{NewNotification(AlertPriceDrop, "email", notify.Message{To: to, Subject: "Verðlækkun", HTML: "<p>Lækkun</p>"})})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	err := s.SendNotifications(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.messages) != 1 || notifier.messages[0].To != "a@b.is" || notifier.messages[0].HTML != "<p>Lækkun</p>" {
+		t.Fatalf("Got messages %v, want one to a@b.is", notifier.messages)
+	}
+
+	sent, err := db.GetNotifications(NotificationSent, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*sent) != 1 || (*sent)[0].Attempts != 1 || (*sent)[0].SentAt == nil {
+		t.Errorf("Got sent %v, want a@b.is after 1 attempt", *sent)
+	}
+
+	// Not due again until the backoff is over
+	due, err := db.GetDueNotifications(10, now.Add(30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*due) != 0 {
+		t.Errorf("Got %d due notifications, want none", len(*due))
+	}
+
+	for i := 2; i <= notificationMaxAttempts; i++ {
+		now = now.Add(notificationBackoff(i - 1))
+		err := s.SendNotifications(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failed, err := db.GetNotifications(NotificationFailed, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*failed) != 1 || (*failed)[0].Attempts != notificationMaxAttempts || (*failed)[0].LastError != "mailbox down" {
+		t.Fatalf("Got failed %v, want c@d.is after %d attempts", *failed, notificationMaxAttempts)
+	}
+
+	// Retried by hand it gets one more try
+	err = db.RetryNotification((*failed)[0].ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	due, err = db.GetDueNotifications(10, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*due) != 1 || (*due)[0].Status != NotificationPending {
+		t.Errorf("Got due %v after retry, want c@d.is", *due)
+	}
+
+	err = db.RetryNotification(100, now)
+	if err == nil {
+		t.Error("Got no error retrying a notification that doesn't exist")
+	}
+}
+
+func TestSendNotificationsPush(t *testing.T) {
+	db := newTestSQL(t)
+	notifier := &downNotifier{}
+	s := &Scraper{DB: db, Channels: []notify.Channel{{Name: "email", Notifier: notifier}}}
+
+	user := &User{Email: "a@b.is"}
+	err := db.CreateUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, auth := range []string{"old", "new"} {
+		err := db.UpdateOrCreatePushSubscription(&PushSubscription{UserID: user.ID, Endpoint: "https://push.example.com/1", P256dh: "key", Auth: auth})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, to := range []string{"a@b.is", "c@d.is"} {
+		err := db.CreateNotifications([]*Notification{NewNotification(AlertPriceDrop, "email", notify.Message{To: to, Subject: "Verðlækkun"})})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.SendNotifications(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(notifier.messages) != 2 {
+		t.Fatalf("Got %d messages, want 2", len(notifier.messages))
+	}
+	if push := notifier.messages[0].Push; len(push) != 1 || push[0].Endpoint != "https://push.example.com/1" || push[0].Keys.Auth != "new" {
+		t.Errorf("Got push %+v to a@b.is, want the updated subscription", push)
+	}
+	if push := notifier.messages[1].Push; len(push) != 0 {
+		t.Errorf("Got push %+v to c@d.is, want none", push)
+	}
+
+	// Unsubscribed browsers aren't pushed to
+	err = db.DeletePushSubscription(user.ID, "https://push.example.com/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriptions, err := db.GetPushSubscriptionsByEmail("a@b.is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*subscriptions) != 0 {
+		t.Errorf("Got %d subscriptions after unsubscribing, want none", len(*subscriptions))
+	}
+}
+
+func TestSendNotificationsChannels(t *testing.T) {
+	db := newTestSQL(t)
+	email := &downNotifier{}
+	webhook := &downNotifier{down: "a@b.is"}
+	s := &Scraper{DB: db, Channels: []notify.Channel{{Name: "email", Notifier: email}, {Name: "webhook", Notifier: webhook}}}
+
+	err := s.queueNotifications(AlertPriceDrop, notify.Message{To: "a@b.is", Subject: "Verðlækkun"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Queued before notifications had a channel, and with a channel that was removed
+	legacy := NewNotification(AlertPriceDrop, "", notify.Message{To: "c@d.is", Subject: "Verðlækkun"})
+	removed := NewNotification(AlertPriceDrop, "sms", notify.Message{To: "c@d.is", Subject: "Verðlækkun"})
+	err = db.CreateNotifications([]*Notification{legacy, removed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The webhook being down doesn't send the email again
+	now := time.Now()
+	for i := 1; i <= 3; i++ {
+		err := s.SendNotifications(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(notificationBackoff(i))
+	}
+	if len(email.messages) != 2 || email.messages[0].To != "a@b.is" || email.messages[1].To != "c@d.is" {
+		t.Errorf("Got emails %v, want one to a@b.is and one to c@d.is", email.messages)
+	}
+	if len(webhook.messages) != 1 || webhook.messages[0].To != "c@d.is" {
+		t.Errorf("Got webhooks %v, want one to c@d.is", webhook.messages)
+	}
+
+	pending, err := db.GetNotifications(NotificationPending, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*pending) != 2 {
+		t.Fatalf("Got %d pending notifications, want the webhook and the removed channel", len(*pending))
+	}
+	for _, n := range *pending {
+		if n.Attempts != 3 || n.LastError == "" {
+			t.Errorf("Got %s notification after %d attempts with error %q, want 3 attempts", n.Channel, n.Attempts, n.LastError)
+		}
+	}
+}
@@ -17,14 +17,14 @@ func (s *Scraper) StartPriceChangeWatcher() error {
 		defer metrics.PriceChangeWatchersRunning.Dec()
 
 		limit := 100
-		offset := 0
+		var cursor *Cursor
 
 		// From is two weeks
 		now := time.Now()
 		from := now.Add(time.Duration(-336) * time.Hour)
 
 		for {
-			products, err := s.DB.GetProducts(limit, offset, 0, 0, "id desc", "", []string{}, []string{})
+			products, next, err := s.DB.GetProducts(ProductQuery{Limit: limit, Cursor: cursor, Sort: ProductSort{Desc: true}})
 			if err != nil {
 				log.Print(err)
 				break
@@ -35,7 +35,7 @@ func (s *Scraper) StartPriceChangeWatcher() error {
 			}
 
 			for _, product := range *products {
-				prices, err := s.DB.GetProductPrices(product.ID, from, "id desc")
+				prices, err := s.DB.GetProductPrices(product.ID, from, true)
 				if err != nil {
 					log.Print(err)
 					continue
@@ -81,7 +81,11 @@ func (s *Scraper) StartPriceChangeWatcher() error {
 				}
 			}
 
-			offset = offset + limit
+			// Keyset, so deleted or added products don't shift the pages
+			if next == nil {
+				break
+			}
+			cursor = next
 		}
 	})
 	c.Start()
@@ -0,0 +1,123 @@
+package scraper
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// PriceStats are statistics for a product price history over a window
+type PriceStats struct {
+	ProductID           uint
+	From                time.Time
+	To                  time.Time
+	Count               int // Number of price entries in window
+	Current             uint
+	Min                 uint
+	Max                 uint
+	Mean                float64
+	Median              float64
+	CurrentPercentile   float64 // 0 is the lowest price in window, 100 the highest
+	Changes             int
+	DaysSinceLastChange int
+	LongestStableDays   int
+}
+
+// CalculatePriceStats calculates statistics from prices in the window from until now, which have to be ordered oldest first.
+// before is the last price from before the window, if any, it's the price in effect at from,
+// so it counts as a price at from and the first price in the window is a change when it differs
+func CalculatePriceStats(before *Price, prices []Price, from, now time.Time) PriceStats {
+	stats := PriceStats{From: from, To: now, Count: len(prices)}
+
+	series := prices
+	if before != nil {
+		series = append([]Price{{Price: before.Price, Date: from}}, prices...)
+	}
+
+	if len(series) == 0 {
+		return stats
+	}
+
+	stats.Current = series[len(series)-1].Price
+	stats.Min = series[0].Price
+	stats.Max = series[0].Price
+
+	values := make([]uint, len(series))
+	var sum float64
+	for i, p := range series {
+		values[i] = p.Price
+		sum += float64(p.Price)
+
+		if p.Price < stats.Min {
+			stats.Min = p.Price
+		}
+		if p.Price > stats.Max {
+			stats.Max = p.Price
+		}
+	}
+	stats.Mean = sum / float64(len(series))
+	stats.Median = medianPrice(values)
+
+	// Percentile rank of current price, equal values count as half
+	below := 0
+	equal := 0
+	for _, v := range values {
+		if v < stats.Current {
+			below++
+		} else if v == stats.Current {
+			equal++
+		}
+	}
+	stats.CurrentPercentile = (float64(below) + 0.5*float64(equal)) / float64(len(values)) * 100
+
+	// Changes and stable periods, a stable period starts at the date of a price change
+	// and lasts until the next change, or until now for the last one
+	stableStart := series[0].Date
+	var longestStable time.Duration
+	for i := 1; i < len(series); i++ {
+		if series[i].Price == series[i-1].Price {
+			continue
+		}
+
+		stats.Changes++
+		if d := series[i].Date.Sub(stableStart); d > longestStable {
+			longestStable = d
+		}
+		stableStart = series[i].Date
+	}
+	if d := now.Sub(stableStart); d > longestStable {
+		longestStable = d
+	}
+
+	stats.DaysSinceLastChange = durationToDays(now.Sub(stableStart))
+	stats.LongestStableDays = durationToDays(longestStable)
+
+	return stats
+}
+
+// medianPrice returns the median of values, values are not modified
+func medianPrice(values []uint) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := make([]uint, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (float64(sorted[mid-1]) + float64(sorted[mid])) / 2
+	}
+
+	return float64(sorted[mid])
+}
+
+// durationToDays returns whole days in duration, never negative
+func durationToDays(d time.Duration) int {
+	if d < 0 {
+		return 0
+	}
+
+	return int(math.Floor(d.Hours() / 24))
+}
@@ -0,0 +1,140 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+)
+
+// pricesFromSeries returns prices one day apart, ending at end
+func pricesFromSeries(end time.Time, series ...uint) []Price {
+	prices := make([]Price, len(series))
+	for i, p := range series {
+		prices[i] = Price{
+			Price: p,
+			Date:  end.AddDate(0, 0, i-len(series)+1),
+		}
+	}
+
+	return prices
+}
+
+func TestCalculatePriceStatsEmpty(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	stats := CalculatePriceStats(nil, []Price{}, now.AddDate(0, 0, -30), now)
+	if stats.Count != 0 {
+		t.Errorf("Got %v, want %v", stats.Count, 0)
+	}
+	if stats.Changes != 0 {
+		t.Errorf("Got %v, want %v", stats.Changes, 0)
+	}
+}
+
+func TestCalculatePriceStatsStable(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	prices := pricesFromSeries(now, 1000, 1000, 1000, 1000, 1000)
+	stats := CalculatePriceStats(nil, prices, prices[0].Date, now)
+
+	if stats.Min != 1000 || stats.Max != 1000 {
+		t.Errorf("Got min %v max %v, want %v", stats.Min, stats.Max, 1000)
+	}
+	if stats.Mean != 1000 || stats.Median != 1000 {
+		t.Errorf("Got mean %v median %v, want %v", stats.Mean, stats.Median, 1000)
+	}
+	if stats.CurrentPercentile != 50 {
+		t.Errorf("Got %v, want %v", stats.CurrentPercentile, 50)
+	}
+	if stats.Changes != 0 {
+		t.Errorf("Got %v, want %v", stats.Changes, 0)
+	}
+	if stats.DaysSinceLastChange != 4 {
+		t.Errorf("Got %v, want %v", stats.DaysSinceLastChange, 4)
+	}
+	if stats.LongestStableDays != 4 {
+		t.Errorf("Got %v, want %v", stats.LongestStableDays, 4)
+	}
+}
+
+func TestCalculatePriceStatsChanges(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	// Day -7 to today
+	prices := pricesFromSeries(now, 2000, 2000, 2000, 2000, 1500, 1500, 1800, 1000)
+	stats := CalculatePriceStats(nil, prices, prices[0].Date, now)
+
+	if stats.Count != 8 {
+		t.Errorf("Got %v, want %v", stats.Count, 8)
+	}
+	if stats.Current != 1000 {
+		t.Errorf("Got %v, want %v", stats.Current, 1000)
+	}
+	if stats.Min != 1000 {
+		t.Errorf("Got %v, want %v", stats.Min, 1000)
+	}
+	if stats.Max != 2000 {
+		t.Errorf("Got %v, want %v", stats.Max, 2000)
+	}
+	if stats.Mean != 1725 {
+		t.Errorf("Got %v, want %v", stats.Mean, 1725)
+	}
+	if stats.Median != 1900 {
+		t.Errorf("Got %v, want %v", stats.Median, 1900)
+	}
+	if stats.CurrentPercentile != 6.25 {
+		t.Errorf("Got %v, want %v", stats.CurrentPercentile, 6.25)
+	}
+	if stats.Changes != 3 {
+		t.Errorf("Got %v, want %v", stats.Changes, 3)
+	}
+	if stats.DaysSinceLastChange != 0 {
+		t.Errorf("Got %v, want %v", stats.DaysSinceLastChange, 0)
+	}
+	if stats.LongestStableDays != 4 {
+		t.Errorf("Got %v, want %v", stats.LongestStableDays, 4)
+	}
+}
+
+func TestCalculatePriceStatsBefore(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	from := now.AddDate(0, 0, -10)
+	before := &Price{Price: 2000, Date: now.AddDate(0, 0, -40)}
+
+	// 2000 since before the window, 1500 from day -3
+	prices := pricesFromSeries(now, 1500, 1500, 1500, 1500)
+	stats := CalculatePriceStats(before, prices, from, now)
+
+	if stats.Count != 4 {
+		t.Errorf("Got %v, want %v", stats.Count, 4)
+	}
+	if stats.Max != 2000 {
+		t.Errorf("Got %v, want %v", stats.Max, 2000)
+	}
+	if stats.Changes != 1 {
+		t.Errorf("Got %v, want %v", stats.Changes, 1)
+	}
+	if stats.DaysSinceLastChange != 3 {
+		t.Errorf("Got %v, want %v", stats.DaysSinceLastChange, 3)
+	}
+	if stats.LongestStableDays != 7 {
+		t.Errorf("Got %v, want %v", stats.LongestStableDays, 7)
+	}
+
+	// No prices in the window, the price before is still in effect
+	stats = CalculatePriceStats(before, nil, from, now)
+	if stats.Count != 0 || stats.Current != 2000 || stats.Changes != 0 {
+		t.Errorf("Got count %v current %v changes %v, want 0, 2000 and 0", stats.Count, stats.Current, stats.Changes)
+	}
+	if stats.LongestStableDays != 10 {
+		t.Errorf("Got %v, want %v", stats.LongestStableDays, 10)
+	}
+}
+
+func TestMedianPrice(t *testing.T) {
+	median := medianPrice([]uint{5, 1, 3})
+	if median != 3 {
+		t.Errorf("Got %v, want %v", median, 3)
+	}
+
+	median = medianPrice([]uint{4, 1, 3, 2})
+	if median != 2.5 {
+		t.Errorf("Got %v, want %v", median, 2.5)
+	}
+}
@@ -9,50 +9,78 @@ import (
 // Product is the base product
 type Product struct {
 	gorm.Model
-	Source      string `gorm:"index:idx_products_source_product_code"`
-	ProductCode string `gorm:"index:idx_products_source_product_code"`
-	Slug        string `gorm:"unique;size:255"`
-	URL         string `gorm:"unique"`
-	Title       string
-	Description string `gorm:"type:text"`
-	MainImgURL  string
-	Price       uint // Latest price
-	OnSale      bool
-	Specs       []Spec
-	Stocks      []Stock
-	AllImgURLs  []Image
-	Prices      []Price
-	Categories  []Category
+	Source          string `gorm:"index:idx_products_source_product_code"`
+	ProductCode     string `gorm:"index:idx_products_source_product_code"`
+	Slug            string `gorm:"unique;size:255"`
+	URL             string `gorm:"unique"`
+	Title           string
+	Description     string `gorm:"type:text"`
+	MainImgURL      string
+	Brand           string  `gorm:"size:255;index"` // Manufacturer from specs, empty if not found
+	Price           uint    // Latest price in minor units of Currency, the sale price when on sale
+	PriceISK        uint    `gorm:"index"`  // Latest price converted to ISK with VAT
+	Currency        string  `gorm:"size:3"` // ISO 4217 code, ISK if the store doesn't set it
+	VATIncluded     bool    // If Price includes VAT, always true for ISK stores
+	ListPrice       uint    // Latest regular price before discount
+	Discount        uint    // Percentage off ListPrice
+	Unit            string  `gorm:"size:8;index"` // kg, l, m, m2 or stk, empty if not found
+	UnitQuantity    float64 // Package quantity in Unit
+	UnitPrice       uint    `gorm:"index"` // Price per one Unit
+	OnSale          bool
+	SuspiciousSale  bool    `gorm:"index"` // On sale but price isn't lower than ReferencePrice
+	ReferencePrice  uint    // Median price over 30 days before sale started
+	ShippingCost    uint    // Home delivery cost in ISK from the store ShippingRule
+	TotalPrice      uint    `gorm:"index"` // PriceISK with ShippingCost, the price to door
+	FreePickup      bool    // Store has free pickup
+	DisplayPrice    float64 `gorm:"-"` // Price in the currency requested from the API
+	DisplayCurrency string  `gorm:"-"`
+	Specs           []Spec
+	Stocks          []Stock
+	AllImgURLs      []Image
+	Prices          []Price
+	Categories      []Category
 }
 
 // SearchProduct is searchable fields in Elasticsearch
 type SearchProduct struct {
-	ID          uint
-	ScrapedAt   string
-	Source      string
-	ProductCode string
-	Slug        string
-	URL         []string
-	Title       string
-	Categories  []string
-	Description string
-	MainImgURL  string
-	Price       uint // latest price
-	OnSale      bool
+	ID           uint
+	ScrapedAt    string
+	Source       string
+	ProductCode  string
+	Slug         string
+	URL          []string
+	Title        string
+	Categories   []string
+	Description  string
+	MainImgURL   string
+	Price        uint // latest price in minor units of Currency
+	PriceISK     uint // latest price in ISK
+	Currency     string
+	ListPrice    uint // latest regular price
+	ShippingCost uint
+	TotalPrice   uint // latest price to door in ISK
+	OnSale       bool
+	InStock      bool     // in stock at any location
+	InStockAt    []string // locations it's in stock at
 }
 
 // ProductPriceDiff is the base product with price diff
 type ProductPriceDiff struct {
 	Product
-	PriceDiff  uint
-	PriceLower bool
+	PriceDiff   uint
+	PriceLower  bool
+	CursorValue int64 `gorm:"->" json:"-"` // value the listing was sorted by, for the next cursor
 }
 
 type Price struct {
 	gorm.Model
-	Price     uint
-	Date      time.Time
-	ProductID uint `gorm:"index"`
+	Price       uint   // In minor units of Currency, ex. cents for EUR
+	ListPrice   uint   // Regular price, same as Price when not on sale
+	PriceISK    uint   // Price in ISK with VAT, at the rate when scraped
+	Currency    string `gorm:"size:3"`
+	VATIncluded bool
+	Date        time.Time
+	ProductID   uint `gorm:"index"`
 }
 
 type Image struct {
@@ -69,6 +97,14 @@ type Stock struct {
 	ProductID uint `gorm:"index"`
 }
 
+// StockChange is when a product went in or out of stock at a location, CreatedAt is when it was scraped
+type StockChange struct {
+	gorm.Model
+	Location  string
+	InStock   bool
+	ProductID uint `gorm:"index"`
+}
+
 type Spec struct {
 	gorm.Model
 	Key       string
@@ -95,13 +131,21 @@ type UniqueCategory struct {
 // WatchProduct is an email watching a product
 type WatchProduct struct {
 	gorm.Model
-	Email           string
-	ProductID       uint
-	Sent            *time.Time
-	PriceIDSent     *uint
-	Verified        bool   `gorm:"index"`
-	VerifyHash      string `gorm:"unique"`
-	UnsubscribeHash string `gorm:"unique"`
+	Email             string
+	ProductID         uint
+	PriceDrop         bool       // alert when the price drops
+	BackInStock       bool       // alert when it's back in stock
+	Location          string     // only alert about stock at this location, any location when empty
+	TargetPrice       uint       // only alert about prices at or below it, in ISK
+	MinDrop           uint       // only alert about drops of at least this much ISK
+	MinDropPercent    uint       // only alert about drops of at least this percentage
+	Sent              *time.Time // last price alert
+	PriceIDSent       *uint
+	StockChangeIDSent *uint      // last stock change alerted about
+	Verified          bool       `gorm:"index"`
+	VerifyHash        string     `gorm:"unique"` // SHA-256 of the token in the verify link
+	VerifySentAt      *time.Time // CreatedAt when nil
+	Locale            string     `gorm:"size:8"` // email locale, Icelandic when empty
 }
 
 // ProductViewCount is a product web page view counter
@@ -126,6 +170,90 @@ type ProductPriceChange struct {
 	PrevPriceDate time.Time
 }
 
+// ShippingRule is how much a store charges for home delivery
+type ShippingRule struct {
+	gorm.Model
+	Source     string `gorm:"unique;size:255"` // ex. elko.is
+	FlatFee    uint   // Delivery cost in ISK
+	FreeAbove  uint   // Delivery is free from this price in ISK, 0 if never free
+	FreePickup bool   // Products can be picked up in store for free
+}
+
+// Notification is a message in the outbox, delivered with retries by StartNotificationSender
+type Notification struct {
+	gorm.Model
+	Kind          string `gorm:"size:32"` // What it's about, ex. price_drop
+	Channel       string `gorm:"size:16"` // Notifier it's delivered with, ex. webhook, every one when empty
+	Recipient     string // Email address
+	RecipientName string
+	Subject       string
+	HTML          string `gorm:"type:text"`
+	Text          string `gorm:"type:text"`
+	URL           string
+	Status        string `gorm:"size:16;index"` // pending, sent or failed
+	Attempts      int
+	LastError     string    `gorm:"type:text"`
+	NextRetryAt   time.Time `gorm:"index"` // When a pending notification is due
+	SentAt        *time.Time
+}
+
+// EmailPreference is how often an email address wants watcher alerts
+type EmailPreference struct {
+	gorm.Model
+	Email  string `gorm:"size:191;unique"`
+	Digest string `gorm:"size:16"` // immediate, daily or weekly
+}
+
+// WatchEvent is an alert waiting for the digest of the watcher email
+type WatchEvent struct {
+	gorm.Model
+	Email           string `gorm:"size:191;index"`
+	WatchProductID  uint   `gorm:"index"`
+	ProductID       uint   `gorm:"index"`
+	Kind            string `gorm:"size:32"` // price_drop or back_in_stock
+	ProductTitle    string
+	ProductURL      string
+	PriceOld        uint // Price drops only, in ISK
+	PriceNew        uint
+	Location        string // Back in stock at a location only
+	Locale          string `gorm:"size:8"` // the watch locale when the event happened
+	Date            time.Time
+}
+
+// UnsubscribeToken is the SHA-256 of the token in the unsubscribe link of an alert about a watch. Every alert
+// gets its own token, so only hashes are stored and the links in older alerts keep working
+type UnsubscribeToken struct {
+	gorm.Model
+	WatchProductID uint   `gorm:"index"`
+	Hash           string `gorm:"unique;size:64"`
+}
+
+// User is an account, logged in with links sent to the email. Watches with the email are the user's
+type User struct {
+	gorm.Model
+	Email       string `gorm:"size:191;unique"`
+	LoginHash   string `gorm:"size:64;index"` // SHA-256 of the token in the login link, empty when none is out
+	LoginSentAt *time.Time
+	LastLoginAt *time.Time
+}
+
+// Session is a logged in user, the token is sent as a bearer token
+type Session struct {
+	gorm.Model
+	UserID    uint      `gorm:"index"`
+	Token     string    `gorm:"size:64;unique"` // SHA-256 of the bearer token
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// PushSubscription is a browser a user gets watcher alerts in with Web Push
+type PushSubscription struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	Endpoint string `gorm:"type:text"` // Push service URL
+	P256dh   string // Browser public key, base64 URL
+	Auth     string // Auth secret, base64 URL
+}
+
 // Bot describes a website scraper robot
 type Bot struct {
 	gorm.Model
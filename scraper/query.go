@@ -0,0 +1,296 @@
+package scraper
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ProductQuery is a product listing, filters, sort and page. Every value ends up as a
+// query parameter, only whitelisted sort fields and fixed SQL are written into the statement
+type ProductQuery struct {
+	Sources       []string // Stores, any of them
+	Categories    []string // Category slugs, any of them, products in child categories are included
+	Brands        []string // Any of them
+	PriceFrom     uint     // In ISK, 0 for no limit
+	PriceTo       uint
+	UnitPriceFrom uint
+	UnitPriceTo   uint // Products without a unit price are left out when set
+	Unit          string
+	OnSale        *bool
+	InStock       *bool  // In stock at any location, or at Location when it's set
+	Location      string // In stock at this location, unless InStock is false
+	Sort          ProductSort
+	Limit         int
+	Offset        int     // Only for the pages without a Cursor
+	Cursor        *Cursor // Start after the last product of the previous page, nil for the first
+}
+
+// ProductSort is the order of a product listing, ties are ordered by ID in the same direction
+type ProductSort struct {
+	Field string // One of ProductSortFields, defaults to id
+	Desc  bool
+}
+
+// Name returns the sort as stored in cursors, ex. "price desc"
+func (s ProductSort) Name() string {
+	field := s.Field
+	if field == "" {
+		field = "id"
+	}
+
+	if s.Desc {
+		return field + " desc"
+	}
+	return field + " asc"
+}
+
+// sortKind is the type of a sort value
+type sortKind int
+
+const (
+	sortNumber sortKind = iota
+	sortText
+	sortTime
+)
+
+// sortField is how to order products by a ProductSort field
+type sortField struct {
+	expr    string // SQL expression
+	ascExpr string // Used instead of expr when ascending, if set
+	kind    sortKind
+	join    string // Extra join the expression needs
+}
+
+// popularityJoin is the view counts join the popularity sort needs
+const popularityJoin = "LEFT JOIN product_view_counts AS pvc ON p.id = pvc.product_id"
+
+// productSortFields are the fields products can be sorted by
+var productSortFields = map[string]sortField{
+	"id":          {expr: "p.id"},
+	"price":       {expr: "p.price_isk"},
+	"total_price": {expr: "p.total_price"},
+	// Products without a unit price go last
+	"unit_price": {expr: "p.unit_price", ascExpr: fmt.Sprintf("CASE WHEN p.unit_price = 0 THEN %d ELSE p.unit_price END", int64(math.MaxInt64))},
+	"discount":   {expr: "p.discount"},
+	"updated":    {expr: "p.updated_at", kind: sortTime},
+	"popularity": {expr: "COALESCE(pvc.views, 0)", join: popularityJoin},
+	"title":      {expr: "p.title", kind: sortText},
+	"price_drop": {expr: "CASE WHEN ppc.price_lower THEN ppc.price_diff ELSE 0 END"},
+}
+
+// ProductSortFields returns the names of all fields products can be sorted by
+func ProductSortFields() []string {
+	return []string{"id", "price", "total_price", "unit_price", "discount", "updated", "popularity", "title", "price_drop"}
+}
+
+// field returns how to sort, or an error if the field isn't whitelisted
+func (s ProductSort) field() (sortField, error) {
+	name := s.Field
+	if name == "" {
+		name = "id"
+	}
+
+	f, ok := productSortFields[name]
+	if !ok {
+		return sortField{}, fmt.Errorf("can't sort products by %s, use one of %s", s.Field, strings.Join(ProductSortFields(), ", "))
+	}
+
+	if !s.Desc && f.ascExpr != "" {
+		f.expr = f.ascExpr
+	}
+
+	return f, nil
+}
+
+// cursorArg returns the value in cursor as a query argument of the field type
+func (f sortField) cursorArg(cursor *Cursor) interface{} {
+	switch f.kind {
+	case sortText:
+		return cursor.Text
+	case sortTime:
+		return time.Unix(0, cursor.Value)
+	}
+
+	return cursor.Value
+}
+
+// cursor returns the cursor to the page after product
+func (f sortField) cursor(product ProductPriceDiff, sort string) *Cursor {
+	cursor := &Cursor{Sort: sort, ID: product.ID}
+	switch f.kind {
+	case sortText:
+		cursor.Text = product.Title
+	case sortTime:
+		cursor.Value = product.UpdatedAt.UnixNano()
+	default:
+		cursor.Value = product.CursorValue
+	}
+
+	return cursor
+}
+
+// inStmt returns "column IN (?,?)" and its args for values
+func inStmt(column string, values []string) (string, []interface{}) {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		args[i] = v
+	}
+
+	params := strings.TrimSuffix(strings.Repeat("?,", len(values)), ",")
+	return fmt.Sprintf("%s IN (%s)", column, params), args
+}
+
+// rangeStmt returns the WHERE condition for column between from and to, 0 is no limit
+func rangeStmt(column string, from, to uint) (string, []interface{}) {
+	switch {
+	case from > 0 && to > 0:
+		return fmt.Sprintf("%s >= ? AND %s <= ?", column, column), []interface{}{from, to}
+	case from > 0:
+		return fmt.Sprintf("%s >= ?", column), []interface{}{from}
+	case to > 0:
+		return fmt.Sprintf("%s <= ?", column), []interface{}{to}
+	}
+
+	return "", nil
+}
+
+// where returns the WHERE clause, without the keyset, and its args
+func (q ProductQuery) where() (string, []interface{}) {
+	var wheres []string
+	var args []interface{}
+	add := func(stmt string, stmtArgs ...interface{}) {
+		if stmt == "" {
+			return
+		}
+		wheres = append(wheres, fmt.Sprintf("(%s)", stmt))
+		args = append(args, stmtArgs...)
+	}
+
+	if len(q.Sources) > 0 {
+		stmt, stmtArgs := inStmt("p.source", q.Sources)
+		add(stmt, stmtArgs...)
+	}
+
+	// Child categories have their parents' rows too, EXISTS keeps products from repeating
+	if len(q.Categories) > 0 {
+		stmt, stmtArgs := inStmt("c.slug", q.Categories)
+		add(fmt.Sprintf("EXISTS (SELECT 1 FROM categories AS c WHERE c.product_id = p.id AND %s)", stmt), stmtArgs...)
+	}
+
+	if len(q.Brands) > 0 {
+		stmt, stmtArgs := inStmt("p.brand", q.Brands)
+		add(stmt, stmtArgs...)
+	}
+
+	// Price in ISK so prices in other currencies compare
+	stmt, stmtArgs := rangeStmt("p.price_isk", q.PriceFrom, q.PriceTo)
+	add(stmt, stmtArgs...)
+
+	stmt, stmtArgs = rangeStmt("p.unit_price", q.UnitPriceFrom, q.UnitPriceTo)
+	if q.UnitPriceTo > 0 {
+		stmt = "p.unit_price > 0 AND " + stmt
+	}
+	add(stmt, stmtArgs...)
+
+	if q.Unit != "" {
+		add("p.unit = ?", q.Unit)
+	}
+
+	if q.OnSale != nil {
+		add("p.on_sale = ?", *q.OnSale)
+	}
+
+	if q.InStock != nil || q.Location != "" {
+		inStock := "EXISTS (SELECT 1 FROM stocks AS s WHERE s.product_id = p.id AND s.in_stock = ?)"
+		inStockArgs := []interface{}{true}
+		if q.Location != "" {
+			inStock = "EXISTS (SELECT 1 FROM stocks AS s WHERE s.product_id = p.id AND s.location = ? AND s.in_stock = ?)"
+			inStockArgs = []interface{}{q.Location, true}
+		}
+		if q.InStock != nil && !*q.InStock {
+			inStock = "NOT " + inStock
+		}
+		add(inStock, inStockArgs...)
+	}
+
+	if len(wheres) == 0 {
+		return "", args
+	}
+
+	return "WHERE " + strings.Join(wheres, " AND "), args
+}
+
+// Build returns the statement and args to get a page of products
+func (q ProductQuery) Build() (string, []interface{}, error) {
+	f, err := q.Sort.field()
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = checkCursor(q.Cursor, q.Sort.Name(), q.Offset)
+	if err != nil {
+		return "", nil, err
+	}
+
+	where, args := q.where()
+
+	// Keyset, rows after the last one on the previous page
+	if q.Cursor != nil {
+		stmt, stmtArgs := keysetStmt(f.expr, "p.id", q.Sort.Desc, f.cursorArg(q.Cursor), q.Cursor.ID)
+		if where == "" {
+			where = "WHERE " + stmt
+		} else {
+			where = where + " AND " + stmt
+		}
+		args = append(args, stmtArgs...)
+	}
+
+	page, pageArgs := pageStmt(q.Limit, q.Offset, q.Cursor)
+	args = append(args, pageArgs...)
+
+	// Text and time values are read from the product for the next cursor
+	cursorValue := f.expr
+	if f.kind != sortNumber {
+		cursorValue = "0"
+	}
+
+	joins := []string{"LEFT JOIN product_price_changes AS ppc ON p.id = ppc.product_id"}
+	if f.join != "" {
+		joins = append(joins, f.join)
+	}
+
+	sql := fmt.Sprintf(
+		"SELECT p.*, ppc.price_diff, ppc.price_lower, %s AS cursor_value FROM products AS p %s %s ORDER BY %s %s",
+		cursorValue,
+		strings.Join(joins, " "),
+		where,
+		keysetOrderStmt(f.expr, "p.id", q.Sort.Desc),
+		page,
+	)
+
+	return strings.Join(strings.Fields(sql), " "), args, nil
+}
+
+// BuildCount returns the statement and args to count all products matching the filters
+func (q ProductQuery) BuildCount() (string, []interface{}) {
+	where, args := q.where()
+	sql := strings.Join(strings.Fields(fmt.Sprintf("SELECT count(*) AS count FROM products AS p %s", where)), " ")
+
+	return sql, args
+}
+
+// nextCursor returns the cursor to the page after products, nil when it's not a full page
+func (q ProductQuery) nextCursor(products []ProductPriceDiff) *Cursor {
+	if q.Limit <= 0 || len(products) < q.Limit {
+		return nil
+	}
+
+	f, err := q.Sort.field()
+	if err != nil {
+		return nil
+	}
+
+	return f.cursor(products[len(products)-1], q.Sort.Name())
+}
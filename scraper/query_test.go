@@ -0,0 +1,179 @@
+package scraper
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestProductQueryBuild(t *testing.T) {
+	yes := true
+	no := false
+	updated := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		query ProductQuery
+		sql   string
+		args  []interface{}
+	}{
+		{
+			"default",
+			ProductQuery{Limit: 10},
+			"SELECT p.*, ppc.price_diff, ppc.price_lower, p.id AS cursor_value FROM products AS p LEFT JOIN product_price_changes AS ppc ON p.id = ppc.product_id ORDER BY p.id ASC LIMIT ? OFFSET ?",
+			[]interface{}{10, 0},
+		},
+		{
+			"filters",
+			ProductQuery{
+				Sources:    []string{"elko.is", "ht.is"},
+				Categories: []string{"tolvur"},
+				Brands:     []string{"Bosch"},
+				PriceFrom:  1000,
+				PriceTo:    5000,
+				Unit:       "kg",
+				OnSale:     &yes,
+				InStock:    &no,
+				Sort:       ProductSort{Field: "price", Desc: true},
+				Limit:      10,
+				Offset:     20,
+			},
+			"SELECT p.*, ppc.price_diff, ppc.price_lower, p.price_isk AS cursor_value FROM products AS p LEFT JOIN product_price_changes AS ppc ON p.id = ppc.product_id " +
+				"WHERE (p.source IN (?,?)) AND (EXISTS (SELECT 1 FROM categories AS c WHERE c.product_id = p.id AND c.slug IN (?))) AND (p.brand IN (?)) " +
+				"AND (p.price_isk >= ? AND p.price_isk <= ?) AND (p.unit = ?) AND (p.on_sale = ?) " +
+				"AND (NOT EXISTS (SELECT 1 FROM stocks AS s WHERE s.product_id = p.id AND s.in_stock = ?)) " +
+				"ORDER BY p.price_isk DESC, p.id DESC LIMIT ? OFFSET ?",
+			[]interface{}{"elko.is", "ht.is", "tolvur", "Bosch", uint(1000), uint(5000), "kg", true, true, 10, 20},
+		},
+		{
+			"location",
+			ProductQuery{Location: "Lindir", Limit: 10},
+			"SELECT p.*, ppc.price_diff, ppc.price_lower, p.id AS cursor_value FROM products AS p LEFT JOIN product_price_changes AS ppc ON p.id = ppc.product_id " +
+				"WHERE (EXISTS (SELECT 1 FROM stocks AS s WHERE s.product_id = p.id AND s.location = ? AND s.in_stock = ?)) " +
+				"ORDER BY p.id ASC LIMIT ? OFFSET ?",
+			[]interface{}{"Lindir", true, 10, 0},
+		},
+		{
+			"unit price to",
+			ProductQuery{UnitPriceTo: 500, Sort: ProductSort{Field: "unit_price"}, Limit: 10},
+			"SELECT p.*, ppc.price_diff, ppc.price_lower, CASE WHEN p.unit_price = 0 THEN 9223372036854775807 ELSE p.unit_price END AS cursor_value FROM products AS p LEFT JOIN product_price_changes AS ppc ON p.id = ppc.product_id " +
+				"WHERE (p.unit_price > 0 AND p.unit_price <= ?) " +
+				"ORDER BY CASE WHEN p.unit_price = 0 THEN 9223372036854775807 ELSE p.unit_price END ASC, p.id ASC LIMIT ? OFFSET ?",
+			[]interface{}{uint(500), 10, 0},
+		},
+		{
+			"popularity cursor",
+			ProductQuery{Sort: ProductSort{Field: "popularity", Desc: true}, Cursor: &Cursor{Sort: "popularity desc", Value: 42, ID: 7}, Limit: 10},
+			"SELECT p.*, ppc.price_diff, ppc.price_lower, COALESCE(pvc.views, 0) AS cursor_value FROM products AS p LEFT JOIN product_price_changes AS ppc ON p.id = ppc.product_id " +
+				"LEFT JOIN product_view_counts AS pvc ON p.id = pvc.product_id " +
+				"WHERE (COALESCE(pvc.views, 0) < ? OR (COALESCE(pvc.views, 0) = ? AND p.id < ?)) " +
+				"ORDER BY COALESCE(pvc.views, 0) DESC, p.id DESC LIMIT ?",
+			[]interface{}{int64(42), int64(42), uint(7), 10},
+		},
+		{
+			"title cursor",
+			ProductQuery{OnSale: &yes, Sort: ProductSort{Field: "title"}, Cursor: &Cursor{Sort: "title asc", Text: "Ofn", ID: 3}, Limit: 5},
+			"SELECT p.*, ppc.price_diff, ppc.price_lower, 0 AS cursor_value FROM products AS p LEFT JOIN product_price_changes AS ppc ON p.id = ppc.product_id " +
+				"WHERE (p.on_sale = ?) AND (p.title > ? OR (p.title = ? AND p.id > ?)) " +
+				"ORDER BY p.title ASC, p.id ASC LIMIT ?",
+			[]interface{}{true, "Ofn", "Ofn", uint(3), 5},
+		},
+		{
+			"updated cursor",
+			ProductQuery{Sort: ProductSort{Field: "updated", Desc: true}, Cursor: &Cursor{Sort: "updated desc", Value: updated.UnixNano(), ID: 3}, Limit: 5},
+			"SELECT p.*, ppc.price_diff, ppc.price_lower, 0 AS cursor_value FROM products AS p LEFT JOIN product_price_changes AS ppc ON p.id = ppc.product_id " +
+				"WHERE (p.updated_at < ? OR (p.updated_at = ? AND p.id < ?)) " +
+				"ORDER BY p.updated_at DESC, p.id DESC LIMIT ?",
+			[]interface{}{time.Unix(0, updated.UnixNano()), time.Unix(0, updated.UnixNano()), uint(3), 5},
+		},
+		{
+			"id cursor",
+			ProductQuery{Cursor: &Cursor{Sort: "id asc", Value: 7, ID: 7}, Limit: 5},
+			"SELECT p.*, ppc.price_diff, ppc.price_lower, p.id AS cursor_value FROM products AS p LEFT JOIN product_price_changes AS ppc ON p.id = ppc.product_id " +
+				"WHERE p.id > ? ORDER BY p.id ASC LIMIT ?",
+			[]interface{}{uint(7), 5},
+		},
+		{
+			"price drop",
+			ProductQuery{Sort: ProductSort{Field: "price_drop", Desc: true}, Limit: 5},
+			"SELECT p.*, ppc.price_diff, ppc.price_lower, CASE WHEN ppc.price_lower THEN ppc.price_diff ELSE 0 END AS cursor_value FROM products AS p LEFT JOIN product_price_changes AS ppc ON p.id = ppc.product_id " +
+				"ORDER BY CASE WHEN ppc.price_lower THEN ppc.price_diff ELSE 0 END DESC, p.id DESC LIMIT ? OFFSET ?",
+			[]interface{}{5, 0},
+		},
+	}
+
+	for _, test := range tests {
+		sql, args, err := test.query.Build()
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if sql != test.sql {
+			t.Errorf("%s: got SQL\n%s\nwant\n%s", test.name, sql, test.sql)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: got args %v, want %v", test.name, args, test.args)
+		}
+	}
+}
+
+func TestProductQueryBuildCount(t *testing.T) {
+	yes := true
+	query := ProductQuery{
+		Sources: []string{"elko.is"},
+		InStock: &yes,
+		Sort:    ProductSort{Field: "title"},
+		Limit:   10,
+		Cursor:  &Cursor{Sort: "title asc", Text: "Ofn", ID: 3},
+	}
+
+	sql, args := query.BuildCount()
+	want := "SELECT count(*) AS count FROM products AS p WHERE (p.source IN (?)) AND (EXISTS (SELECT 1 FROM stocks AS s WHERE s.product_id = p.id AND s.in_stock = ?))"
+	if sql != want {
+		t.Errorf("Got SQL\n%s\nwant\n%s", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"elko.is", true}) {
+		t.Errorf("Got args %v", args)
+	}
+}
+
+func TestProductQueryBuildErrors(t *testing.T) {
+	tests := []ProductQuery{
+		{Sort: ProductSort{Field: "price_isk"}},
+		{Sort: ProductSort{Field: "id; DROP TABLE products"}},
+		{Sort: ProductSort{Field: "price"}, Cursor: &Cursor{Sort: "price desc"}},
+		{Sort: ProductSort{Field: "price"}, Cursor: &Cursor{Sort: "price asc"}, Offset: 20},
+	}
+
+	for _, query := range tests {
+		_, _, err := query.Build()
+		if err == nil {
+			t.Errorf("%+v: got no error", query)
+		}
+	}
+}
+
+func TestValuesNeverInSQL(t *testing.T) {
+	// Values that would break out of a string if they were written into the statement
+	evil := "x') OR 1=1 --"
+	query := ProductQuery{
+		Sources:    []string{evil},
+		Categories: []string{evil},
+		Brands:     []string{evil},
+		Unit:       evil,
+		Location:   evil,
+		Sort:       ProductSort{Field: "title"},
+		Cursor:     &Cursor{Sort: "title asc", Text: evil},
+		Limit:      10,
+	}
+
+	sql, args, err := query.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containsAny(sql, []string{evil, "'"}) {
+		t.Errorf("Value written into SQL: %s", sql)
+	}
+	if len(args) != 10 {
+		t.Errorf("Got %d args, want 10", len(args))
+	}
+}
@@ -0,0 +1,128 @@
+package scraper
+
+import "time"
+
+// ProductRepository reads and writes products, their child rows, counters and shipping rules
+type ProductRepository interface {
+	GetProducts(query ProductQuery) (*[]ProductPriceDiff, *Cursor, error)
+	GetProductsCount(query ProductQuery) (int, error)
+	GetProductByID(id uint) (*Product, error)
+	GetProductBySlug(slug string) (*Product, error)
+	GetProductsBySourceProductCode(source, productCode string) (*[]Product, error)
+	GetProductSpecs(id uint) (*[]Spec, error)
+	GetProductStocks(id uint) (*[]Stock, error)
+	GetProductStockHistory(id uint, from time.Time) (*[]StockChange, error)
+	GetProductImages(id uint) (*[]Image, error)
+	GetProductCategories(id uint) (*[]Category, error)
+	GetPopularProducts(limit, offset int, cursor *Cursor) (*[]ProductPriceDiff, *Cursor, error)
+	GetSuspiciousSaleProducts(limit, offset int, cursor *Cursor, sources []string) (*[]Product, *Cursor, error)
+	GetLastUpdatedProduct() (*Product, error)
+	UpdateOrCreateProduct(scrapedProduct *Product) (*Product, error)
+	UpdateOrCreateProducts(scrapedProducts []*Product) ([]*Product, []error, error)
+	DeleteProductByID(id uint) error
+	UpdateProductSuspiciousSale(id uint, suspicious bool, referencePrice uint) error
+	ClearSuspiciousSales() error
+	IncrementProductViewCount(id uint) error
+	GetProductViewCounts(limit int, afterID uint) (*[]ProductViewCount, error)
+	DeleteProductViewCountByID(id uint) error
+	CreateProductClickCount(productClickCount *ProductClickCount) error
+	GetShippingRules() (*[]ShippingRule, error)
+	GetShippingRuleBySource(source string) (*ShippingRule, error)
+	UpdateOrCreateShippingRule(rule *ShippingRule) error
+	UpdateAllProductsShipping() error
+}
+
+// PriceRepository reads product prices and price changes
+type PriceRepository interface {
+	GetProductPrices(id uint, from time.Time, desc bool) (*[]Price, error)
+	GetProductPriceStats(id uint, from time.Time) (*PriceStats, error)
+	GetProductsPriceChanges(limit, offset int, cursor *Cursor, lower string) (*[]ProductPriceDiff, *Cursor, error)
+	GetProductPriceChangeByProductID(productID uint) (*ProductPriceChange, error)
+	UpdateOrCreateProductPriceChange(productPriceChange *ProductPriceChange) error
+}
+
+// WatchRepository reads and writes emails watching products
+type WatchRepository interface {
+	CreateWatchProduct(watchProduct *WatchProduct) error
+	UpdateWatchProduct(watchProduct *WatchProduct) error
+	GetWatchProducts(limit int, afterID uint) (*[]WatchProduct, error)
+	GetWatchProductsByEmail(email string) (*[]WatchProduct, error)
+	GetWatchProductByID(id uint) (*WatchProduct, error)
+	GetWatchProductByVerifyHash(verifyHash string) (*WatchProduct, error)
+	CreateUnsubscribeToken(token *UnsubscribeToken) error
+	GetWatchProductByUnsubscribeHash(unsubscribeHash string) (*WatchProduct, error)
+	DeleteWatchProductByUnsubscribeHash(unsubscribeHash string) error
+	DeleteWatchProductByID(id uint) error
+	DeleteUnverifiedWatchProducts(sentBefore time.Time) (int64, error)
+}
+
+// UserRepository reads and writes user accounts, their sessions and push subscriptions
+type UserRepository interface {
+	GetUserByEmail(email string) (*User, error)
+	GetUserByLoginHash(loginHash string) (*User, error)
+	CreateUser(user *User) error
+	UpdateUser(user *User) error
+	CreateSession(session *Session) error
+	LoginUser(user *User, session *Session) error
+	GetUserBySessionToken(token string, now time.Time) (*User, error)
+	DeleteSession(token string) error
+	UpdateOrCreatePushSubscription(subscription *PushSubscription) error
+	GetPushSubscriptionsByEmail(email string) (*[]PushSubscription, error)
+	DeletePushSubscription(userID uint, endpoint string) error
+}
+
+// DigestRepository reads and writes email digest preferences and the alerts waiting for digests
+type DigestRepository interface {
+	GetEmailPreference(email string) (*EmailPreference, error)
+	UpdateOrCreateEmailPreference(preference *EmailPreference) error
+	CreateWatchEvent(event *WatchEvent) error
+	GetDigestEmails(digest string) (*[]string, error)
+	GetWatchEventsByEmail(email string) (*[]WatchEvent, error)
+	CreateDigestNotifications(notifications []*Notification, eventIDs []uint) error
+}
+
+// CategoryRepository reads unique categories
+type CategoryRepository interface {
+	GetUniqueCategories(parent string) (*[]UniqueCategory, error)
+	GetUniqueCategoryBySlug(slug string) (*UniqueCategory, error)
+}
+
+// BotRepository reads and writes when store scrapers ran
+type BotRepository interface {
+	GetBotByURL(URL string) (*Bot, error)
+	UpdateOrCreateBot(bot *Bot) error
+}
+
+// NotificationRepository reads and writes the notifications outbox
+type NotificationRepository interface {
+	CreateNotifications(notifications []*Notification) error
+	UpdateNotification(notification *Notification) error
+	GetDueNotifications(limit int, now time.Time) (*[]Notification, error)
+	GetNotifications(status string, limit, offset int) (*[]Notification, error)
+	RetryNotification(id uint, now time.Time) error
+}
+
+// Repository is everything stored in the SQL database, implemented by SQL
+type Repository interface {
+	ProductRepository
+	PriceRepository
+	WatchRepository
+	DigestRepository
+	UserRepository
+	CategoryRepository
+	BotRepository
+	NotificationRepository
+}
+
+// SearchRepository indexes and searches products, implemented by Elasticsearch
+type SearchRepository interface {
+	SearchForProduct(value string, filter SearchFilter, limit, offset int) (*[]SearchProduct, error)
+	UpdateOrIndexSearchProduct(product *SearchProduct) error
+	BulkIndexSearchProducts(products []*SearchProduct) error
+	DeleteSearchProductByID(id uint) error
+}
+
+var (
+	_ Repository       = &SQL{}
+	_ SearchRepository = &Elasticsearch{}
+)
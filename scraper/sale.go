@@ -0,0 +1,130 @@
+package scraper
+
+import (
+	"log"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/metrics"
+	"github.com/robfig/cron/v3"
+)
+
+// saleReferenceDays is how many days before a sale started are used for the reference price,
+// in the spirit of the EU Omnibus directive
+const saleReferenceDays int = 30
+
+// StartSaleChecker flags products on sale where the sale price is not lower
+// than the median price before the sale started
+func (s *Scraper) StartSaleChecker() error {
+	c := cron.New()
+	c.AddFunc("40 */6 * * *", func() { // At minute 40 past every 6th hour.
+		metrics.SaleCheckersRunning.Inc()
+		defer metrics.SaleCheckersRunning.Dec()
+
+		// Products that are no longer on sale can't be suspicious
+		err := s.DB.ClearSuspiciousSales()
+		if err != nil {
+			log.Print(err)
+		}
+
+		limit := 100
+		onSale := true
+		var cursor *Cursor
+
+		// From is 90 days, so a sale can have run for up to 60 days
+		now := time.Now()
+		from := now.AddDate(0, 0, -90)
+
+		for {
+			products, next, err := s.DB.GetProducts(ProductQuery{Limit: limit, Cursor: cursor, Sort: ProductSort{Desc: true}, OnSale: &onSale})
+			if err != nil {
+				log.Print(err)
+				break
+			}
+
+			if len(*products) == 0 {
+				break
+			}
+
+			for _, product := range *products {
+				prices, err := s.DB.GetProductPrices(product.ID, from, false)
+				if err != nil {
+					log.Print(err)
+					continue
+				}
+
+				suspicious, referencePrice := checkSale(*prices, product.OnSale)
+				if suspicious == product.SuspiciousSale && referencePrice == product.ReferencePrice {
+					continue
+				}
+
+				err = s.DB.UpdateProductSuspiciousSale(product.ID, suspicious, referencePrice)
+				if err != nil {
+					log.Print(err)
+					continue
+				}
+
+				if suspicious {
+					metrics.SuspiciousSalesFound.Inc()
+				}
+			}
+
+			// Keyset, so deleted or added products don't shift the pages
+			if next == nil {
+				break
+			}
+			cursor = next
+		}
+	})
+	c.Start()
+
+	return nil
+}
+
+// checkSale checks prices of a product on sale, ordered oldest first, and returns if the sale is suspicious
+// and the reference price, which is the median price over the days before the sale started.
+// The sale is assumed to have started at the last price change. When the price never changed over the
+// whole reference window a product on sale is only showing the regular price as a discount, so it's flagged
+// with the median price as the reference, with a shorter history there's not enough to tell
+func checkSale(prices []Price, onSale bool) (bool, uint) {
+	if len(prices) == 0 {
+		return false, 0
+	}
+
+	current := prices[len(prices)-1]
+
+	// Find where the current price started
+	saleStart := len(prices) - 1
+	for saleStart > 0 && prices[saleStart-1].Price == current.Price {
+		saleStart--
+	}
+
+	if saleStart == 0 {
+		if !onSale || prices[0].Date.After(current.Date.AddDate(0, 0, -saleReferenceDays)) {
+			return false, 0
+		}
+
+		values := make([]uint, len(prices))
+		for i, p := range prices {
+			values[i] = p.Price
+		}
+
+		return true, uint(medianPrice(values))
+	}
+
+	windowStart := prices[saleStart].Date.AddDate(0, 0, -saleReferenceDays)
+	var values []uint
+	for _, p := range prices[:saleStart] {
+		if p.Date.Before(windowStart) {
+			continue
+		}
+		values = append(values, p.Price)
+	}
+
+	if len(values) == 0 {
+		return false, 0
+	}
+
+	referencePrice := uint(medianPrice(values))
+
+	return current.Price >= referencePrice, referencePrice
+}
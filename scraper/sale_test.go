@@ -0,0 +1,44 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckSale(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		prices     []Price
+		onSale     bool
+		suspicious bool
+		reference  uint
+	}{
+		{"no prices", nil, true, false, 0},
+		{"real drop", pricesFromSeries(now, 2000, 2000, 2000, 2000, 1500, 1500), true, false, 2000},
+		{"fake discount after a raise", pricesFromSeries(now, 2000, 2000, 2000, 2500, 2500, 2000), true, true, 2000},
+		{"raise", pricesFromSeries(now, 2000, 2000, 2500), true, true, 2000},
+		{"no change on sale", pricesFromSeries(now, series(31, 2000)...), true, true, 2000},
+		{"no change not on sale", pricesFromSeries(now, series(31, 2000)...), false, false, 0},
+		{"single price on sale", pricesFromSeries(now, 2000), true, false, 0},
+		{"short history on sale", pricesFromSeries(now, series(30, 2000)...), true, false, 0},
+	}
+
+	for _, test := range tests {
+		suspicious, reference := checkSale(test.prices, test.onSale)
+		if suspicious != test.suspicious || reference != test.reference {
+			t.Errorf("%s: got %t %d, want %t %d", test.name, suspicious, reference, test.suspicious, test.reference)
+		}
+	}
+}
+
+// series returns n days of the same price
+func series(n int, price uint) []uint {
+	prices := make([]uint, n)
+	for i := range prices {
+		prices[i] = price
+	}
+
+	return prices
+}
@@ -11,6 +11,7 @@ import (
 
 	"bitbucket.org/hilmarp/price-scraper/formatters"
 	"bitbucket.org/hilmarp/price-scraper/metrics"
+	"bitbucket.org/hilmarp/price-scraper/notify"
 	"github.com/go-redis/redis/v8"
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/queue"
@@ -20,8 +21,8 @@ import (
 
 // Scraper handles scraping the web
 type Scraper struct {
-	DB              *SQL
-	ES              *Elasticsearch
+	DB              Repository
+	ES              SearchRepository
 	Redis           *Redis
 	Mongo           *Mongo
 	Stop            chan struct{}
@@ -32,6 +33,10 @@ type Scraper struct {
 	Storage         string
 	QueueStorage    string
 	RandomUserAgent bool
+	Currencies      *CurrencyRates
+	Writer          *ProductWriter
+	Channels        []notify.Channel // Watcher alerts, each one is delivered and retried on its own
+	UnverifiedTTL   time.Duration    // Unverified watches are deleted after, DefaultUnverifiedWatchTTL when 0
 }
 
 type onlineStore struct {
@@ -63,7 +68,7 @@ func (s *Scraper) StartScraper() error {
 	return nil
 }
 
-func createScrapeWorker(onlStore onlineStore, stackQueue, storageType, queueStorageType string, queueWorkers, stackParallel, redisDB int, randomUserAgent bool, mongo *Mongo, db *SQL) func() {
+func createScrapeWorker(onlStore onlineStore, stackQueue, storageType, queueStorageType string, queueWorkers, stackParallel, redisDB int, randomUserAgent bool, mongo *Mongo, db BotRepository) func() {
 	return func() {
 		startedAt := time.Now()
 		env := os.Getenv("PRICE_APP_ENV")
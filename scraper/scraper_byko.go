@@ -23,6 +23,7 @@ func (s *Scraper) bykoCallback(e *colly.HTMLElement) {
 	title := titleDom.Text()
 	code := e.ChildText(".productDetails_MainInformation_ProductNumber")
 	priceText := e.ChildText(".productDetails_MainInformation_Price .priceTag_Price")
+	listPriceText := e.ChildText(".productDetails_MainInformation_Price .crashOverOldPrice")
 	description := strings.TrimSpace(e.ChildText(".productDetails__descriptionContainer"))
 
 	// Images
@@ -37,11 +38,18 @@ func (s *Scraper) bykoCallback(e *colly.HTMLElement) {
 		mainImgURL = allImgURLs[0].URL
 	}
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// Specs
 	specs := make([]Spec, 0)
@@ -71,6 +79,7 @@ func (s *Scraper) bykoCallback(e *colly.HTMLElement) {
 		Description: description,
 		MainImgURL:  mainImgURL,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find(".crashOverOldPrice").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -79,7 +88,7 @@ func (s *Scraper) bykoCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(&product)
+	err = s.StoreProduct(&product)
 	if err != nil {
 		log.Println(err.Error())
 	}
@@ -17,6 +17,7 @@ func (s *Scraper) computerCallback(e *colly.HTMLElement) {
 
 	title := e.ChildText("h2.header-title")
 	priceText := e.ChildText(".pantavoru .displayPrice")
+	listPriceText := e.ChildText(".pantavoru .oldPrice")
 	description := strings.TrimSpace(e.ChildText(".preContent"))
 
 	// Code
@@ -31,11 +32,18 @@ func (s *Scraper) computerCallback(e *colly.HTMLElement) {
 		return
 	}
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// All images
 	imgSrcs := e.ChildAttrs(".productImg .product-image-main a", "href")
@@ -80,6 +88,7 @@ func (s *Scraper) computerCallback(e *colly.HTMLElement) {
 		Title:       title,
 		Description: description,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find(".discountRibbon").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -89,7 +98,7 @@ func (s *Scraper) computerCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(&product)
+	err = s.StoreProduct(&product)
 	if err != nil {
 		log.Println(err.Error())
 	}
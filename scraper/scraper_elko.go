@@ -23,6 +23,7 @@ func (s *Scraper) elkoCallback(e *colly.HTMLElement) {
 	title := e.ChildText("#product_title")
 	code := e.ChildText(".product-detail-content .product-code")
 	priceText := e.ChildText(".product-price-content .product-price")
+	listPriceText := e.ChildText(".product-price-content .product-price-before")
 	description := strings.TrimSpace(e.ChildText("#description"))
 
 	// All images
@@ -36,11 +37,18 @@ func (s *Scraper) elkoCallback(e *colly.HTMLElement) {
 		mainImgURL = allImgURLs[0].URL
 	}
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// Specs
 	specs := make([]Spec, 0)
@@ -103,6 +111,7 @@ func (s *Scraper) elkoCallback(e *colly.HTMLElement) {
 		Title:       title,
 		Description: description,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find(".product-price-content .product-discount").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -112,7 +121,7 @@ func (s *Scraper) elkoCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(&product)
+	err = s.StoreProduct(&product)
 	if err != nil {
 		log.Println(err.Error())
 	}
@@ -18,6 +18,7 @@ func (s *Scraper) epalCallback(e *colly.HTMLElement) {
 	title := e.ChildText("h1.product-title")
 	code := e.ChildText(".product_meta .sku_wrapper .sku")
 	priceText := e.ChildText(".price-wrapper .amount")
+	listPriceText := e.ChildText(".price-wrapper del .amount")
 	description := strings.TrimSpace(e.ChildText("#tab-description p"))
 
 	// Images
@@ -31,11 +32,18 @@ func (s *Scraper) epalCallback(e *colly.HTMLElement) {
 		mainImgURL = allImgURLs[0].URL
 	}
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// Specs
 	specs := make([]Spec, 0)
@@ -71,6 +79,7 @@ func (s *Scraper) epalCallback(e *colly.HTMLElement) {
 		Description: description,
 		MainImgURL:  mainImgURL,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find(".price-wrapper .price-on-sale").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -79,7 +88,7 @@ func (s *Scraper) epalCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(&product)
+	err = s.StoreProduct(&product)
 	if err != nil {
 		log.Println(err.Error())
 	}
@@ -21,6 +21,7 @@ func (s *Scraper) heimkaupCallback(e *colly.HTMLElement) {
 
 	title := e.ChildText(".Header-title h1")
 	priceText := e.DOM.Find(".SideDetails-basket .Price-price .Price").Contents().Not("s").Text()
+	listPriceText := e.DOM.Find(".SideDetails-basket .Price-price .Price s").Text()
 
 	if priceText == "" {
 		priceText = e.ChildAttr(".SideDetails-serialPayments #netgiro-serial", "data-amount")
@@ -32,11 +33,18 @@ func (s *Scraper) heimkaupCallback(e *colly.HTMLElement) {
 		description = strings.TrimSpace(e.ChildText(".ProductDetails-description.ProductDetails-section"))
 	}
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// Code
 	code := e.ChildText(".SideDetails-brand .Details-partNumber")
@@ -80,6 +88,7 @@ func (s *Scraper) heimkaupCallback(e *colly.HTMLElement) {
 		Description: description,
 		MainImgURL:  mainImgURL,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find(".SideDetails-basket .Price-discount").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -88,7 +97,7 @@ func (s *Scraper) heimkaupCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(product)
+	err = s.StoreProduct(product)
 	if err != nil {
 		log.Println(err.Error())
 	}
@@ -22,6 +22,7 @@ func (s *Scraper) htCallback(e *colly.HTMLElement) {
 	title := e.ChildText("h1.product-title")
 	code := e.ChildText(".product-nr")
 	priceText := e.ChildText(".product-price")
+	listPriceText := e.ChildText("#product .old-price") // Related products have old prices too
 	description := strings.TrimSpace(e.ChildText(".product-preDesc"))
 
 	// All images
@@ -35,11 +36,18 @@ func (s *Scraper) htCallback(e *colly.HTMLElement) {
 		mainImgURL = allImgURLs[0].URL
 	}
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// Specs
 	specs := make([]Spec, 0)
@@ -69,6 +77,7 @@ func (s *Scraper) htCallback(e *colly.HTMLElement) {
 		Title:       title,
 		Description: description,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find("#product .discount-percent").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -78,7 +87,7 @@ func (s *Scraper) htCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(&product)
+	err = s.StoreProduct(&product)
 	if err != nil {
 		log.Println(err.Error())
 	}
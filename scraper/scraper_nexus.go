@@ -19,12 +19,25 @@ func (s *Scraper) nexusCallback(e *colly.HTMLElement) {
 	code := e.ChildAttr(".tinv-wraper.woocommerce.tinv-wishlist.tinvwl-before-add-to-cart", "data-product_id")
 	description := strings.TrimSpace(e.ChildText("div#tab-description"))
 	priceText := e.ChildText(".summary.entry-summary .woocommerce-Price-amount.amount")
+	listPriceText := e.ChildText(".summary.entry-summary del .woocommerce-Price-amount.amount")
+
+	// On sale the list price is in del and the sale price in ins
+	if listPriceText != "" {
+		priceText = e.ChildText(".summary.entry-summary ins .woocommerce-Price-amount.amount")
+	}
+
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
 
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// All images
 	imgSrcs := e.ChildAttrs(".woocommerce-product-gallery .woocommerce-product-gallery__image img", "src")
@@ -67,7 +80,8 @@ func (s *Scraper) nexusCallback(e *colly.HTMLElement) {
 		Description: description,
 		MainImgURL:  mainImgURL,
 		Price:       price.Price,
-		OnSale:      false,
+		ListPrice:   price.ListPrice,
+		OnSale:      price.ListPrice > price.Price,
 		Specs:       specs,
 		Stocks:      stocks,
 		AllImgURLs:  allImgURLs,
@@ -75,7 +89,7 @@ func (s *Scraper) nexusCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(&product)
+	err = s.StoreProduct(&product)
 	if err != nil {
 		log.Println(err.Error())
 	}
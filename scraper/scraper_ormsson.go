@@ -20,17 +20,25 @@ func (s *Scraper) ormssonCallback(e *colly.HTMLElement) {
 
 	title := productDOM.Find("h1.h1-text").Text()
 	priceText := productDOM.Find(".thisprice").Not(".oldPrice").Text()
+	listPriceText := productDOM.Find(".thisprice.oldPrice").Text()
 	description := strings.TrimSpace(productDOM.Find(".precontent").Children().Text())
 
 	// Product code, looks like "vrn. SAQE55Q95TATXXC"
 	code := productDOM.Find(".productNr").Text()
 	code = strings.ReplaceAll(code, "vrn. ", "")
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// Images
 	imgs := productDOM.Find(".col-lg-5.col-md-6.col-sm-12 a[data-lightbox] img")
@@ -78,6 +86,7 @@ func (s *Scraper) ormssonCallback(e *colly.HTMLElement) {
 		Description: description,
 		MainImgURL:  mainImgURL,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      productDOM.Find(".oldPrice").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -86,7 +95,7 @@ func (s *Scraper) ormssonCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(product)
+	err = s.StoreProduct(product)
 	if err != nil {
 		log.Println(err.Error())
 	}
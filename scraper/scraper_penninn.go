@@ -22,16 +22,24 @@ func (s *Scraper) penninnCallback(e *colly.HTMLElement) {
 	title := e.ChildText(".gtm-details h1.hdln--larger")
 	description := strings.TrimSpace(e.ChildText(".field-type-text-with-summary p"))
 	priceText := e.ChildText(".commerce-price-savings-formatter-price .price-amount")
+	listPriceText := e.ChildText(".commerce-price-savings-formatter-list .price-amount")
 
 	// Code
 	code := e.ChildText(".prod-num")
 	code = strings.ReplaceAll(code, "Vörunúmer: ", "")
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// Stock
 	stocks := make([]Stock, 0)
@@ -72,6 +80,7 @@ func (s *Scraper) penninnCallback(e *colly.HTMLElement) {
 		Description: description,
 		MainImgURL:  mainImgURL,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find(".commerce-price-savings-formatter-savings").Length() > 0,
 		Specs:       []Spec{},
 		Stocks:      stocks,
@@ -80,7 +89,7 @@ func (s *Scraper) penninnCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(product)
+	err = s.StoreProduct(product)
 	if err != nil {
 		log.Println(err.Error())
 	}
@@ -18,13 +18,21 @@ func (s *Scraper) rafhaCallback(e *colly.HTMLElement) {
 	title := e.ChildText("h1.product_title")
 	code := e.ChildText(".loop-product-categories a")
 	priceText := e.ChildText(".electro-price ins .amount")
+	listPriceText := e.ChildText(".electro-price del .amount")
 	description := strings.TrimSpace(e.ChildText(".electro-description"))
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// All images
 	imgSrcs := e.ChildAttrs(".thumbnails-single.owl-carousel a", "href")
@@ -54,6 +62,7 @@ func (s *Scraper) rafhaCallback(e *colly.HTMLElement) {
 		Title:       title,
 		Description: description,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find(".electro-price del").Length() > 0,
 		Stocks:      stocks,
 		AllImgURLs:  allImgURLs,
@@ -62,7 +71,7 @@ func (s *Scraper) rafhaCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(&product)
+	err = s.StoreProduct(&product)
 	if err != nil {
 		log.Println(err.Error())
 	}
@@ -22,6 +22,7 @@ func (s *Scraper) raflandCallback(e *colly.HTMLElement) {
 	title := e.ChildText(".product-title h1")
 	code := e.ChildText(".product-nr")
 	priceText := e.ChildText(".addtocartform .btn-cart")
+	listPriceText := e.ChildText("#product .old-price") // Related products have old prices too
 
 	// Description and specs, it has the same layout
 	description := ""
@@ -70,11 +71,18 @@ func (s *Scraper) raflandCallback(e *colly.HTMLElement) {
 		mainImgURL = allImgURLs[0].URL
 	}
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// Stock
 	stock := e.ChildText(".product-status")
@@ -93,6 +101,7 @@ func (s *Scraper) raflandCallback(e *colly.HTMLElement) {
 		Title:       title,
 		Description: description,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find("#product .old-price").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -102,7 +111,7 @@ func (s *Scraper) raflandCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(&product)
+	err = s.StoreProduct(&product)
 	if err != nil {
 		log.Println(err.Error())
 	}
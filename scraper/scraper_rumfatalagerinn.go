@@ -18,6 +18,7 @@ func (s *Scraper) rumfatalagerinnCallback(e *colly.HTMLElement) {
 	title := e.ChildText("h1.new-product-main-header")
 	description := strings.TrimSpace(e.ChildText(".new-product-description-text-container"))
 	priceText := e.ChildText(".new-product-price__price")
+	listPriceText := e.ChildText(".new-product-price__offer-price strike")
 
 	// Code
 	code := e.ChildText(".new-product-main-title p")
@@ -35,11 +36,18 @@ func (s *Scraper) rumfatalagerinnCallback(e *colly.HTMLElement) {
 		mainImgURL = allImgURLs[0].URL
 	}
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// Specs
 	specs := make([]Spec, 0)
@@ -70,6 +78,7 @@ func (s *Scraper) rumfatalagerinnCallback(e *colly.HTMLElement) {
 		Description: description,
 		MainImgURL:  mainImgURL,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find(".new-product-price__offer-price strike").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -78,7 +87,7 @@ func (s *Scraper) rumfatalagerinnCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(&product)
+	err = s.StoreProduct(&product)
 	if err != nil {
 		log.Println(err.Error())
 	}
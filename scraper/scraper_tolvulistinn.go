@@ -17,17 +17,25 @@ func (s *Scraper) tolvulistinnCallback(e *colly.HTMLElement) {
 
 	title := e.ChildText(".product-title h1")
 	priceText := e.ChildText(".addtocartform .btn-cart")
+	listPriceText := e.ChildText(".old-price")
 	description := strings.TrimSpace(e.DOM.Find(".product-body-content").First().Text())
 
 	// Code
 	code := e.ChildText(".product-nr")
 	code = strings.ReplaceAll(code, "Vörunúmer : ", "")
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// All images
 	imgSrcs := e.ChildAttrs(".product-image-slider .image-item img", "src")
@@ -69,6 +77,7 @@ func (s *Scraper) tolvulistinnCallback(e *colly.HTMLElement) {
 		Description: description,
 		MainImgURL:  mainImgURL,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find(".old-price").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -77,7 +86,7 @@ func (s *Scraper) tolvulistinnCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(&product)
+	err = s.StoreProduct(&product)
 	if err != nil {
 		log.Println(err.Error())
 	}
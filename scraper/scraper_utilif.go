@@ -18,17 +18,25 @@ func (s *Scraper) utilifCallback(e *colly.HTMLElement) {
 	title := e.ChildText("h1.page-title")
 	code := e.ChildText(".product.attribute.sku .value")
 	priceText := e.ChildText(".product-info-price .normal-price .price")
+	listPriceText := e.ChildText(".product-info-price .old-price .price")
 	description := strings.TrimSpace(e.ChildText(".product.attribute.description .value"))
 
 	if priceText == "" {
 		priceText = e.ChildText("span.price")
 	}
 
+	parsedPrice, err := formatters.ParsePrice(priceText)
+	if err != nil {
+		log.Printf("Error parsing price for %s: %s\n", productURL, err.Error())
+		return
+	}
+
 	// Price at date
 	price := Price{
-		Price: formatters.StringToPrice(priceText),
+		Price: parsedPrice,
 		Date:  time.Now(),
 	}
+	price.ListPrice = formatters.StringToListPrice(listPriceText, price.Price)
 
 	// All images
 	imgSrcs := e.ChildAttrs(".MagicToolboxContainer a.mt-thumb-switcher", "href")
@@ -74,6 +82,7 @@ func (s *Scraper) utilifCallback(e *colly.HTMLElement) {
 		Description: description,
 		MainImgURL:  mainImgURL,
 		Price:       price.Price,
+		ListPrice:   price.ListPrice,
 		OnSale:      e.DOM.Find(".product-info-price .old-price").Length() > 0,
 		Specs:       specs,
 		Stocks:      stocks,
@@ -82,7 +91,7 @@ func (s *Scraper) utilifCallback(e *colly.HTMLElement) {
 		Categories:  categories,
 	}
 
-	err := s.StoreProduct(product)
+	err = s.StoreProduct(product)
 	if err != nil {
 		log.Println(err.Error())
 	}
@@ -0,0 +1,1572 @@
+// Package scrapertest has in-memory fakes of the scraper repositories for tests
+package scrapertest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/scraper"
+	"gorm.io/gorm"
+)
+
+// Repository is an in-memory scraper.Repository, not found errors are gorm.ErrRecordNotFound
+// like with scraper.SQL. The zero value is ready to use
+type Repository struct {
+	mu                sync.Mutex
+	lastIDs           map[string]uint
+	products          []*scraper.Product
+	prices            []scraper.Price
+	stockChanges      []scraper.StockChange
+	priceChanges      []scraper.ProductPriceChange
+	viewCounts        []scraper.ProductViewCount
+	clickCounts       []scraper.ProductClickCount
+	watchProducts     []scraper.WatchProduct
+	uniqueCategories  []scraper.UniqueCategory
+	bots              []scraper.Bot
+	shippingRules     []scraper.ShippingRule
+	notifications     []scraper.Notification
+	emailPreferences  []scraper.EmailPreference
+	watchEvents       []scraper.WatchEvent
+	unsubscribeTokens []scraper.UnsubscribeToken
+	users             []scraper.User
+	sessions          []scraper.Session
+	pushes            []scraper.PushSubscription
+}
+
+var _ scraper.Repository = &Repository{}
+
+// newModel returns a gorm.Model with the next ID in table and timestamps set to now
+func (r *Repository) newModel(table string) gorm.Model {
+	if r.lastIDs == nil {
+		r.lastIDs = make(map[string]uint)
+	}
+	r.lastIDs[table]++
+
+	now := time.Now()
+	return gorm.Model{ID: r.lastIDs[table], CreatedAt: now, UpdatedAt: now}
+}
+
+func (r *Repository) findProduct(match func(p *scraper.Product) bool) (*scraper.Product, error) {
+	for _, p := range r.products {
+		if match(p) {
+			product := *p
+			return &product, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// priceDiff returns product with its price change, if any
+func (r *Repository) priceDiff(p *scraper.Product) scraper.ProductPriceDiff {
+	diff := scraper.ProductPriceDiff{Product: *p}
+	for _, c := range r.priceChanges {
+		if c.ProductID == p.ID {
+			diff.PriceDiff = uint(c.PriceDiff)
+			diff.PriceLower = c.PriceLower
+		}
+	}
+
+	return diff
+}
+
+// filterProducts returns products matching the same filters as scraper.SQL GetProducts
+func (r *Repository) filterProducts(query scraper.ProductQuery) []scraper.ProductPriceDiff {
+	var products []scraper.ProductPriceDiff
+	for _, p := range r.products {
+		if query.PriceFrom > 0 && p.PriceISK < query.PriceFrom || query.PriceTo > 0 && p.PriceISK > query.PriceTo {
+			continue
+		}
+		if query.UnitPriceFrom > 0 && p.UnitPrice < query.UnitPriceFrom {
+			continue
+		}
+		if query.UnitPriceTo > 0 && (p.UnitPrice == 0 || p.UnitPrice > query.UnitPriceTo) {
+			continue
+		}
+		if query.Unit != "" && p.Unit != query.Unit {
+			continue
+		}
+		if query.OnSale != nil && p.OnSale != *query.OnSale {
+			continue
+		}
+		if (query.InStock != nil || query.Location != "") && inStock(p, query.Location) != (query.InStock == nil || *query.InStock) {
+			continue
+		}
+		if len(query.Sources) > 0 && !contains(query.Sources, p.Source) {
+			continue
+		}
+		if len(query.Brands) > 0 && !contains(query.Brands, p.Brand) {
+			continue
+		}
+		if len(query.Categories) > 0 && !hasCategory(p, query.Categories) {
+			continue
+		}
+
+		products = append(products, r.priceDiff(p))
+	}
+
+	return products
+}
+
+// sortKey returns the values products are sorted by, a number or text,
+// products without a unit price go last
+func (r *Repository) sortKey(productSort scraper.ProductSort) func(p scraper.ProductPriceDiff) (int64, string) {
+	return func(p scraper.ProductPriceDiff) (int64, string) {
+		switch productSort.Field {
+		case "price":
+			return int64(p.PriceISK), ""
+		case "total_price":
+			return int64(p.TotalPrice), ""
+		case "unit_price":
+			if p.UnitPrice == 0 && !productSort.Desc {
+				return math.MaxInt64, ""
+			}
+			return int64(p.UnitPrice), ""
+		case "discount":
+			return int64(p.Discount), ""
+		case "updated":
+			return p.UpdatedAt.UnixNano(), ""
+		case "popularity":
+			for _, v := range r.viewCounts {
+				if v.ProductID == p.ID {
+					return int64(v.Views), ""
+				}
+			}
+			return 0, ""
+		case "title":
+			return 0, p.Title
+		case "price_drop":
+			if p.PriceLower {
+				return int64(p.PriceDiff), ""
+			}
+			return 0, ""
+		}
+
+		return int64(p.ID), ""
+	}
+}
+
+// numberKey makes a sortKey of a number
+func numberKey(value func(p scraper.ProductPriceDiff) int64) func(p scraper.ProductPriceDiff) (int64, string) {
+	return func(p scraper.ProductPriceDiff) (int64, string) {
+		return value(p), ""
+	}
+}
+
+// keysetPage sorts products by key and then ID, and returns the limit after cursor and offset
+// with the cursor to the next page, like scraper.SQL
+func keysetPage(products []scraper.ProductPriceDiff, key func(p scraper.ProductPriceDiff) (int64, string), desc bool, limit, offset int, cursor *scraper.Cursor, sortName string) (*[]scraper.ProductPriceDiff, *scraper.Cursor, error) {
+	if cursor != nil && cursor.Sort != sortName {
+		return nil, nil, scraper.ErrInvalidCursor
+	}
+	if cursor != nil && offset != 0 {
+		return nil, nil, scraper.ErrCursorOffset
+	}
+
+	// compare returns -1, 0 or 1 for a sorted before, same as or after b
+	compare := func(aValue int64, aText string, aID uint, bValue int64, bText string, bID uint) int {
+		switch {
+		case aValue != bValue:
+			if aValue < bValue {
+				return -1
+			}
+			return 1
+		case aText != bText:
+			return strings.Compare(aText, bText)
+		case aID != bID:
+			if aID < bID {
+				return -1
+			}
+			return 1
+		}
+		return 0
+	}
+	if desc {
+		asc := compare
+		compare = func(aValue int64, aText string, aID uint, bValue int64, bText string, bID uint) int {
+			return -asc(aValue, aText, aID, bValue, bText, bID)
+		}
+	}
+
+	sort.SliceStable(products, func(i, j int) bool {
+		iValue, iText := key(products[i])
+		jValue, jText := key(products[j])
+		return compare(iValue, iText, products[i].ID, jValue, jText, products[j].ID) < 0
+	})
+
+	if cursor != nil {
+		var rest []scraper.ProductPriceDiff
+		for _, p := range products {
+			value, text := key(p)
+			if compare(value, text, p.ID, cursor.Value, cursor.Text, cursor.ID) > 0 {
+				rest = append(rest, p)
+			}
+		}
+		products = rest
+	}
+
+	start, end := page(len(products), limit, offset)
+	products = products[start:end]
+
+	var next *scraper.Cursor
+	if limit > 0 && len(products) == limit {
+		last := products[len(products)-1]
+		value, text := key(last)
+		next = &scraper.Cursor{Sort: sortName, Value: value, Text: text, ID: last.ID}
+	}
+
+	return &products, next, nil
+}
+
+// inStock returns if p is in stock at location, or at any location when it's empty
+func inStock(p *scraper.Product, location string) bool {
+	for _, s := range p.Stocks {
+		if s.InStock && (location == "" || s.Location == location) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasCategory(p *scraper.Product, slugs []string) bool {
+	for _, c := range p.Categories {
+		if contains(slugs, c.Slug) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// page returns the limit and offset part of n items
+func page(n, limit, offset int) (int, int) {
+	if offset > n {
+		offset = n
+	}
+	end := offset + limit
+	if end > n {
+		end = n
+	}
+
+	return offset, end
+}
+
+// GetProducts returns a page of products matching query
+func (r *Repository) GetProducts(query scraper.ProductQuery) (*[]scraper.ProductPriceDiff, *scraper.Cursor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !contains(append(scraper.ProductSortFields(), ""), query.Sort.Field) {
+		return nil, nil, fmt.Errorf("can't sort products by %s", query.Sort.Field)
+	}
+
+	products := r.filterProducts(query)
+
+	return keysetPage(products, r.sortKey(query.Sort), query.Sort.Desc, query.Limit, query.Offset, query.Cursor, query.Sort.Name())
+}
+
+// GetProductsCount returns the total count of products matching query filters
+func (r *Repository) GetProductsCount(query scraper.ProductQuery) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.filterProducts(query)), nil
+}
+
+// GetProductByID returns a single product
+func (r *Repository) GetProductByID(id uint) (*scraper.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.findProduct(func(p *scraper.Product) bool { return p.ID == id })
+}
+
+// GetProductBySlug returns a single product
+func (r *Repository) GetProductBySlug(slug string) (*scraper.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.findProduct(func(p *scraper.Product) bool { return p.Slug == slug })
+}
+
+// GetProductsBySourceProductCode returns products with source and product code
+func (r *Repository) GetProductsBySourceProductCode(source, productCode string) (*[]scraper.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var products []scraper.Product
+	for _, p := range r.products {
+		if p.Source == source && p.ProductCode == productCode {
+			products = append(products, *p)
+		}
+	}
+
+	return &products, nil
+}
+
+// GetProductSpecs returns specs for a product
+func (r *Repository) GetProductSpecs(id uint) (*[]scraper.Spec, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	specs := []scraper.Spec{}
+	product, err := r.findProduct(func(p *scraper.Product) bool { return p.ID == id })
+	if err == nil {
+		specs = append(specs, product.Specs...)
+	}
+
+	return &specs, nil
+}
+
+// GetProductStocks returns stock info for a product
+func (r *Repository) GetProductStocks(id uint) (*[]scraper.Stock, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stocks := []scraper.Stock{}
+	product, err := r.findProduct(func(p *scraper.Product) bool { return p.ID == id })
+	if err == nil {
+		stocks = append(stocks, product.Stocks...)
+	}
+
+	return &stocks, nil
+}
+
+// GetProductStockHistory returns stock changes for a product since from, oldest first
+func (r *Repository) GetProductStockHistory(id uint, from time.Time) (*[]scraper.StockChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changes := []scraper.StockChange{}
+	for _, c := range r.stockChanges {
+		if c.ProductID == id && !c.CreatedAt.Before(from) {
+			changes = append(changes, c)
+		}
+	}
+
+	return &changes, nil
+}
+
+// GetProductImages returns images for a product
+func (r *Repository) GetProductImages(id uint) (*[]scraper.Image, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	images := []scraper.Image{}
+	product, err := r.findProduct(func(p *scraper.Product) bool { return p.ID == id })
+	if err == nil {
+		images = append(images, product.AllImgURLs...)
+	}
+
+	return &images, nil
+}
+
+// GetProductCategories returns categories for a product
+func (r *Repository) GetProductCategories(id uint) (*[]scraper.Category, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	categories := []scraper.Category{}
+	product, err := r.findProduct(func(p *scraper.Product) bool { return p.ID == id })
+	if err == nil {
+		categories = append(categories, product.Categories...)
+	}
+
+	return &categories, nil
+}
+
+// GetPopularProducts returns products with views, most viewed first
+func (r *Repository) GetPopularProducts(limit, offset int, cursor *scraper.Cursor) (*[]scraper.ProductPriceDiff, *scraper.Cursor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	views := make(map[uint]int)
+	var products []scraper.ProductPriceDiff
+	for _, v := range r.viewCounts {
+		for _, p := range r.products {
+			if p.ID == v.ProductID && v.Views > 0 {
+				views[p.ID] = v.Views
+				products = append(products, r.priceDiff(p))
+			}
+		}
+	}
+
+	value := func(p scraper.ProductPriceDiff) int64 {
+		return int64(views[p.ID])
+	}
+
+	return keysetPage(products, numberKey(value), true, limit, offset, cursor, "views desc")
+}
+
+// GetSuspiciousSaleProducts returns products flagged with a suspicious sale, by source and newest first in each
+func (r *Repository) GetSuspiciousSaleProducts(limit, offset int, cursor *scraper.Cursor, sources []string) (*[]scraper.Product, *scraper.Cursor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sortName := "source asc"
+	if cursor != nil && cursor.Sort != sortName {
+		return nil, nil, scraper.ErrInvalidCursor
+	}
+	if cursor != nil && offset != 0 {
+		return nil, nil, scraper.ErrCursorOffset
+	}
+
+	// before returns if a is listed before b
+	before := func(aSource string, aID uint, bSource string, bID uint) bool {
+		if aSource != bSource {
+			return aSource < bSource
+		}
+		return aID > bID
+	}
+
+	var products []scraper.Product
+	for _, p := range r.products {
+		if !p.SuspiciousSale || len(sources) > 0 && !contains(sources, p.Source) {
+			continue
+		}
+		if cursor != nil && !before(cursor.Text, cursor.ID, p.Source, p.ID) {
+			continue
+		}
+		products = append(products, *p)
+	}
+
+	sort.SliceStable(products, func(i, j int) bool {
+		return before(products[i].Source, products[i].ID, products[j].Source, products[j].ID)
+	})
+
+	start, end := page(len(products), limit, offset)
+	products = products[start:end]
+
+	var next *scraper.Cursor
+	if limit > 0 && len(products) == limit {
+		last := products[len(products)-1]
+		next = &scraper.Cursor{Sort: sortName, Text: last.Source, ID: last.ID}
+	}
+
+	return &products, next, nil
+}
+
+// GetLastUpdatedProduct returns the last updated product
+func (r *Repository) GetLastUpdatedProduct() (*scraper.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var last *scraper.Product
+	for _, p := range r.products {
+		if last == nil || !p.UpdatedAt.Before(last.UpdatedAt) {
+			last = p
+		}
+	}
+
+	if last == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	product := *last
+	return &product, nil
+}
+
+// UpdateOrCreateProduct updates the product with the same URL or creates it
+func (r *Repository) UpdateOrCreateProduct(scrapedProduct *scraper.Product) (*scraper.Product, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.upsertProduct(scrapedProduct), nil
+}
+
+// UpdateOrCreateProducts is UpdateOrCreateProduct for many products, it never fails
+func (r *Repository) UpdateOrCreateProducts(scrapedProducts []*scraper.Product) ([]*scraper.Product, []error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := make([]*scraper.Product, len(scrapedProducts))
+	for i, p := range scrapedProducts {
+		stored[i] = r.upsertProduct(p)
+	}
+
+	return stored, make([]error, len(scrapedProducts)), nil
+}
+
+func (r *Repository) upsertProduct(scrapedProduct *scraper.Product) *scraper.Product {
+	for _, c := range scrapedProduct.Categories {
+		found := false
+		for _, uc := range r.uniqueCategories {
+			if uc.Name == c.Name && uc.Slug == c.Slug && uc.Parent == c.Parent {
+				found = true
+			}
+		}
+		if !found {
+			r.uniqueCategories = append(r.uniqueCategories, scraper.UniqueCategory{
+				Model:  r.newModel("unique_categories"),
+				Name:   c.Name,
+				Slug:   c.Slug,
+				Parent: c.Parent,
+			})
+		}
+	}
+
+	index := -1
+	for i, p := range r.products {
+		if p.URL == scrapedProduct.URL {
+			index = i
+		}
+	}
+
+	var storedStocks []scraper.Stock
+	if index != -1 {
+		storedStocks = r.products[index].Stocks
+	}
+
+	product := *scrapedProduct
+	product.Prices = nil
+	if index == -1 {
+		product.Model = r.newModel("products")
+		r.products = append(r.products, &product)
+	} else {
+		product.Model = r.products[index].Model
+		product.UpdatedAt = time.Now()
+		r.products[index] = &product
+	}
+
+	product.Specs = append([]scraper.Spec{}, product.Specs...)
+	for i := range product.Specs {
+		product.Specs[i].Model = r.newModel("specs")
+		product.Specs[i].ProductID = product.ID
+	}
+	product.Stocks = append([]scraper.Stock{}, product.Stocks...)
+	for i := range product.Stocks {
+		product.Stocks[i].Model = r.newModel("stocks")
+		product.Stocks[i].ProductID = product.ID
+	}
+	for _, change := range scraper.DiffStockChanges(product.ID, storedStocks, product.Stocks) {
+		change.Model = r.newModel("stock_changes")
+		r.stockChanges = append(r.stockChanges, change)
+	}
+	product.AllImgURLs = append([]scraper.Image{}, product.AllImgURLs...)
+	for i := range product.AllImgURLs {
+		product.AllImgURLs[i].Model = r.newModel("images")
+		product.AllImgURLs[i].ProductID = product.ID
+	}
+	product.Categories = append([]scraper.Category{}, product.Categories...)
+	for i := range product.Categories {
+		product.Categories[i].Model = r.newModel("categories")
+		product.Categories[i].ProductID = product.ID
+	}
+
+	// Only the first price is new, same as scraper.SQL
+	for i, price := range scrapedProduct.Prices {
+		if i > 0 && index != -1 {
+			break
+		}
+		price.Model = r.newModel("prices")
+		price.ProductID = product.ID
+		r.prices = append(r.prices, price)
+	}
+
+	scrapedProduct.Model = product.Model
+	stored := product
+	return &stored
+}
+
+// DeleteProductByID deletes a product and everything belonging to it
+func (r *Repository) DeleteProductByID(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var products []*scraper.Product
+	for _, p := range r.products {
+		if p.ID != id {
+			products = append(products, p)
+		}
+	}
+	r.products = products
+
+	var prices []scraper.Price
+	for _, p := range r.prices {
+		if p.ProductID != id {
+			prices = append(prices, p)
+		}
+	}
+	r.prices = prices
+
+	var stockChanges []scraper.StockChange
+	for _, c := range r.stockChanges {
+		if c.ProductID != id {
+			stockChanges = append(stockChanges, c)
+		}
+	}
+	r.stockChanges = stockChanges
+
+	var watchProducts []scraper.WatchProduct
+	for _, w := range r.watchProducts {
+		if w.ProductID != id {
+			watchProducts = append(watchProducts, w)
+		}
+	}
+	r.watchProducts = watchProducts
+
+	var watchEvents []scraper.WatchEvent
+	for _, e := range r.watchEvents {
+		if e.ProductID != id {
+			watchEvents = append(watchEvents, e)
+		}
+	}
+	r.watchEvents = watchEvents
+
+	return nil
+}
+
+// UpdateProductSuspiciousSale sets the suspicious sale flag and reference price on a product
+func (r *Repository) UpdateProductSuspiciousSale(id uint, suspicious bool, referencePrice uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.products {
+		if p.ID == id {
+			p.SuspiciousSale = suspicious
+			p.ReferencePrice = referencePrice
+		}
+	}
+
+	return nil
+}
+
+// ClearSuspiciousSales removes the suspicious sale flag from products that are no longer on sale
+func (r *Repository) ClearSuspiciousSales() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.products {
+		if p.SuspiciousSale && !p.OnSale {
+			p.SuspiciousSale = false
+			p.ReferencePrice = 0
+		}
+	}
+
+	return nil
+}
+
+// IncrementProductViewCount adds one view to a product
+func (r *Repository) IncrementProductViewCount(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.viewCounts {
+		if r.viewCounts[i].ProductID == id {
+			r.viewCounts[i].Views++
+			return nil
+		}
+	}
+
+	r.viewCounts = append(r.viewCounts, scraper.ProductViewCount{Model: r.newModel("product_view_counts"), ProductID: id, Views: 1})
+
+	return nil
+}
+
+// GetProductViewCounts returns a limit of view counters with ID after afterID
+func (r *Repository) GetProductViewCounts(limit int, afterID uint) (*[]scraper.ProductViewCount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	viewCounts := []scraper.ProductViewCount{}
+	for _, v := range r.viewCounts {
+		if v.ID > afterID && len(viewCounts) < limit {
+			viewCounts = append(viewCounts, v)
+		}
+	}
+
+	return &viewCounts, nil
+}
+
+// DeleteProductViewCountByID deletes a view counter
+func (r *Repository) DeleteProductViewCountByID(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var viewCounts []scraper.ProductViewCount
+	for _, v := range r.viewCounts {
+		if v.ID != id {
+			viewCounts = append(viewCounts, v)
+		}
+	}
+	r.viewCounts = viewCounts
+
+	return nil
+}
+
+// CreateProductClickCount stores a product click
+func (r *Repository) CreateProductClickCount(productClickCount *scraper.ProductClickCount) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	productClickCount.Model = r.newModel("product_click_counts")
+	r.clickCounts = append(r.clickCounts, *productClickCount)
+
+	return nil
+}
+
+// ClickCount returns how many clicks product has
+func (r *Repository) ClickCount(productID uint) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, c := range r.clickCounts {
+		if c.ProductID == productID {
+			count++
+		}
+	}
+
+	return count
+}
+
+// GetShippingRules returns all store shipping rules
+func (r *Repository) GetShippingRules() (*[]scraper.ShippingRule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rules := append([]scraper.ShippingRule{}, r.shippingRules...)
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Source < rules[j].Source
+	})
+
+	return &rules, nil
+}
+
+// GetShippingRuleBySource returns the shipping rule for a store
+func (r *Repository) GetShippingRuleBySource(source string) (*scraper.ShippingRule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rule := range r.shippingRules {
+		if rule.Source == source {
+			found := rule
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// UpdateOrCreateShippingRule saves the shipping rule for a store and applies it to the store products
+func (r *Repository) UpdateOrCreateShippingRule(rule *scraper.ShippingRule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	found := false
+	for i := range r.shippingRules {
+		if r.shippingRules[i].Source == rule.Source {
+			rule.Model = r.shippingRules[i].Model
+			r.shippingRules[i] = *rule
+			found = true
+		}
+	}
+	if !found {
+		rule.Model = r.newModel("shipping_rules")
+		r.shippingRules = append(r.shippingRules, *rule)
+	}
+
+	r.applyShipping()
+
+	return nil
+}
+
+// UpdateAllProductsShipping applies all shipping rules
+func (r *Repository) UpdateAllProductsShipping() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.applyShipping()
+
+	return nil
+}
+
+func (r *Repository) applyShipping() {
+	for _, p := range r.products {
+		p.ShippingCost = 0
+		p.FreePickup = false
+		for _, rule := range r.shippingRules {
+			if rule.Source == p.Source {
+				p.ShippingCost = rule.Cost(p.PriceISK)
+				p.FreePickup = rule.FreePickup
+			}
+		}
+		p.TotalPrice = p.PriceISK + p.ShippingCost
+	}
+}
+
+// GetProductPrices returns prices for a product from date, oldest first or newest first when desc
+func (r *Repository) GetProductPrices(id uint, from time.Time, desc bool) (*[]scraper.Price, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	prices := []scraper.Price{}
+	for _, p := range r.prices {
+		if p.ProductID == id && !p.Date.Before(from) {
+			prices = append(prices, p)
+		}
+	}
+
+	if desc {
+		sort.SliceStable(prices, func(i, j int) bool {
+			return prices[i].ID > prices[j].ID
+		})
+	}
+
+	return &prices, nil
+}
+
+// GetProductPriceStats returns price statistics for a product from date until now
+func (r *Repository) GetProductPriceStats(id uint, from time.Time) (*scraper.PriceStats, error) {
+	prices, err := r.GetProductPrices(id, from, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// The price in effect when the window starts
+	r.mu.Lock()
+	var before *scraper.Price
+	for _, p := range r.prices {
+		if p.ProductID == id && p.Date.Before(from) && (before == nil || !p.Date.Before(before.Date)) {
+			p := p
+			before = &p
+		}
+	}
+	r.mu.Unlock()
+
+	stats := scraper.CalculatePriceStats(before, *prices, from, time.Now())
+	stats.ProductID = id
+
+	return &stats, nil
+}
+
+// GetProductsPriceChanges returns products that have changed in price, biggest change first
+func (r *Repository) GetProductsPriceChanges(limit, offset int, cursor *scraper.Cursor, lower string) (*[]scraper.ProductPriceDiff, *scraper.Cursor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var products []scraper.ProductPriceDiff
+	for _, p := range r.products {
+		diff := r.priceDiff(p)
+		if diff.PriceDiff == 0 || lower == "true" && !diff.PriceLower || lower == "false" && diff.PriceLower {
+			continue
+		}
+		products = append(products, diff)
+	}
+
+	value := func(p scraper.ProductPriceDiff) int64 {
+		return int64(p.PriceDiff)
+	}
+
+	return keysetPage(products, numberKey(value), true, limit, offset, cursor, "price_diff desc")
+}
+
+// GetProductPriceChangeByProductID returns the price change of a product
+func (r *Repository) GetProductPriceChangeByProductID(productID uint) (*scraper.ProductPriceChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.priceChanges {
+		if c.ProductID == productID {
+			found := c
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// UpdateOrCreateProductPriceChange saves the price change of a product
+func (r *Repository) UpdateOrCreateProductPriceChange(productPriceChange *scraper.ProductPriceChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.priceChanges {
+		if r.priceChanges[i].ProductID == productPriceChange.ProductID {
+			productPriceChange.Model = r.priceChanges[i].Model
+			r.priceChanges[i] = *productPriceChange
+			return nil
+		}
+	}
+
+	productPriceChange.Model = r.newModel("product_price_changes")
+	r.priceChanges = append(r.priceChanges, *productPriceChange)
+
+	return nil
+}
+
+// CreateWatchProduct stores an email watching a product
+func (r *Repository) CreateWatchProduct(watchProduct *scraper.WatchProduct) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	watchProduct.Model = r.newModel("watch_products")
+	r.watchProducts = append(r.watchProducts, *watchProduct)
+
+	return nil
+}
+
+// UpdateWatchProduct updates a watch with the same ID
+func (r *Repository) UpdateWatchProduct(watchProduct *scraper.WatchProduct) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.watchProducts {
+		if r.watchProducts[i].ID == watchProduct.ID {
+			r.watchProducts[i] = *watchProduct
+			return nil
+		}
+	}
+
+	return gorm.ErrRecordNotFound
+}
+
+// GetWatchProducts returns a limit of verified watches with ID after afterID
+func (r *Repository) GetWatchProducts(limit int, afterID uint) (*[]scraper.WatchProduct, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	watchProducts := []scraper.WatchProduct{}
+	for _, w := range r.watchProducts {
+		if w.Verified && w.ID > afterID && len(watchProducts) < limit {
+			watchProducts = append(watchProducts, w)
+		}
+	}
+
+	return &watchProducts, nil
+}
+
+// GetWatchProductsByEmail returns all watches of email, verified or not, ordered by ID
+func (r *Repository) GetWatchProductsByEmail(email string) (*[]scraper.WatchProduct, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	watchProducts := []scraper.WatchProduct{}
+	for _, w := range r.watchProducts {
+		if w.Email == email {
+			watchProducts = append(watchProducts, w)
+		}
+	}
+
+	return &watchProducts, nil
+}
+
+// GetWatchProductByID returns the watch with ID
+func (r *Repository) GetWatchProductByID(id uint) (*scraper.WatchProduct, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, w := range r.watchProducts {
+		if w.ID == id {
+			found := w
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// GetWatchProductByVerifyHash returns the watch with verify hash
+func (r *Repository) GetWatchProductByVerifyHash(verifyHash string) (*scraper.WatchProduct, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, w := range r.watchProducts {
+		if w.VerifyHash == verifyHash {
+			found := w
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// CreateUnsubscribeToken stores the hash of an unsubscribe token
+func (r *Repository) CreateUnsubscribeToken(token *scraper.UnsubscribeToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token.Model = r.newModel("unsubscribe_tokens")
+	r.unsubscribeTokens = append(r.unsubscribeTokens, *token)
+
+	return nil
+}
+
+// GetWatchProductByUnsubscribeHash returns the watch with an unsubscribe token with hash
+func (r *Repository) GetWatchProductByUnsubscribeHash(unsubscribeHash string) (*scraper.WatchProduct, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, t := range r.unsubscribeTokens {
+		if t.Hash != unsubscribeHash {
+			continue
+		}
+		for _, w := range r.watchProducts {
+			if w.ID == t.WatchProductID {
+				found := w
+				return &found, nil
+			}
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// DeleteWatchProductByUnsubscribeHash deletes the watch with an unsubscribe token with hash
+func (r *Repository) DeleteWatchProductByUnsubscribeHash(unsubscribeHash string) error {
+	watchProduct, err := r.GetWatchProductByUnsubscribeHash(unsubscribeHash)
+	if err != nil {
+		return err
+	}
+
+	return r.DeleteWatchProductByID(watchProduct.ID)
+}
+
+// DeleteWatchProductByID deletes a watch and the alerts waiting for its digest
+func (r *Repository) DeleteWatchProductByID(id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var watchProducts []scraper.WatchProduct
+	for _, w := range r.watchProducts {
+		if w.ID != id {
+			watchProducts = append(watchProducts, w)
+		}
+	}
+	r.watchProducts = watchProducts
+
+	var watchEvents []scraper.WatchEvent
+	for _, e := range r.watchEvents {
+		if e.WatchProductID != id {
+			watchEvents = append(watchEvents, e)
+		}
+	}
+	r.watchEvents = watchEvents
+
+	var unsubscribeTokens []scraper.UnsubscribeToken
+	for _, t := range r.unsubscribeTokens {
+		if t.WatchProductID != id {
+			unsubscribeTokens = append(unsubscribeTokens, t)
+		}
+	}
+	r.unsubscribeTokens = unsubscribeTokens
+
+	return nil
+}
+
+// DeleteUnverifiedWatchProducts deletes unverified watches with verify links sent before sentBefore
+func (r *Repository) DeleteUnverifiedWatchProducts(sentBefore time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var deleted int64
+	var watchProducts []scraper.WatchProduct
+	for _, w := range r.watchProducts {
+		sent := w.CreatedAt
+		if w.VerifySentAt != nil {
+			sent = *w.VerifySentAt
+		}
+		if !w.Verified && sent.Before(sentBefore) {
+			deleted++
+			continue
+		}
+		watchProducts = append(watchProducts, w)
+	}
+	r.watchProducts = watchProducts
+
+	return deleted, nil
+}
+
+// WatchProducts returns all watches, verified or not
+func (r *Repository) WatchProducts() []scraper.WatchProduct {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]scraper.WatchProduct{}, r.watchProducts...)
+}
+
+// GetUniqueCategories returns unique categories with parent
+func (r *Repository) GetUniqueCategories(parent string) (*[]scraper.UniqueCategory, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	categories := []scraper.UniqueCategory{}
+	for _, c := range r.uniqueCategories {
+		if c.Parent == parent {
+			categories = append(categories, c)
+		}
+	}
+
+	sort.SliceStable(categories, func(i, j int) bool {
+		return categories[i].Name < categories[j].Name
+	})
+
+	return &categories, nil
+}
+
+// GetUniqueCategoryBySlug returns a single unique category
+func (r *Repository) GetUniqueCategoryBySlug(slug string) (*scraper.UniqueCategory, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.uniqueCategories {
+		if c.Slug == slug {
+			found := c
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// GetBotByURL returns a single bot by URL
+func (r *Repository) GetBotByURL(URL string) (*scraper.Bot, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, b := range r.bots {
+		if b.URL == URL {
+			found := b
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// UpdateOrCreateBot updates or creates a bot with the same URL
+func (r *Repository) UpdateOrCreateBot(bot *scraper.Bot) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.bots {
+		if r.bots[i].URL == bot.URL {
+			bot.Model = r.bots[i].Model
+			r.bots[i] = *bot
+			return nil
+		}
+	}
+
+	bot.Model = r.newModel("bots")
+	r.bots = append(r.bots, *bot)
+
+	return nil
+}
+
+// CreateNotifications adds notifications to the outbox
+func (r *Repository) CreateNotifications(notifications []*scraper.Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, notification := range notifications {
+		notification.Model = r.newModel("notifications")
+		r.notifications = append(r.notifications, *notification)
+	}
+
+	return nil
+}
+
+// UpdateNotification saves the delivery state of a notification with ID
+func (r *Repository) UpdateNotification(notification *scraper.Notification) error {
+	if notification.ID == 0 {
+		return fmt.Errorf("no notification ID")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.notifications {
+		if r.notifications[i].ID == notification.ID {
+			n := &r.notifications[i]
+			n.Status = notification.Status
+			n.Attempts = notification.Attempts
+			n.LastError = notification.LastError
+			n.NextRetryAt = notification.NextRetryAt
+			n.SentAt = notification.SentAt
+			n.UpdatedAt = time.Now()
+		}
+	}
+
+	return nil
+}
+
+// GetDueNotifications returns a limit of pending notifications due at now, longest waiting first
+func (r *Repository) GetDueNotifications(limit int, now time.Time) (*[]scraper.Notification, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notifications := []scraper.Notification{}
+	for _, n := range r.notifications {
+		if n.Status == scraper.NotificationPending && !n.NextRetryAt.After(now) {
+			notifications = append(notifications, n)
+		}
+	}
+	sort.SliceStable(notifications, func(i, j int) bool {
+		return notifications[i].NextRetryAt.Before(notifications[j].NextRetryAt)
+	})
+	if len(notifications) > limit {
+		notifications = notifications[:limit]
+	}
+
+	return &notifications, nil
+}
+
+// GetNotifications returns a page of notifications with status, all when it's empty, newest first
+func (r *Repository) GetNotifications(status string, limit, offset int) (*[]scraper.Notification, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notifications := []scraper.Notification{}
+	for i := len(r.notifications) - 1; i >= 0; i-- {
+		if status == "" || r.notifications[i].Status == status {
+			notifications = append(notifications, r.notifications[i])
+		}
+	}
+	start, end := page(len(notifications), limit, offset)
+	notifications = notifications[start:end]
+
+	return &notifications, nil
+}
+
+// RetryNotification makes a notification pending and due at now, attempts are kept so a failed one gets one more try
+func (r *Repository) RetryNotification(id uint, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.notifications {
+		if r.notifications[i].ID == id {
+			r.notifications[i].Status = scraper.NotificationPending
+			r.notifications[i].NextRetryAt = now
+			return nil
+		}
+	}
+
+	return gorm.ErrRecordNotFound
+}
+
+// Notifications returns all notifications in the outbox
+func (r *Repository) Notifications() []scraper.Notification {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]scraper.Notification{}, r.notifications...)
+}
+
+// GetEmailPreference returns the preference of email
+func (r *Repository) GetEmailPreference(email string) (*scraper.EmailPreference, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, p := range r.emailPreferences {
+		if p.Email == email {
+			found := p
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// UpdateOrCreateEmailPreference updates or creates the preference of the same email
+func (r *Repository) UpdateOrCreateEmailPreference(preference *scraper.EmailPreference) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.emailPreferences {
+		if r.emailPreferences[i].Email == preference.Email {
+			preference.Model = r.emailPreferences[i].Model
+			r.emailPreferences[i] = *preference
+			return nil
+		}
+	}
+
+	preference.Model = r.newModel("email_preferences")
+	r.emailPreferences = append(r.emailPreferences, *preference)
+
+	return nil
+}
+
+// CreateWatchEvent adds an alert to the next digest of the watcher email
+func (r *Repository) CreateWatchEvent(event *scraper.WatchEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	event.Model = r.newModel("watch_events")
+	r.watchEvents = append(r.watchEvents, *event)
+
+	return nil
+}
+
+// GetDigestEmails returns the emails with waiting alerts that have the digest preference,
+// emails without a preference are immediate
+func (r *Repository) GetDigestEmails(digest string) (*[]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	preferences := make(map[string]string)
+	for _, p := range r.emailPreferences {
+		preferences[p.Email] = p.Digest
+	}
+
+	emails := []string{}
+	for _, e := range r.watchEvents {
+		emailDigest, ok := preferences[e.Email]
+		if !ok {
+			emailDigest = scraper.DigestImmediate
+		}
+		if emailDigest == digest && !contains(emails, e.Email) {
+			emails = append(emails, e.Email)
+		}
+	}
+	sort.Strings(emails)
+
+	return &emails, nil
+}
+
+// GetWatchEventsByEmail returns the alerts waiting for the digest of email, oldest first
+func (r *Repository) GetWatchEventsByEmail(email string) (*[]scraper.WatchEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := []scraper.WatchEvent{}
+	for _, e := range r.watchEvents {
+		if e.Email == email {
+			events = append(events, e)
+		}
+	}
+
+	return &events, nil
+}
+
+// CreateDigestNotifications adds the notifications of a digest to the outbox and deletes the alerts in it
+func (r *Repository) CreateDigestNotifications(notifications []*scraper.Notification, ids []uint) error {
+	err := r.CreateNotifications(notifications)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var watchEvents []scraper.WatchEvent
+	for _, e := range r.watchEvents {
+		deleted := false
+		for _, id := range ids {
+			if e.ID == id {
+				deleted = true
+			}
+		}
+		if !deleted {
+			watchEvents = append(watchEvents, e)
+		}
+	}
+	r.watchEvents = watchEvents
+
+	return nil
+}
+
+// WatchEvents returns all alerts waiting for digests
+func (r *Repository) WatchEvents() []scraper.WatchEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]scraper.WatchEvent{}, r.watchEvents...)
+}
+
+// GetUserByEmail returns the user with email
+func (r *Repository) GetUserByEmail(email string) (*scraper.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Email == email {
+			found := u
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// GetUserByLoginHash returns the user a login link was sent to
+func (r *Repository) GetUserByLoginHash(loginHash string) (*scraper.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if loginHash != "" && u.LoginHash == loginHash {
+			found := u
+			return &found, nil
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// CreateUser creates a user, emails are unique
+func (r *Repository) CreateUser(user *scraper.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, u := range r.users {
+		if u.Email == user.Email {
+			return fmt.Errorf("user %s already exists", user.Email)
+		}
+	}
+
+	user.Model = r.newModel("users")
+	r.users = append(r.users, *user)
+
+	return nil
+}
+
+// UpdateUser updates the login state of a user with ID
+func (r *Repository) UpdateUser(user *scraper.User) error {
+	if user.ID == 0 {
+		return fmt.Errorf("no user ID")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].ID == user.ID {
+			r.users[i].LoginHash = user.LoginHash
+			r.users[i].LoginSentAt = user.LoginSentAt
+			r.users[i].LastLoginAt = user.LastLoginAt
+			r.users[i].UpdatedAt = time.Now()
+		}
+	}
+
+	return nil
+}
+
+// CreateSession creates a session
+func (r *Repository) CreateSession(session *scraper.Session) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	session.Model = r.newModel("sessions")
+	r.sessions = append(r.sessions, *session)
+
+	return nil
+}
+
+// LoginUser saves the login state of a user and creates its session
+func (r *Repository) LoginUser(user *scraper.User, session *scraper.Session) error {
+	err := r.UpdateUser(user)
+	if err != nil {
+		return err
+	}
+
+	return r.CreateSession(session)
+}
+
+// GetUserBySessionToken returns the user of a session that hasn't expired at now
+func (r *Repository) GetUserBySessionToken(token string, now time.Time) (*scraper.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range r.sessions {
+		if s.Token != token || !s.ExpiresAt.After(now) {
+			continue
+		}
+		for _, u := range r.users {
+			if u.ID == s.UserID {
+				found := u
+				return &found, nil
+			}
+		}
+	}
+
+	return nil, gorm.ErrRecordNotFound
+}
+
+// DeleteSession deletes the session with token
+func (r *Repository) DeleteSession(token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sessions []scraper.Session
+	for _, s := range r.sessions {
+		if s.Token != token {
+			sessions = append(sessions, s)
+		}
+	}
+	r.sessions = sessions
+
+	return nil
+}
+
+// UpdateOrCreatePushSubscription creates the push subscription or updates the keys of the one
+// the user already has with its endpoint
+func (r *Repository) UpdateOrCreatePushSubscription(subscription *scraper.PushSubscription) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.pushes {
+		if r.pushes[i].UserID == subscription.UserID && r.pushes[i].Endpoint == subscription.Endpoint {
+			r.pushes[i].P256dh = subscription.P256dh
+			r.pushes[i].Auth = subscription.Auth
+			r.pushes[i].UpdatedAt = time.Now()
+			subscription.Model = r.pushes[i].Model
+			return nil
+		}
+	}
+
+	subscription.Model = r.newModel("push_subscriptions")
+	r.pushes = append(r.pushes, *subscription)
+
+	return nil
+}
+
+// GetPushSubscriptionsByEmail returns the push subscriptions of the user with email
+func (r *Repository) GetPushSubscriptionsByEmail(email string) (*[]scraper.PushSubscription, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscriptions := []scraper.PushSubscription{}
+	for _, u := range r.users {
+		if u.Email != email {
+			continue
+		}
+		for _, p := range r.pushes {
+			if p.UserID == u.ID {
+				subscriptions = append(subscriptions, p)
+			}
+		}
+	}
+
+	return &subscriptions, nil
+}
+
+// DeletePushSubscription deletes the push subscription of the user with endpoint
+func (r *Repository) DeletePushSubscription(userID uint, endpoint string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var pushes []scraper.PushSubscription
+	for _, p := range r.pushes {
+		if p.UserID != userID || p.Endpoint != endpoint {
+			pushes = append(pushes, p)
+		}
+	}
+	r.pushes = pushes
+
+	return nil
+}
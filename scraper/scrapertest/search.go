@@ -0,0 +1,102 @@
+package scrapertest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+	"bitbucket.org/hilmarp/price-scraper/scraper"
+)
+
+// Search is an in-memory scraper.SearchRepository, matching on URL or a case insensitive
+// substring of product code, title or description. The zero value is ready to use
+type Search struct {
+	mu       sync.Mutex
+	products map[uint]scraper.SearchProduct
+}
+
+var _ scraper.SearchRepository = &Search{}
+
+// SearchForProduct returns products matching value and filter, ordered by ID
+func (s *Search) SearchForProduct(value string, filter scraper.SearchFilter, limit, offset int) (*[]scraper.SearchProduct, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	isURL := formatters.IsValidURL(value)
+	value = strings.ToLower(value)
+
+	var products []scraper.SearchProduct
+	for _, p := range s.products {
+		if !matchesFilter(p, filter) {
+			continue
+		}
+		if isURL && contains(p.URL, value) {
+			products = append(products, p)
+			continue
+		}
+		if !isURL && (strings.Contains(strings.ToLower(p.ProductCode), value) ||
+			strings.Contains(strings.ToLower(p.Title), value) ||
+			strings.Contains(strings.ToLower(p.Description), value)) {
+			products = append(products, p)
+		}
+	}
+
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].ID < products[j].ID
+	})
+
+	start, end := page(len(products), limit, offset)
+	products = products[start:end]
+
+	return &products, nil
+}
+
+// matchesFilter returns if p matches filter the same way as scraper.Elasticsearch
+func matchesFilter(p scraper.SearchProduct, filter scraper.SearchFilter) bool {
+	if filter.InStock == nil && filter.Location == "" {
+		return true
+	}
+
+	inStock := p.InStock
+	if filter.Location != "" {
+		inStock = contains(p.InStockAt, filter.Location)
+	}
+
+	return inStock == (filter.InStock == nil || *filter.InStock)
+}
+
+// UpdateOrIndexSearchProduct stores product by ID
+func (s *Search) UpdateOrIndexSearchProduct(product *scraper.SearchProduct) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.products == nil {
+		s.products = make(map[uint]scraper.SearchProduct)
+	}
+	s.products[product.ID] = *product
+
+	return nil
+}
+
+// BulkIndexSearchProducts stores many products
+func (s *Search) BulkIndexSearchProducts(products []*scraper.SearchProduct) error {
+	for _, p := range products {
+		err := s.UpdateOrIndexSearchProduct(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteSearchProductByID removes a product
+func (s *Search) DeleteSearchProductByID(id uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.products, id)
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package scraper
+
+import (
+	"errors"
+	"log"
+
+	"bitbucket.org/hilmarp/price-scraper/metrics"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+// Cost returns the delivery cost for a product with ISK price
+func (r *ShippingRule) Cost(priceISK uint) uint {
+	if r.FreeAbove > 0 && priceISK >= r.FreeAbove {
+		return 0
+	}
+
+	return r.FlatFee
+}
+
+// setProductShipping sets shipping cost and total price on product from rule,
+// products from stores without a rule have no shipping cost
+func setProductShipping(product *Product, rule *ShippingRule) {
+	product.ShippingCost = 0
+	product.FreePickup = false
+
+	if rule != nil {
+		product.ShippingCost = rule.Cost(product.PriceISK)
+		product.FreePickup = rule.FreePickup
+	}
+
+	product.TotalPrice = product.PriceISK + product.ShippingCost
+}
+
+// getShippingRule returns the shipping rule for source, nil if the store has none
+func (s *Scraper) getShippingRule(source string) (*ShippingRule, error) {
+	rule, err := s.DB.GetShippingRuleBySource(source)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return rule, nil
+}
+
+// StartShippingUpdater applies shipping rules to all products, so changes made to rules
+// in the database show up without waiting for products to be scraped again
+func (s *Scraper) StartShippingUpdater() error {
+	c := cron.New()
+	c.AddFunc("15 * * * *", func() { // At minute 15.
+		metrics.ShippingUpdatersRunning.Inc()
+		defer metrics.ShippingUpdatersRunning.Dec()
+
+		err := s.DB.UpdateAllProductsShipping()
+		if err != nil {
+			log.Print(err)
+		}
+	})
+	c.Start()
+
+	return nil
+}
@@ -0,0 +1,30 @@
+package scraper
+
+import "testing"
+
+func TestSetProductShipping(t *testing.T) {
+	rule := &ShippingRule{Source: "elko.is", FlatFee: 1990, FreeAbove: 10000, FreePickup: true}
+
+	tests := []struct {
+		rule     *ShippingRule
+		priceISK uint
+		shipping uint
+		total    uint
+	}{
+		{rule, 5000, 1990, 6990},
+		{rule, 10000, 0, 10000},
+		{&ShippingRule{FlatFee: 990}, 100000, 990, 100990},
+		{nil, 5000, 0, 5000},
+	}
+
+	for _, test := range tests {
+		product := &Product{PriceISK: test.priceISK}
+		setProductShipping(product, test.rule)
+		if product.ShippingCost != test.shipping || product.TotalPrice != test.total {
+			t.Errorf("Got %v and %v, want %v and %v for %v", product.ShippingCost, product.TotalPrice, test.shipping, test.total, test.priceISK)
+		}
+		if product.FreePickup != (test.rule != nil && test.rule.FreePickup) {
+			t.Errorf("Got FreePickup %v for %v", product.FreePickup, test.priceISK)
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"strings"
 	"time"
 
-	"bitbucket.org/hilmarp/price-scraper/formatters"
 	"gorm.io/gorm"
 )
 
@@ -15,25 +14,27 @@ type SQL struct {
 	*gorm.DB
 }
 
-// GetProducts returns a limit of products
-func (db *SQL) GetProducts(limit, offset, priceFrom, priceTo int, order, onSale string, sources, categorySlugs []string) (*[]ProductPriceDiff, error) {
-	sql, args := db.GetProductsSQLStmt(limit, offset, priceFrom, priceTo, order, onSale, sources, categorySlugs, false)
+// GetProducts returns a page of products matching query,
+// and the cursor to the next page, which is nil after the last page
+func (db *SQL) GetProducts(query ProductQuery) (*[]ProductPriceDiff, *Cursor, error) {
+	sql, args, err := query.Build()
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// Get products in category with slug from slugs list
 	var products []ProductPriceDiff
 	result := db.Raw(sql, args...).Scan(&products)
 	if err := result.Error; err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &products, nil
+	return &products, query.nextCursor(products), nil
 }
 
-// GetProductsCount returns the total count of products with filters
-func (db *SQL) GetProductsCount(limit, offset, priceFrom, priceTo int, order, onSale string, sources, categorySlugs []string) (int, error) {
-	sql, args := db.GetProductsSQLStmt(limit, offset, priceFrom, priceTo, order, onSale, sources, categorySlugs, true)
+// GetProductsCount returns the total count of products matching query filters
+func (db *SQL) GetProductsCount(query ProductQuery) (int, error) {
+	sql, args := query.BuildCount()
 
-	// Get products in category with slug from slugs list
 	type countJSON struct {
 		Count int
 	}
@@ -46,168 +47,6 @@ func (db *SQL) GetProductsCount(limit, offset, priceFrom, priceTo int, order, on
 	return count.Count, nil
 }
 
-// GetProductsSQLStmt returns the SQL statement with filters to get products from DB
-func (db *SQL) GetProductsSQLStmt(limit, offset, priceFrom, priceTo int, order, onSale string, sources, categorySlugs []string, countOnly bool) (string, []interface{}) {
-	// Only add parent categories to list, all children will be returned
-	// Query might have heyrnartol]hljod-og-mynd and hljod-og-mynd, so only add hljod-og-mynd
-	var slugs []string
-	for _, s := range categorySlugs {
-		sSlugs := strings.Split(s, "]")
-
-		// If no separator it's a parent, so add it
-		if len(sSlugs) < 2 {
-			slugs = append(slugs, s)
-			continue
-		}
-
-		// Check for parent, if it contains any of the parents, don't add it
-		// Might be heyrnartol]hljod-og-mynd and tolvuheyrnartol]heyrnartol]hljod-og-mynd, so only add the first one
-		var uc UniqueCategory
-		db.Where("slug = ?", s).First(&uc)
-
-		if formatters.IsInStringList(uc.Parent, categorySlugs) {
-			continue
-		}
-
-		slugs = append(slugs, s)
-	}
-
-	// Set up statements and arguments
-	var args []interface{}
-
-	// Slugs where
-	slugWheres := make([]string, len(slugs))
-	for i, s := range slugs {
-		args = append(args, s)
-		slugWheres[i] = "c.slug = ?"
-	}
-	slugStmt := ""
-	if len(slugWheres) > 0 {
-		slugStmt = fmt.Sprintf("(%s)", strings.Join(slugWheres, " OR "))
-	}
-
-	// Sources where
-	sourceWheres := make([]string, len(sources))
-	for i, s := range sources {
-		args = append(args, s)
-		sourceWheres[i] = "p.source = ?"
-	}
-	sourceStmt := ""
-	if len(sourceWheres) > 0 {
-		sourceStmt = fmt.Sprintf("(%s)", strings.Join(sourceWheres, " OR "))
-	}
-
-	// Price where
-	priceStmt := ""
-	if priceFrom > 0 || priceTo > 0 {
-		if priceFrom > 0 && priceTo > 0 {
-			args = append(args, priceFrom)
-			args = append(args, priceTo)
-			priceStmt = "(price >= ? AND price <= ?)"
-		} else if priceFrom > 0 {
-			args = append(args, priceFrom)
-			priceStmt = "(price >= ?)"
-		} else if priceTo > 0 {
-			args = append(args, priceTo)
-			priceStmt = "(price <= ?)"
-		}
-	}
-
-	// OnSale where
-	onSaleStmt := ""
-	if onSale != "" {
-		onSaleVal := "1"
-		if onSale == "false" {
-			onSaleVal = "0"
-		}
-		args = append(args, onSaleVal)
-		onSaleStmt = "(on_sale = ?)"
-	}
-
-	// All where clauses combined
-	whereStmt := ""
-	whereStmts := []string{slugStmt, sourceStmt, priceStmt, onSaleStmt}
-	whereStmtsNotEmpty := make([]string, 0)
-	for _, s := range whereStmts {
-		if s != "" {
-			whereStmtsNotEmpty = append(whereStmtsNotEmpty, s)
-		}
-	}
-	whereStmt = strings.Join(whereStmtsNotEmpty, " AND ")
-	if whereStmt != "" {
-		whereStmt = fmt.Sprintf("WHERE %s", whereStmt)
-	}
-
-	// Order by
-	orderByStmt := ""
-	if order != "" {
-		orderByStmt = fmt.Sprintf("ORDER BY %s", order)
-	}
-
-	// Limit offset args
-	if !countOnly {
-		args = append(args, limit)
-		args = append(args, offset)
-	}
-
-	sql := ""
-	if countOnly {
-		if len(slugWheres) > 0 {
-			sql = fmt.Sprintf(
-				`
-					SELECT count(*) AS count
-					FROM products AS p
-					INNER JOIN categories AS c
-					ON p.id = c.product_id
-					%s
-				`,
-				whereStmt,
-			)
-		} else {
-			sql = fmt.Sprintf(
-				`
-					SELECT count(*) AS count
-					FROM products AS p
-					%s
-				`,
-				whereStmt,
-			)
-		}
-	} else {
-		if len(slugWheres) > 0 {
-			sql = fmt.Sprintf(
-				`
-					SELECT p.*, ppc.price_diff, ppc.price_lower FROM products AS p
-					INNER JOIN categories AS c
-					ON p.id = c.product_id
-					LEFT JOIN product_price_changes AS ppc
-					ON p.id = ppc.product_id
-					%s
-					%s
-					LIMIT ? OFFSET ?
-				`,
-				whereStmt,
-				orderByStmt,
-			)
-		} else {
-			sql = fmt.Sprintf(
-				`
-					SELECT p.*, ppc.price_diff, ppc.price_lower FROM products AS p
-					LEFT JOIN product_price_changes AS ppc
-					ON p.id = ppc.product_id
-					%s
-					%s
-					LIMIT ? OFFSET ?
-				`,
-				whereStmt,
-				orderByStmt,
-			)
-		}
-	}
-
-	return sql, args
-}
-
 // GetUniqueCategories returns unique categories
 func (db *SQL) GetUniqueCategories(parent string) (*[]UniqueCategory, error) {
 	var categories []UniqueCategory
@@ -241,29 +80,20 @@ func (db *SQL) GetProductsByIDs(ids ...uint) (*[]Product, error) {
 	}
 
 	idParamsS := make([]string, len(ids))
-	for i := range ids {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
 		idParamsS[i] = "?"
+		args[i] = id
 	}
 
-	// SELECT * FROM table WHERE id IN (5,4,3,1,6) ORDER BY FIELD(id, 5,4,3,1,6);
+	orderStmt, orderArgs := db.orderByIDsStmt(ids)
+	args = append(args, orderArgs...)
 
-	idParams := strings.Join(idParamsS, ",")
 	sql := fmt.Sprintf(`
 		SELECT * FROM products
 		WHERE id IN (%s)
-		ORDER BY FIELD(id, %s)
-	`, idParams, idParams)
-
-	// Ex. 1,2,3,1,2,3 because we pass them in twice as parameters
-	// TODO: One loop
-	var doubleIds []uint
-	doubleIds = append(doubleIds, ids...)
-	doubleIds = append(doubleIds, ids...)
-
-	args := make([]interface{}, len(doubleIds))
-	for i, id := range doubleIds {
-		args[i] = id
-	}
+		ORDER BY %s
+	`, strings.Join(idParamsS, ","), orderStmt)
 
 	result := db.Raw(sql, args...).Scan(&products)
 	if err := result.Error; err != nil {
@@ -274,33 +104,121 @@ func (db *SQL) GetProductsByIDs(ids ...uint) (*[]Product, error) {
 }
 
 // GetProductsPriceChanges returns products that have changed in price recently,
-// both price drop and price hikes
-func (db *SQL) GetProductsPriceChanges(limit, offset int, lower string) (*[]ProductPriceDiff, error) {
+// both price drop and price hikes, biggest change first. Pages with cursor like GetProducts
+func (db *SQL) GetProductsPriceChanges(limit, offset int, cursor *Cursor, lower string) (*[]ProductPriceDiff, *Cursor, error) {
+	sort := "price_diff desc"
+	err := checkCursor(cursor, sort, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var args []interface{}
 	whereLower := ""
 	switch lower {
 	case "true":
-		whereLower = "AND ppc.price_lower = 1"
+		whereLower = "AND ppc.price_lower = ?"
+		args = append(args, true)
 	case "false":
-		whereLower = "AND ppc.price_lower = 0"
+		whereLower = "AND ppc.price_lower = ?"
+		args = append(args, false)
+	}
+
+	whereCursor := ""
+	if cursor != nil {
+		var cursorArgs []interface{}
+		whereCursor, cursorArgs = keysetStmt("ppc.price_diff", "p.id", true, cursor.Value, cursor.ID)
+		whereCursor = "AND " + whereCursor
+		args = append(args, cursorArgs...)
 	}
+	page, pageArgs := pageStmt(limit, offset, cursor)
+	args = append(args, pageArgs...)
 
 	sql := fmt.Sprintf(`
-		SELECT p.*, ppc.price_diff, ppc.price_lower FROM products AS p
+		SELECT p.*, ppc.price_diff, ppc.price_lower, ppc.price_diff AS cursor_value FROM products AS p
 		INNER JOIN product_price_changes AS ppc
 		ON p.id = ppc.product_id
 		WHERE ppc.price_diff > 0
 		%s
-		ORDER BY ppc.price_diff DESC
-		LIMIT ? OFFSET ?
-	`, whereLower)
+		%s
+		ORDER BY %s
+		%s
+	`, whereLower, whereCursor, keysetOrderStmt("ppc.price_diff", "p.id", true), page)
 
 	var products []ProductPriceDiff
-	result := db.Raw(sql, limit, offset).Scan(&products)
+	result := db.Raw(sql, args...).Scan(&products)
 	if err := result.Error; err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &products, nil
+	return &products, keysetPage(products, limit, sort), nil
+}
+
+// GetSuspiciousSaleProducts returns products flagged with a suspicious sale, optionally only from sources,
+// by source and newest first in each. Pages with cursor like GetProducts
+func (db *SQL) GetSuspiciousSaleProducts(limit, offset int, cursor *Cursor, sources []string) (*[]Product, *Cursor, error) {
+	sort := "source asc"
+	err := checkCursor(cursor, sort, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := db.Where("suspicious_sale = ?", true)
+	if len(sources) > 0 {
+		query = query.Where("source IN ?", sources)
+	}
+
+	// Source and ID are in opposite directions, so this isn't keysetStmt
+	if cursor != nil {
+		query = query.Where("(source > ? OR (source = ? AND id < ?))", cursor.Text, cursor.Text, cursor.ID)
+	} else {
+		query = query.Offset(offset)
+	}
+
+	var products []Product
+	result := query.
+		Order("source asc, id desc").
+		Limit(limit).
+		Find(&products)
+	if err := result.Error; err != nil {
+		return nil, nil, err
+	}
+
+	var next *Cursor
+	if limit > 0 && len(products) == limit {
+		last := products[len(products)-1]
+		next = &Cursor{Sort: sort, Text: last.Source, ID: last.ID}
+	}
+
+	return &products, next, nil
+}
+
+// UpdateProductSuspiciousSale sets the suspicious sale flag and reference price,
+// without touching updated_at since it's not a scraped change
+func (db *SQL) UpdateProductSuspiciousSale(id uint, suspicious bool, referencePrice uint) error {
+	result := db.Model(&Product{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"suspicious_sale": suspicious,
+		"reference_price": referencePrice,
+	})
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ClearSuspiciousSales removes the suspicious sale flag from products that are no longer on sale
+func (db *SQL) ClearSuspiciousSales() error {
+	result := db.Model(&Product{}).
+		Where("suspicious_sale = ? AND on_sale = ?", true, false).
+		UpdateColumns(map[string]interface{}{
+			"suspicious_sale": false,
+			"reference_price": 0,
+		})
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
 }
 
 // GetProductByID returns a single product by ID
@@ -331,6 +249,11 @@ func (db *SQL) DeleteProductByID(id uint) error {
 		return err
 	}
 
+	result = db.Where("product_id = ?", id).Unscoped().Delete(StockChange{})
+	if err := result.Error; err != nil {
+		return err
+	}
+
 	result = db.Where("product_id = ?", id).Unscoped().Delete(Spec{})
 	if err := result.Error; err != nil {
 		return err
@@ -341,6 +264,11 @@ func (db *SQL) DeleteProductByID(id uint) error {
 		return err
 	}
 
+	result = db.Where("product_id = ?", id).Unscoped().Delete(WatchEvent{})
+	if err := result.Error; err != nil {
+		return err
+	}
+
 	result = db.Where("product_id = ?", id).Unscoped().Delete(WatchProduct{})
 	if err := result.Error; err != nil {
 		return err
@@ -425,17 +353,18 @@ func (db *SQL) GetProductsBySourceProductCodeTitle(source, productCode, title st
 	return &foundProducts, nil
 }
 
-// GetProductPrices returns prices for a product
-func (db *SQL) GetProductPrices(id uint, from time.Time, order string) (*[]Price, error) {
-	sql := fmt.Sprintf(`
-		SELECT * FROM prices
-		WHERE product_id = ?
-		AND date >= ?
-		ORDER BY %s;
-	`, order)
+// GetProductPrices returns prices for a product from date, oldest first or newest first when desc
+func (db *SQL) GetProductPrices(id uint, from time.Time, desc bool) (*[]Price, error) {
+	order := "id asc"
+	if desc {
+		order = "id desc"
+	}
 
 	var prices []Price
-	result := db.Raw(sql, id, from).Scan(&prices)
+	result := db.
+		Where("product_id = ? AND date >= ?", id, from).
+		Order(order).
+		Find(&prices)
 	if err := result.Error; err != nil {
 		return nil, err
 	}
@@ -443,6 +372,30 @@ func (db *SQL) GetProductPrices(id uint, from time.Time, order string) (*[]Price
 	return &prices, nil
 }
 
+// GetProductPriceStats returns price statistics for a product from date until now
+func (db *SQL) GetProductPriceStats(id uint, from time.Time) (*PriceStats, error) {
+	prices, err := db.GetProductPrices(id, from, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// The price in effect when the window starts
+	var before *Price
+	var last []Price
+	result := db.Where("product_id = ? AND date < ?", id, from).Order("date desc, id desc").Limit(1).Find(&last)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+	if len(last) == 1 {
+		before = &last[0]
+	}
+
+	stats := CalculatePriceStats(before, *prices, from, time.Now())
+	stats.ProductID = id
+
+	return &stats, nil
+}
+
 // GetProductSpecs returns specs for a product
 func (db *SQL) GetProductSpecs(id uint) (*[]Spec, error) {
 	var specs []Spec
@@ -465,6 +418,17 @@ func (db *SQL) GetProductStocks(id uint) (*[]Stock, error) {
 	return &stocks, nil
 }
 
+// GetProductStockHistory returns stock changes for a product since from, oldest first
+func (db *SQL) GetProductStockHistory(id uint, from time.Time) (*[]StockChange, error) {
+	var changes []StockChange
+	result := db.Where("product_id = ? AND created_at >= ?", id, from).Order("created_at asc, id asc").Find(&changes)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &changes, nil
+}
+
 // GetProductImages returns images for a product
 func (db *SQL) GetProductImages(id uint) (*[]Image, error) {
 	var images []Image
@@ -517,19 +481,135 @@ func (db *SQL) InsertUniqueCategory(categories *[]Category) error {
 }
 
 // UpdateOrCreateProduct will update product fields if it already exists,
-// otherwise create it, and return the db instance of product
+// otherwise create it, and return the db instance of product.
+// Everything runs in one transaction and child rows that didn't change are left alone
 func (db *SQL) UpdateOrCreateProduct(scrapedProduct *Product) (*Product, error) {
+	var storedProduct *Product
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		txDB := &SQL{DB: tx}
+
+		product, rows, err := txDB.upsertProduct(scrapedProduct)
+		if err != nil {
+			return err
+		}
+
+		err = rows.insert(tx)
+		if err != nil {
+			return err
+		}
+
+		storedProduct = product
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return storedProduct, nil
+}
+
+// UpdateOrCreateProducts is UpdateOrCreateProduct for many products in one transaction,
+// with new price points and child rows of all the products inserted together. A product that fails
+// is rolled back on its own, the returned slices have the stored product or error at the same index as scrapedProducts
+func (db *SQL) UpdateOrCreateProducts(scrapedProducts []*Product) ([]*Product, []error, error) {
+	storedProducts := make([]*Product, len(scrapedProducts))
+	errs := make([]error, len(scrapedProducts))
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var rows productRows
+		for i, scrapedProduct := range scrapedProducts {
+			// Nested transactions are savepoints
+			errs[i] = tx.Transaction(func(tx *gorm.DB) error {
+				txDB := &SQL{DB: tx}
+
+				product, productRows, err := txDB.upsertProduct(scrapedProduct)
+				if err != nil {
+					return err
+				}
+
+				rows.add(productRows)
+				storedProducts[i] = product
+				return nil
+			})
+		}
+
+		return rows.insert(tx)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return storedProducts, errs, nil
+}
+
+// productRows are new child rows of products, upsertProduct returns them instead of storing them
+// so the rows of many products can be inserted in batches
+type productRows struct {
+	prices       []Price
+	specs        []Spec
+	stocks       []Stock
+	stockChanges []StockChange
+	images       []Image
+	categories   []Category
+}
+
+// productRowsBatchSize is how many rows go in one insert
+const productRowsBatchSize int = 100
+
+// add adds other to the rows
+func (r *productRows) add(other productRows) {
+	r.prices = append(r.prices, other.prices...)
+	r.specs = append(r.specs, other.specs...)
+	r.stocks = append(r.stocks, other.stocks...)
+	r.stockChanges = append(r.stockChanges, other.stockChanges...)
+	r.images = append(r.images, other.images...)
+	r.categories = append(r.categories, other.categories...)
+}
+
+// insert creates the rows in batches, with one insert per table and batch
+func (r *productRows) insert(tx *gorm.DB) error {
+	for _, rows := range []struct {
+		name  string
+		len   int
+		value interface{}
+	}{
+		{"prices", len(r.prices), &r.prices},
+		{"specs", len(r.specs), &r.specs},
+		{"stocks", len(r.stocks), &r.stocks},
+		{"stock changes", len(r.stockChanges), &r.stockChanges},
+		{"images", len(r.images), &r.images},
+		{"categories", len(r.categories), &r.categories},
+	} {
+		if rows.len == 0 {
+			continue
+		}
+
+		result := tx.CreateInBatches(rows.value, productRowsBatchSize)
+		if err := result.Error; err != nil {
+			return fmt.Errorf("error storing product %s: %w", rows.name, err)
+		}
+	}
+
+	return nil
+}
+
+// upsertProduct does the work for UpdateOrCreateProduct, and should run in a transaction.
+// New child rows of an existing product, and stock changes, are returned instead of stored so they can be batched
+func (db *SQL) upsertProduct(scrapedProduct *Product) (*Product, productRows, error) {
+	var rows productRows
+
 	// Insert unique categories
 	err := db.InsertUniqueCategory(&scrapedProduct.Categories)
 	if err != nil {
-		return nil, err
+		return nil, rows, fmt.Errorf("error storing unique categories: %w", err)
 	}
 
 	// Check if it's been scraped already
 	foundProduct, err := db.GetProductByURL(scrapedProduct.URL)
 	if err != nil {
 		if !errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, err
+			return nil, rows, err
 		}
 	}
 
@@ -537,88 +617,271 @@ func (db *SQL) UpdateOrCreateProduct(scrapedProduct *Product) (*Product, error)
 	if foundProduct == nil {
 		result := db.Create(scrapedProduct)
 		if err := result.Error; err != nil {
-			return nil, fmt.Errorf("error creating %v: %w", scrapedProduct.URL, err)
+			return nil, rows, fmt.Errorf("error creating %v: %w", scrapedProduct.URL, err)
 		}
-		return scrapedProduct, nil // skip rest
-	}
 
-	result := db.Model(&foundProduct).Updates(map[string]interface{}{
-		"source":       scrapedProduct.Source,
-		"product_code": scrapedProduct.ProductCode,
-		"slug":         scrapedProduct.Slug,
-		"url":          scrapedProduct.URL,
-		"title":        scrapedProduct.Title,
-		"description":  scrapedProduct.Description,
-		"main_img_url": scrapedProduct.MainImgURL,
-		"price":        scrapedProduct.Price,
-		"on_sale":      scrapedProduct.OnSale,
+		rows.stockChanges = DiffStockChanges(scrapedProduct.ID, nil, scrapedProduct.Stocks)
+
+		return scrapedProduct, rows, nil // skip rest
+	}
+
+	result := db.Model(foundProduct).Updates(map[string]interface{}{
+		"source":        scrapedProduct.Source,
+		"product_code":  scrapedProduct.ProductCode,
+		"slug":          scrapedProduct.Slug,
+		"url":           scrapedProduct.URL,
+		"title":         scrapedProduct.Title,
+		"description":   scrapedProduct.Description,
+		"main_img_url":  scrapedProduct.MainImgURL,
+		"price":         scrapedProduct.Price,
+		"price_isk":     scrapedProduct.PriceISK,
+		"currency":      scrapedProduct.Currency,
+		"vat_included":  scrapedProduct.VATIncluded,
+		"list_price":    scrapedProduct.ListPrice,
+		"discount":      scrapedProduct.Discount,
+		"brand":         scrapedProduct.Brand,
+		"unit":          scrapedProduct.Unit,
+		"unit_quantity": scrapedProduct.UnitQuantity,
+		"unit_price":    scrapedProduct.UnitPrice,
+		"shipping_cost": scrapedProduct.ShippingCost,
+		"total_price":   scrapedProduct.TotalPrice,
+		"free_pickup":   scrapedProduct.FreePickup,
+		"on_sale":       scrapedProduct.OnSale,
 	})
 	if err := result.Error; err != nil {
-		return nil, err
+		return nil, rows, fmt.Errorf("error updating %v: %w", scrapedProduct.URL, err)
 	}
 
-	// Delete the old specs and add the newly scraped
-	db.Where("product_id = ?", foundProduct.ID).Unscoped().Delete(Spec{})
+	rows.specs, err = db.syncProductSpecs(foundProduct.ID, scrapedProduct.Specs)
+	if err != nil {
+		return nil, rows, fmt.Errorf("error storing product specs: %w", err)
+	}
 
-	for _, s := range scrapedProduct.Specs {
-		db.Create(&Spec{
-			Key:       s.Key,
-			Value:     s.Value,
-			ProductID: foundProduct.ID,
-		})
+	// A page without stock info says nothing about stock, the stored stocks are kept
+	// so the product doesn't go out of stock everywhere and the search index keeps them
+	if len(scrapedProduct.Stocks) == 0 {
+		result := db.Where("product_id = ?", foundProduct.ID).Find(&scrapedProduct.Stocks)
+		if err := result.Error; err != nil {
+			return nil, rows, fmt.Errorf("error getting product stocks: %w", err)
+		}
 	}
 
-	// Delete old stock info and add new
-	db.Where("product_id = ?", foundProduct.ID).Unscoped().Delete(Stock{})
+	rows.stocks, rows.stockChanges, err = db.syncProductStocks(foundProduct.ID, scrapedProduct.Stocks)
+	if err != nil {
+		return nil, rows, fmt.Errorf("error storing product stocks: %w", err)
+	}
 
-	for _, s := range scrapedProduct.Stocks {
-		db.Create(&Stock{
-			Location:  s.Location,
-			InStock:   s.InStock,
-			ProductID: foundProduct.ID,
-		})
+	rows.images, err = db.syncProductImages(foundProduct.ID, scrapedProduct.AllImgURLs)
+	if err != nil {
+		return nil, rows, fmt.Errorf("error storing product images: %w", err)
 	}
 
-	// Delete the old image urls and add the newly scraped
-	db.Where("product_id = ?", foundProduct.ID).Unscoped().Delete(Image{})
+	rows.categories, err = db.syncProductCategories(foundProduct.ID, scrapedProduct.Categories)
+	if err != nil {
+		return nil, rows, fmt.Errorf("error storing product categories: %w", err)
+	}
 
-	for _, img := range scrapedProduct.AllImgURLs {
-		db.Create(&Image{
-			URL:         img.URL,
-			OriginalURL: img.OriginalURL,
+	// New dated price
+	if len(scrapedProduct.Prices) > 0 {
+		rows.prices = append(rows.prices, Price{
+			Price:       scrapedProduct.Prices[0].Price,
+			ListPrice:   scrapedProduct.Prices[0].ListPrice,
+			PriceISK:    scrapedProduct.Prices[0].PriceISK,
+			Currency:    scrapedProduct.Prices[0].Currency,
+			VATIncluded: scrapedProduct.Prices[0].VATIncluded,
+			Date:        scrapedProduct.Prices[0].Date,
 			ProductID:   foundProduct.ID,
 		})
 	}
 
-	// Add new dated price
-	if len(scrapedProduct.Prices) > 0 {
-		price := Price{
-			Price:     scrapedProduct.Prices[0].Price,
-			Date:      scrapedProduct.Prices[0].Date,
-			ProductID: foundProduct.ID,
+	return foundProduct, rows, nil
+}
+
+// childRow is a stored child row of a product, Key identifies its content
+type childRow struct {
+	ID  uint
+	Key string
+}
+
+// diffChildren matches stored rows with scraped keys, returns indexes of scraped keys
+// that need to be created and IDs of stored rows that need to be deleted
+func diffChildren(stored []childRow, scraped []string) ([]int, []uint) {
+	storedIDs := make(map[string][]uint)
+	for _, row := range stored {
+		storedIDs[row.Key] = append(storedIDs[row.Key], row.ID)
+	}
+
+	var create []int
+	for i, key := range scraped {
+		if ids := storedIDs[key]; len(ids) > 0 {
+			storedIDs[key] = ids[1:]
+			continue
 		}
-		result := db.Create(&price)
+		create = append(create, i)
+	}
+
+	var remove []uint
+	for _, row := range stored {
+		for _, id := range storedIDs[row.Key] {
+			remove = append(remove, id)
+		}
+		delete(storedIDs, row.Key)
+	}
+
+	return create, remove
+}
+
+// syncProductSpecs deletes stored specs of product that are not in specs and returns the specs to create
+func (db *SQL) syncProductSpecs(productID uint, specs []Spec) ([]Spec, error) {
+	var storedSpecs []Spec
+	result := db.Where("product_id = ?", productID).Find(&storedSpecs)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	stored := make([]childRow, len(storedSpecs))
+	for i, s := range storedSpecs {
+		stored[i] = childRow{ID: s.ID, Key: s.Key + "\x00" + s.Value}
+	}
+	scraped := make([]string, len(specs))
+	for i, s := range specs {
+		scraped[i] = s.Key + "\x00" + s.Value
+	}
+
+	create, remove := diffChildren(stored, scraped)
+	if len(remove) > 0 {
+		result := db.Where("id IN ?", remove).Unscoped().Delete(Spec{})
 		if err := result.Error; err != nil {
 			return nil, err
 		}
 	}
 
-	// Delete the old categories and add the newly scraped
-	db.Where("product_id = ?", foundProduct.ID).Unscoped().Delete(Category{})
+	rows := make([]Spec, len(create))
+	for j, i := range create {
+		rows[j] = Spec{
+			Key:       specs[i].Key,
+			Value:     specs[i].Value,
+			ProductID: productID,
+		}
+	}
+
+	return rows, nil
+}
 
-	for _, c := range scrapedProduct.Categories {
-		result := db.Create(&Category{
-			Name:      c.Name,
-			Slug:      c.Slug,
-			Parent:    c.Parent,
-			ProductID: foundProduct.ID,
-		})
+// syncProductStocks deletes stored stock info of product that is not in stocks and returns the stocks
+// and stock changes to create
+func (db *SQL) syncProductStocks(productID uint, stocks []Stock) ([]Stock, []StockChange, error) {
+	var storedStocks []Stock
+	result := db.Where("product_id = ?", productID).Find(&storedStocks)
+	if err := result.Error; err != nil {
+		return nil, nil, err
+	}
+
+	stored := make([]childRow, len(storedStocks))
+	for i, s := range storedStocks {
+		stored[i] = childRow{ID: s.ID, Key: fmt.Sprintf("%s\x00%t", s.Location, s.InStock)}
+	}
+	scraped := make([]string, len(stocks))
+	for i, s := range stocks {
+		scraped[i] = fmt.Sprintf("%s\x00%t", s.Location, s.InStock)
+	}
+
+	// Rows are replaced when they change, so the history is kept separately
+	changes := DiffStockChanges(productID, storedStocks, stocks)
+
+	create, remove := diffChildren(stored, scraped)
+	if len(remove) > 0 {
+		result := db.Where("id IN ?", remove).Unscoped().Delete(Stock{})
+		if err := result.Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rows := make([]Stock, len(create))
+	for j, i := range create {
+		rows[j] = Stock{
+			Location:  stocks[i].Location,
+			InStock:   stocks[i].InStock,
+			ProductID: productID,
+		}
+	}
+
+	return rows, changes, nil
+}
+
+// syncProductImages deletes stored image urls of product that are not in images and returns the images to create
+func (db *SQL) syncProductImages(productID uint, images []Image) ([]Image, error) {
+	var storedImages []Image
+	result := db.Where("product_id = ?", productID).Find(&storedImages)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	stored := make([]childRow, len(storedImages))
+	for i, img := range storedImages {
+		stored[i] = childRow{ID: img.ID, Key: img.URL + "\x00" + img.OriginalURL}
+	}
+	scraped := make([]string, len(images))
+	for i, img := range images {
+		scraped[i] = img.URL + "\x00" + img.OriginalURL
+	}
+
+	create, remove := diffChildren(stored, scraped)
+	if len(remove) > 0 {
+		result := db.Where("id IN ?", remove).Unscoped().Delete(Image{})
+		if err := result.Error; err != nil {
+			return nil, err
+		}
+	}
+
+	rows := make([]Image, len(create))
+	for j, i := range create {
+		rows[j] = Image{
+			URL:         images[i].URL,
+			OriginalURL: images[i].OriginalURL,
+			ProductID:   productID,
+		}
+	}
+
+	return rows, nil
+}
+
+// syncProductCategories deletes stored categories of product that are not in categories and returns the categories to create
+func (db *SQL) syncProductCategories(productID uint, categories []Category) ([]Category, error) {
+	var storedCategories []Category
+	result := db.Where("product_id = ?", productID).Find(&storedCategories)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	stored := make([]childRow, len(storedCategories))
+	for i, c := range storedCategories {
+		stored[i] = childRow{ID: c.ID, Key: c.Name + "\x00" + c.Slug + "\x00" + c.Parent}
+	}
+	scraped := make([]string, len(categories))
+	for i, c := range categories {
+		scraped[i] = c.Name + "\x00" + c.Slug + "\x00" + c.Parent
+	}
+
+	create, remove := diffChildren(stored, scraped)
+	if len(remove) > 0 {
+		result := db.Where("id IN ?", remove).Unscoped().Delete(Category{})
 		if err := result.Error; err != nil {
-			return nil, fmt.Errorf("error storing product categories: %w", err)
+			return nil, err
 		}
 	}
 
-	return foundProduct, nil
+	rows := make([]Category, len(create))
+	for j, i := range create {
+		rows[j] = Category{
+			Name:      categories[i].Name,
+			Slug:      categories[i].Slug,
+			Parent:    categories[i].Parent,
+			ProductID: productID,
+		}
+	}
+
+	return rows, nil
 }
 
 // CreateWatchProduct will create a WatchProduct entry, email watching a product
@@ -638,13 +901,20 @@ func (db *SQL) UpdateWatchProduct(watchProduct *WatchProduct) error {
 	}
 
 	result := db.Model(watchProduct).Updates(map[string]interface{}{
-		"email":            watchProduct.Email,
-		"product_id":       watchProduct.ProductID,
-		"sent":             watchProduct.Sent,
-		"price_id_sent":    watchProduct.PriceIDSent,
-		"verified":         watchProduct.Verified,
-		"verify_hash":      watchProduct.VerifyHash,
-		"unsubscribe_hash": watchProduct.UnsubscribeHash,
+		"email":                watchProduct.Email,
+		"product_id":           watchProduct.ProductID,
+		"price_drop":           watchProduct.PriceDrop,
+		"back_in_stock":        watchProduct.BackInStock,
+		"location":             watchProduct.Location,
+		"target_price":         watchProduct.TargetPrice,
+		"min_drop":             watchProduct.MinDrop,
+		"min_drop_percent":     watchProduct.MinDropPercent,
+		"sent":                 watchProduct.Sent,
+		"price_id_sent":        watchProduct.PriceIDSent,
+		"stock_change_id_sent": watchProduct.StockChangeIDSent,
+		"verified":             watchProduct.Verified,
+		"verify_hash":          watchProduct.VerifyHash,
+		"verify_sent_at":       watchProduct.VerifySentAt,
 	})
 	if err := result.Error; err != nil {
 		return err
@@ -653,14 +923,14 @@ func (db *SQL) UpdateWatchProduct(watchProduct *WatchProduct) error {
 	return nil
 }
 
-// GetWatchProducts returns a limit list of verified watches
-func (db *SQL) GetWatchProducts(limit, offset int) (*[]WatchProduct, error) {
+// GetWatchProducts returns a limit list of verified watches with ID after afterID, ordered by ID
+func (db *SQL) GetWatchProducts(limit int, afterID uint) (*[]WatchProduct, error) {
 	var watchProducts []WatchProduct
 
 	result := db.
-		Where("verified = ?", true).
+		Where("verified = ? AND id > ?", true, afterID).
+		Order("id asc").
 		Limit(limit).
-		Offset(offset).
 		Find(&watchProducts)
 	if err := result.Error; err != nil {
 		return nil, err
@@ -669,6 +939,28 @@ func (db *SQL) GetWatchProducts(limit, offset int) (*[]WatchProduct, error) {
 	return &watchProducts, nil
 }
 
+// GetWatchProductsByEmail returns all watches of email, verified or not, ordered by ID
+func (db *SQL) GetWatchProductsByEmail(email string) (*[]WatchProduct, error) {
+	var watchProducts []WatchProduct
+	result := db.Where("email = ?", email).Order("id asc").Find(&watchProducts)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &watchProducts, nil
+}
+
+// GetWatchProductByID returns a single WatchProduct by ID
+func (db *SQL) GetWatchProductByID(id uint) (*WatchProduct, error) {
+	var watchProduct WatchProduct
+	result := db.First(&watchProduct, id)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &watchProduct, nil
+}
+
 // GetWatchProductsByProductID returns watches for a product_id
 func (db *SQL) GetWatchProductsByProductID(id uint) (*[]WatchProduct, error) {
 	var watchProducts []WatchProduct
@@ -694,44 +986,92 @@ func (db *SQL) GetWatchProductByVerifyHash(verifyHash string) (*WatchProduct, er
 	return &watchProduct, nil
 }
 
-// GetWatchProductByUnsubscribeHash returns a single WatchProduct by unsubscribe hash
+// CreateUnsubscribeToken stores the hash of an unsubscribe token
+func (db *SQL) CreateUnsubscribeToken(token *UnsubscribeToken) error {
+	result := db.Create(token)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetWatchProductByUnsubscribeHash returns a single WatchProduct by the hash of one of its unsubscribe tokens
 func (db *SQL) GetWatchProductByUnsubscribeHash(unsubscribeHash string) (*WatchProduct, error) {
-	var watchProduct WatchProduct
-	result := db.Where("unsubscribe_hash = ?", unsubscribeHash).First(&watchProduct)
+	var token UnsubscribeToken
+	result := db.Where("hash = ?", unsubscribeHash).First(&token)
 	if err := result.Error; err != nil {
 		return nil, err
 	}
 
-	return &watchProduct, nil
+	return db.GetWatchProductByID(token.WatchProductID)
 }
 
 // DeleteWatchProductByUnsubscribeHash deletes a watch, so unsubscribe
 func (db *SQL) DeleteWatchProductByUnsubscribeHash(unsubscribeHash string) error {
-	result := db.Where("unsubscribe_hash = ?", unsubscribeHash).Unscoped().Delete(WatchProduct{})
+	var token UnsubscribeToken
+	result := db.Where("hash = ?", unsubscribeHash).First(&token)
 	if err := result.Error; err != nil {
 		return err
 	}
 
-	return nil
+	return db.DeleteWatchProductByID(token.WatchProductID)
 }
 
-// DeleteWatchProductByProductID deletes a watch by product id
-func (db *SQL) DeleteWatchProductByProductID(id uint) error {
-	result := db.Where("product_id = ?", id).Unscoped().Delete(WatchProduct{})
-	if err := result.Error; err != nil {
-		return err
-	}
-
+// DeleteWatchProductByID deletes a watch, its unsubscribe tokens and the alerts waiting for its digest
+func (db *SQL) DeleteWatchProductByID(id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("watch_product_id = ?", id).Unscoped().Delete(WatchEvent{})
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		result = tx.Where("watch_product_id = ?", id).Unscoped().Delete(UnsubscribeToken{})
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		result = tx.Where("id = ?", id).Unscoped().Delete(WatchProduct{})
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// DeleteUnverifiedWatchProducts deletes unverified watches with verify links sent before sentBefore
+// and returns how many
+func (db *SQL) DeleteUnverifiedWatchProducts(sentBefore time.Time) (int64, error) {
+	result := db.
+		Where("verified = ? AND COALESCE(verify_sent_at, created_at) < ?", false, sentBefore).
+		Unscoped().
+		Delete(WatchProduct{})
+	if err := result.Error; err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected, nil
+}
+
+// DeleteWatchProductByProductID deletes a watch by product id
+func (db *SQL) DeleteWatchProductByProductID(id uint) error {
+	result := db.Where("product_id = ?", id).Unscoped().Delete(WatchProduct{})
+	if err := result.Error; err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// GetProductViewCounts returns a limit list of view counts
-func (db *SQL) GetProductViewCounts(limit, offset int) (*[]ProductViewCount, error) {
+// GetProductViewCounts returns a limit list of view counts with ID after afterID, ordered by ID
+func (db *SQL) GetProductViewCounts(limit int, afterID uint) (*[]ProductViewCount, error) {
 	var productViewCounts []ProductViewCount
 
 	result := db.
+		Where("id > ?", afterID).
+		Order("id asc").
 		Limit(limit).
-		Offset(offset).
 		Find(&productViewCounts)
 	if err := result.Error; err != nil {
 		return nil, err
@@ -825,26 +1165,43 @@ func (db *SQL) DeleteProductViewCountByID(id uint) error {
 	return nil
 }
 
-// GetPopularProducts returns the most popular products, based on product view count
-func (db *SQL) GetPopularProducts(limit, offset int) (*[]ProductPriceDiff, error) {
-	sql := `
-		SELECT p.*, ppc.price_diff, ppc.price_lower FROM products AS p
+// GetPopularProducts returns the most popular products, based on product view count.
+// Pages with cursor like GetProducts
+func (db *SQL) GetPopularProducts(limit, offset int, cursor *Cursor) (*[]ProductPriceDiff, *Cursor, error) {
+	sort := "views desc"
+	err := checkCursor(cursor, sort, offset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var args []interface{}
+	whereCursor := ""
+	if cursor != nil {
+		whereCursor, args = keysetStmt("pvc.views", "p.id", true, cursor.Value, cursor.ID)
+		whereCursor = "AND " + whereCursor
+	}
+	page, pageArgs := pageStmt(limit, offset, cursor)
+	args = append(args, pageArgs...)
+
+	sql := fmt.Sprintf(`
+		SELECT p.*, ppc.price_diff, ppc.price_lower, pvc.views AS cursor_value FROM products AS p
 		INNER JOIN product_view_counts AS pvc
 		ON p.id = pvc.product_id
 		LEFT JOIN product_price_changes AS ppc
 		ON p.id = ppc.product_id
 		WHERE pvc.views > 0
-		ORDER BY pvc.views DESC
-		LIMIT ? OFFSET ?
-	`
+		%s
+		ORDER BY %s
+		%s
+	`, whereCursor, keysetOrderStmt("pvc.views", "p.id", true), page)
 
 	var products []ProductPriceDiff
-	result := db.Raw(sql, limit, offset).Scan(&products)
+	result := db.Raw(sql, args...).Scan(&products)
 	if err := result.Error; err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return &products, nil
+	return &products, keysetPage(products, limit, sort), nil
 }
 
 // GetProductPriceChangeByProductID returns a product price change entry
@@ -981,25 +1338,452 @@ func (db *SQL) UpdateOrCreateBot(bot *Bot) error {
 	return nil
 }
 
-// Migrate will run db migration
-func (db *SQL) Migrate() error {
-	err := db.AutoMigrate(
-		&Product{},
-		&Price{},
-		&Image{},
-		&Stock{},
-		&Spec{},
-		&Category{},
-		&UniqueCategory{},
-		&WatchProduct{},
-		&ProductViewCount{},
-		&ProductPriceChange{},
-		&ProductClickCount{},
-		&Bot{},
+// GetShippingRules returns all store shipping rules
+func (db *SQL) GetShippingRules() (*[]ShippingRule, error) {
+	var rules []ShippingRule
+	result := db.Order("source asc").Find(&rules)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &rules, nil
+}
+
+// GetShippingRuleBySource returns the shipping rule for a store
+func (db *SQL) GetShippingRuleBySource(source string) (*ShippingRule, error) {
+	var rule ShippingRule
+	result := db.Where("source = ?", source).First(&rule)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+// UpdateOrCreateShippingRule saves the shipping rule for a store and applies it to the store products
+func (db *SQL) UpdateOrCreateShippingRule(rule *ShippingRule) error {
+	found, err := db.GetShippingRuleBySource(rule.Source)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	if found == nil {
+		result := db.Create(rule)
+		if err := result.Error; err != nil {
+			return err
+		}
+	} else {
+		rule.ID = found.ID
+		result := db.Model(rule).Updates(map[string]interface{}{
+			"flat_fee":    rule.FlatFee,
+			"free_above":  rule.FreeAbove,
+			"free_pickup": rule.FreePickup,
+		})
+		if err := result.Error; err != nil {
+			return err
+		}
+	}
+
+	return db.UpdateProductsShipping(rule)
+}
+
+// UpdateProductsShipping sets shipping cost and total price on all products from the rule store,
+// same as setProductShipping but for all products at once
+func (db *SQL) UpdateProductsShipping(rule *ShippingRule) error {
+	result := db.Exec(`
+		UPDATE products
+		SET
+			shipping_cost = CASE WHEN ? > 0 AND price_isk >= ? THEN 0 ELSE ? END,
+			total_price = price_isk + CASE WHEN ? > 0 AND price_isk >= ? THEN 0 ELSE ? END,
+			free_pickup = ?
+		WHERE source = ?
+	`,
+		rule.FreeAbove, rule.FreeAbove, rule.FlatFee,
+		rule.FreeAbove, rule.FreeAbove, rule.FlatFee,
+		rule.FreePickup,
+		rule.Source,
 	)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateAllProductsShipping applies all shipping rules, products from stores without a rule
+// get no shipping cost
+func (db *SQL) UpdateAllProductsShipping() error {
+	rules, err := db.GetShippingRules()
 	if err != nil {
 		return err
 	}
 
+	sources := make([]string, len(*rules))
+	for i, rule := range *rules {
+		err := db.UpdateProductsShipping(&rule)
+		if err != nil {
+			return err
+		}
+		sources[i] = rule.Source
+	}
+
+	var result *gorm.DB
+	if len(sources) > 0 {
+		result = db.Exec(`
+			UPDATE products
+			SET shipping_cost = 0, total_price = price_isk, free_pickup = ?
+			WHERE source NOT IN ? AND (total_price != price_isk OR free_pickup = ?)
+		`, false, sources, true)
+	} else {
+		result = db.Exec(`
+			UPDATE products
+			SET shipping_cost = 0, total_price = price_isk, free_pickup = ?
+			WHERE total_price != price_isk OR free_pickup = ?
+		`, false, true)
+	}
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateNotifications adds notifications to the outbox, all or none of them
+func (db *SQL) CreateNotifications(notifications []*Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	result := db.Create(notifications)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateNotification saves the delivery state of a notification with ID
+func (db *SQL) UpdateNotification(notification *Notification) error {
+	if notification.ID == 0 {
+		return fmt.Errorf("no notification ID")
+	}
+
+	result := db.Model(notification).Updates(map[string]interface{}{
+		"status":        notification.Status,
+		"attempts":      notification.Attempts,
+		"last_error":    notification.LastError,
+		"next_retry_at": notification.NextRetryAt,
+		"sent_at":       notification.SentAt,
+	})
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetDueNotifications returns a limit of pending notifications due at now, longest waiting first
+func (db *SQL) GetDueNotifications(limit int, now time.Time) (*[]Notification, error) {
+	var notifications []Notification
+	result := db.
+		Where("status = ? AND next_retry_at <= ?", NotificationPending, now).
+		Order("next_retry_at asc, id asc").
+		Limit(limit).
+		Find(&notifications)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &notifications, nil
+}
+
+// GetNotifications returns a page of notifications with status, all when it's empty, newest first
+func (db *SQL) GetNotifications(status string, limit, offset int) (*[]Notification, error) {
+	var notifications []Notification
+	query := db.Order("id desc").Limit(limit).Offset(offset)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	result := query.Find(&notifications)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &notifications, nil
+}
+
+// RetryNotification makes a notification pending and due at now, attempts are kept so a failed one gets one more try
+func (db *SQL) RetryNotification(id uint, now time.Time) error {
+	result := db.Model(&Notification{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"status":        NotificationPending,
+		"next_retry_at": now,
+	})
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// GetEmailPreference returns the preference of email
+func (db *SQL) GetEmailPreference(email string) (*EmailPreference, error) {
+	var preference EmailPreference
+	result := db.Where("email = ?", email).First(&preference)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &preference, nil
+}
+
+// UpdateOrCreateEmailPreference updates or creates the preference of the same email
+func (db *SQL) UpdateOrCreateEmailPreference(preference *EmailPreference) error {
+	found, err := db.GetEmailPreference(preference.Email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	if found == nil {
+		result := db.Create(preference)
+		if err := result.Error; err != nil {
+			return err
+		}
+	} else {
+		preference.ID = found.ID
+		result := db.Model(preference).Updates(map[string]interface{}{
+			"digest": preference.Digest,
+		})
+		if err := result.Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateWatchEvent adds an alert to the next digest of the watcher email
+func (db *SQL) CreateWatchEvent(event *WatchEvent) error {
+	result := db.Create(event)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetDigestEmails returns the emails with waiting alerts that have the digest preference,
+// emails without a preference are immediate
+func (db *SQL) GetDigestEmails(digest string) (*[]string, error) {
+	var emails []string
+	result := db.Model(&WatchEvent{}).
+		Distinct("watch_events.email").
+		Joins("LEFT JOIN email_preferences ON email_preferences.email = watch_events.email AND email_preferences.deleted_at IS NULL").
+		Where("COALESCE(email_preferences.digest, ?) = ?", DigestImmediate, digest).
+		Order("watch_events.email asc").
+		Pluck("watch_events.email", &emails)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &emails, nil
+}
+
+// GetWatchEventsByEmail returns the alerts waiting for the digest of email, oldest first
+func (db *SQL) GetWatchEventsByEmail(email string) (*[]WatchEvent, error) {
+	var events []WatchEvent
+	result := db.Where("email = ?", email).Order("id asc").Find(&events)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &events, nil
+}
+
+// CreateDigestNotifications adds the notifications of a digest to the outbox and deletes the alerts in it,
+// in one transaction so alerts are neither lost nor sent twice
+func (db *SQL) CreateDigestNotifications(notifications []*Notification, eventIDs []uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := (&SQL{DB: tx}).CreateNotifications(notifications)
+		if err != nil {
+			return err
+		}
+
+		if len(eventIDs) == 0 {
+			return nil
+		}
+
+		result := tx.Where("id IN ?", eventIDs).Unscoped().Delete(WatchEvent{})
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// GetUserByEmail returns a single user by email
+func (db *SQL) GetUserByEmail(email string) (*User, error) {
+	var user User
+	result := db.Where("email = ?", email).First(&user)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// GetUserByLoginHash returns the user a login link was sent to
+func (db *SQL) GetUserByLoginHash(loginHash string) (*User, error) {
+	if loginHash == "" {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	var user User
+	result := db.Where("login_hash = ?", loginHash).First(&user)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CreateUser will create a user
+func (db *SQL) CreateUser(user *User) error {
+	result := db.Create(user)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateUser will update the login state of a user with ID
+func (db *SQL) UpdateUser(user *User) error {
+	if user.ID == 0 {
+		return fmt.Errorf("no user ID")
+	}
+
+	result := db.Model(user).Updates(map[string]interface{}{
+		"login_hash":    user.LoginHash,
+		"login_sent_at": user.LoginSentAt,
+		"last_login_at": user.LastLoginAt,
+	})
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CreateSession will create a session
+func (db *SQL) CreateSession(session *Session) error {
+	result := db.Create(session)
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LoginUser saves the login state of a user and creates the session it logged in with,
+// in one transaction so a login link is used up exactly when it makes a session
+func (db *SQL) LoginUser(user *User, session *Session) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		txDB := &SQL{DB: tx}
+
+		err := txDB.UpdateUser(user)
+		if err != nil {
+			return err
+		}
+
+		return txDB.CreateSession(session)
+	})
+}
+
+// GetUserBySessionToken returns the user of a session that hasn't expired at now
+func (db *SQL) GetUserBySessionToken(token string, now time.Time) (*User, error) {
+	var user User
+	result := db.
+		Joins("JOIN sessions ON sessions.user_id = users.id AND sessions.deleted_at IS NULL").
+		Where("sessions.token = ? AND sessions.expires_at > ?", token, now).
+		First(&user)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// DeleteSession deletes a session, so log out
+func (db *SQL) DeleteSession(token string) error {
+	result := db.Where("token = ?", token).Unscoped().Delete(Session{})
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateOrCreatePushSubscription creates the push subscription or updates the keys of the one
+// the user already has with its endpoint
+func (db *SQL) UpdateOrCreatePushSubscription(subscription *PushSubscription) error {
+	var stored PushSubscription
+	result := db.Where("user_id = ? AND endpoint = ?", subscription.UserID, subscription.Endpoint).First(&stored)
+	if err := result.Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		result := db.Create(subscription)
+		if err := result.Error; err != nil {
+			return err
+		}
+
+		return nil
+	}
+
+	subscription.ID = stored.ID
+	result = db.Model(&stored).Updates(map[string]interface{}{
+		"p256dh": subscription.P256dh,
+		"auth":   subscription.Auth,
+	})
+	if err := result.Error; err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPushSubscriptionsByEmail returns the push subscriptions of the user with email
+func (db *SQL) GetPushSubscriptionsByEmail(email string) (*[]PushSubscription, error) {
+	var subscriptions []PushSubscription
+	result := db.
+		Joins("JOIN users ON users.id = push_subscriptions.user_id AND users.deleted_at IS NULL").
+		Where("users.email = ?", email).
+		Order("push_subscriptions.id").
+		Find(&subscriptions)
+	if err := result.Error; err != nil {
+		return nil, err
+	}
+
+	return &subscriptions, nil
+}
+
+// DeletePushSubscription deletes the push subscription of the user with endpoint
+func (db *SQL) DeletePushSubscription(userID uint, endpoint string) error {
+	result := db.Where("user_id = ? AND endpoint = ?", userID, endpoint).Unscoped().Delete(PushSubscription{})
+	if err := result.Error; err != nil {
+		return err
+	}
+
 	return nil
 }
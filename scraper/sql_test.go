@@ -0,0 +1,583 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestSQL returns a migrated SQLite database in a temp dir
+func newTestSQL(t *testing.T) *SQL {
+	dialector, err := NewDialector(DialectSQLite, "", "", filepath.Join(t.TempDir(), "price.db"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gormDB, err := gorm.Open(dialector, &gorm.Config{
+		SkipDefaultTransaction: true,
+		Logger:                 logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &SQL{DB: gormDB}
+	err = db.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		sqlDB, err := gormDB.DB()
+		if err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	return db
+}
+
+// testProduct returns a scraped product like StoreProduct would store it
+func testProduct(source, url string, price uint) *Product {
+	return &Product{
+		Source:      source,
+		ProductCode: url,
+		Slug:        source + "-" + url,
+		URL:         "https://" + source + "/" + url,
+		Title:       url,
+		Price:       price,
+		PriceISK:    price,
+		ListPrice:   price,
+		TotalPrice:  price,
+		Currency:    BaseCurrency,
+		VATIncluded: true,
+		Specs:       []Spec{{Key: "Litur", Value: "Svartur"}, {Key: "Þyngd", Value: "1 kg"}},
+		Stocks:      []Stock{{Location: "Lindir", InStock: true}},
+		Categories:  []Category{{Name: "Tölvur", Slug: "tolvur"}},
+		Prices:      []Price{{Price: price, ListPrice: price, PriceISK: price, Date: time.Now()}},
+	}
+}
+
+func TestDiffChildren(t *testing.T) {
+	stored := []childRow{
+		{ID: 1, Key: "a"},
+		{ID: 2, Key: "b"},
+		{ID: 3, Key: "b"},
+		{ID: 4, Key: "c"},
+	}
+
+	tests := []struct {
+		scraped []string
+		create  []int
+		remove  []uint
+	}{
+		{[]string{"a", "b", "b", "c"}, nil, nil},
+		{[]string{"c", "b", "a", "b"}, nil, nil},
+		{[]string{"a", "b", "c"}, nil, []uint{3}},
+		{[]string{"a", "b", "b", "c", "d"}, []int{4}, nil},
+		{[]string{"d", "a"}, []int{0}, []uint{2, 3, 4}},
+		{[]string{}, nil, []uint{1, 2, 3, 4}},
+	}
+
+	for _, test := range tests {
+		create, remove := diffChildren(stored, test.scraped)
+		if !reflect.DeepEqual(create, test.create) || !reflect.DeepEqual(remove, test.remove) {
+			t.Errorf("Got %v and %v, want %v and %v for %v", create, remove, test.create, test.remove, test.scraped)
+		}
+	}
+}
+
+func TestUpdateOrCreateProduct(t *testing.T) {
+	db := newTestSQL(t)
+
+	created, err := db.UpdateOrCreateProduct(testProduct("elko.is", "sjonvarp", 99995))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs, err := db.GetProductSpecs(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Scraped again with a new price and one spec changed
+	scraped := testProduct("elko.is", "sjonvarp", 89995)
+	scraped.Specs[1].Value = "2 kg"
+	updated, err := db.UpdateOrCreateProduct(scraped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ID != created.ID {
+		t.Errorf("Got ID %d, want %d", updated.ID, created.ID)
+	}
+
+	product, err := db.GetProductByURL(scraped.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.Price != 89995 {
+		t.Errorf("Got price %d, want 89995", product.Price)
+	}
+
+	updatedSpecs, err := db.GetProductSpecs(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*updatedSpecs) != 2 {
+		t.Fatalf("Got %d specs, want 2", len(*updatedSpecs))
+	}
+	for _, spec := range *updatedSpecs {
+		if spec.Key == "Litur" && spec.ID != (*specs)[0].ID {
+			t.Errorf("Unchanged spec was rewritten")
+		}
+		if spec.Key == "Þyngd" && spec.Value != "2 kg" {
+			t.Errorf("Got %s, want 2 kg", spec.Value)
+		}
+	}
+
+	prices, err := db.GetProductPrices(created.ID, time.Now().AddDate(0, 0, -1), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*prices) != 2 {
+		t.Errorf("Got %d prices, want 2", len(*prices))
+	}
+}
+
+func TestUpdateOrCreateProducts(t *testing.T) {
+	db := newTestSQL(t)
+
+	// Same slug as the first one, so the second product fails on its own
+	failing := testProduct("ht.is", "fartolva", 150000)
+	failing.Slug = "elko.is-fartolva"
+	products := []*Product{
+		testProduct("elko.is", "fartolva", 149995),
+		failing,
+		testProduct("tl.is", "fartolva", 139995),
+	}
+
+	stored, errs, err := db.UpdateOrCreateProducts(products)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs[0] != nil || errs[2] != nil {
+		t.Errorf("Got errors %v", errs)
+	}
+	if errs[1] == nil {
+		t.Fatal("Got no error for duplicate slug")
+	}
+
+	found, err := db.GetProductsByIDs(stored[2].ID, stored[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*found) != 2 || (*found)[0].Source != "tl.is" || (*found)[1].Source != "elko.is" {
+		t.Errorf("Got %v, want tl.is and elko.is in that order", *found)
+	}
+
+	// Scraped again, the new child rows of both are inserted together
+	rescraped := []*Product{testProduct("elko.is", "fartolva", 129995), testProduct("tl.is", "fartolva", 139995)}
+	for _, p := range rescraped {
+		p.Specs[1].Value = "2 kg"
+		p.Stocks[0].InStock = false
+		p.AllImgURLs = []Image{{URL: "https://" + p.Source + "/mynd.jpg"}}
+	}
+	_, errs, err = db.UpdateOrCreateProducts(rescraped)
+	if err != nil || errs[0] != nil || errs[1] != nil {
+		t.Fatalf("Got %v, %v", err, errs)
+	}
+
+	for _, id := range []uint{stored[0].ID, stored[2].ID} {
+		specs, err := db.GetProductSpecs(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stocks, err := db.GetProductStocks(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images, err := db.GetProductImages(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prices, err := db.GetProductPrices(id, time.Now().AddDate(0, 0, -1), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*specs) != 2 || len(*stocks) != 1 || (*stocks)[0].InStock || len(*images) != 1 || len(*prices) != 2 {
+			t.Errorf("Product %d: got %d specs, %v stocks, %d images and %d prices", id, len(*specs), *stocks, len(*images), len(*prices))
+		}
+	}
+}
+
+func TestGetProductStockHistory(t *testing.T) {
+	db := newTestSQL(t)
+
+	scraped := []struct {
+		stocks []Stock
+		want   []StockChange
+	}{
+		{
+			[]Stock{{Location: "Lindir", InStock: true}, {Location: "Smáralind", InStock: false}},
+			[]StockChange{{Location: "Lindir", InStock: true}, {Location: "Smáralind", InStock: false}},
+		},
+		// Nothing changed
+		{
+			[]Stock{{Location: "Lindir", InStock: true}, {Location: "Smáralind", InStock: false}},
+			nil,
+		},
+		{
+			[]Stock{{Location: "Lindir", InStock: false}, {Location: "Smáralind", InStock: true}},
+			[]StockChange{{Location: "Lindir", InStock: false}, {Location: "Smáralind", InStock: true}},
+		},
+		// No longer listed at Smáralind
+		{
+			[]Stock{{Location: "Lindir", InStock: false}},
+			[]StockChange{{Location: "Smáralind", InStock: false}},
+		},
+		{
+			[]Stock{{Location: "Lindir", InStock: true}},
+			[]StockChange{{Location: "Lindir", InStock: true}},
+		},
+		// No stock info on the page
+		{
+			nil,
+			nil,
+		},
+	}
+
+	var id uint
+	var want []StockChange
+	for _, s := range scraped {
+		product := testProduct("elko.is", "ryksuga", 9995)
+		product.Stocks = s.stocks
+		stored, err := db.UpdateOrCreateProduct(product)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id = stored.ID
+		want = append(want, s.want...)
+	}
+
+	changes, err := db.GetProductStockHistory(id, time.Now().AddDate(0, 0, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*changes) != len(want) {
+		t.Fatalf("Got %d changes, want %d", len(*changes), len(want))
+	}
+	for i, c := range *changes {
+		if c.ProductID != id || c.Location != want[i].Location || c.InStock != want[i].InStock {
+			t.Errorf("Change %d: got %s %t, want %s %t", i, c.Location, c.InStock, want[i].Location, want[i].InStock)
+		}
+	}
+
+	stocks, err := db.GetProductStocks(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*stocks) != 1 || !(*stocks)[0].InStock {
+		t.Errorf("Got stocks %+v, want Lindir in stock", *stocks)
+	}
+}
+
+func TestGetProductPriceStats(t *testing.T) {
+	db := newTestSQL(t)
+
+	now := time.Now()
+	for _, p := range []Price{
+		{ProductID: 1, Price: 3000, Date: now.AddDate(0, 0, -60)},
+		{ProductID: 1, Price: 2000, Date: now.AddDate(0, 0, -40)},
+		{ProductID: 1, Price: 1500, Date: now.AddDate(0, 0, -3)},
+		{ProductID: 2, Price: 100, Date: now.AddDate(0, 0, -20)},
+	} {
+		p := p
+		result := db.Create(&p)
+		if err := result.Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 2000 is in effect when the window starts
+	stats, err := db.GetProductPriceStats(1, now.AddDate(0, 0, -10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Count != 1 || stats.Max != 2000 || stats.Current != 1500 || stats.Changes != 1 {
+		t.Errorf("Got count %d max %d current %d changes %d, want 1, 2000, 1500 and 1", stats.Count, stats.Max, stats.Current, stats.Changes)
+	}
+	if stats.LongestStableDays != 7 {
+		t.Errorf("Got %d longest stable days, want 7", stats.LongestStableDays)
+	}
+}
+
+func TestGetProducts(t *testing.T) {
+	db := newTestSQL(t)
+
+	cheap := testProduct("elko.is", "ryksuga", 9995)
+	cheap.OnSale = true
+	cheap.Discount = 20
+	expensive := testProduct("ht.is", "isskapur", 199995)
+	expensive.Brand = "Bosch"
+	expensive.Stocks = []Stock{{Location: "Lindir", InStock: false}}
+	expensive.Categories = []Category{{Name: "Heimilistæki", Slug: "heimilistaeki"}}
+	for _, p := range []*Product{cheap, expensive} {
+		_, err := db.UpdateOrCreateProduct(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	yes, no := true, false
+	tests := []struct {
+		query ProductQuery
+		want  []string
+	}{
+		{ProductQuery{}, []string{"elko.is", "ht.is"}},
+		{ProductQuery{Sort: ProductSort{Desc: true}}, []string{"ht.is", "elko.is"}},
+		{ProductQuery{PriceTo: 10000}, []string{"elko.is"}},
+		{ProductQuery{PriceFrom: 10000}, []string{"ht.is"}},
+		{ProductQuery{OnSale: &yes}, []string{"elko.is"}},
+		{ProductQuery{OnSale: &no}, []string{"ht.is"}},
+		{ProductQuery{InStock: &yes}, []string{"elko.is"}},
+		{ProductQuery{InStock: &no}, []string{"ht.is"}},
+		{ProductQuery{Location: "Lindir"}, []string{"elko.is"}},
+		{ProductQuery{Location: "Lindir", InStock: &no}, []string{"ht.is"}},
+		{ProductQuery{Location: "Smáralind"}, nil},
+		{ProductQuery{Sources: []string{"elko.is", "ht.is"}}, []string{"elko.is", "ht.is"}},
+		{ProductQuery{Sources: []string{"ht.is"}}, []string{"ht.is"}},
+		{ProductQuery{Categories: []string{"tolvur"}}, []string{"elko.is"}},
+		{ProductQuery{Brands: []string{"Bosch"}}, []string{"ht.is"}},
+		{ProductQuery{Sort: ProductSort{Field: "price", Desc: true}}, []string{"ht.is", "elko.is"}},
+		{ProductQuery{Sort: ProductSort{Field: "discount", Desc: true}}, []string{"elko.is", "ht.is"}},
+		{ProductQuery{Sort: ProductSort{Field: "title"}}, []string{"ht.is", "elko.is"}},
+		{ProductQuery{Sort: ProductSort{Field: "updated", Desc: true}}, []string{"ht.is", "elko.is"}},
+		{ProductQuery{Sort: ProductSort{Field: "popularity"}}, []string{"elko.is", "ht.is"}},
+		{ProductQuery{Sort: ProductSort{Field: "price_drop"}}, []string{"elko.is", "ht.is"}},
+	}
+
+	for _, test := range tests {
+		test.query.Limit = 10
+		products, _, err := db.GetProducts(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, p := range *products {
+			got = append(got, p.Source)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v: got %v, want %v", test.query, got, test.want)
+		}
+
+		count, err := db.GetProductsCount(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != len(test.want) {
+			t.Errorf("%+v: got count %d, want %d", test.query, count, len(test.want))
+		}
+	}
+
+	_, _, err := db.GetProducts(ProductQuery{Limit: 10, Sort: ProductSort{Field: "price; DROP TABLE products"}})
+	if err == nil {
+		t.Error("Got no error for a sort field that isn't whitelisted")
+	}
+}
+
+func TestGetProductsCursor(t *testing.T) {
+	db := newTestSQL(t)
+
+	// Two products share a price so ID has to break the tie, one has no unit price
+	for i, price := range []uint{3000, 1000, 2000, 2000, 5000} {
+		p := testProduct("elko.is", fmt.Sprintf("vara-%d", i), price)
+		p.UnitPrice = price / 10
+		if i == 4 {
+			p.UnitPrice = 0
+		}
+		_, err := db.UpdateOrCreateProduct(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		sort ProductSort
+		want []uint
+	}{
+		{ProductSort{Field: "id"}, []uint{1, 2, 3, 4, 5}},
+		{ProductSort{Field: "id", Desc: true}, []uint{5, 4, 3, 2, 1}},
+		{ProductSort{Field: "price"}, []uint{2, 3, 4, 1, 5}},
+		{ProductSort{Field: "price", Desc: true}, []uint{5, 1, 4, 3, 2}},
+		{ProductSort{Field: "unit_price"}, []uint{2, 3, 4, 1, 5}},
+		{ProductSort{Field: "unit_price", Desc: true}, []uint{1, 4, 3, 2, 5}},
+		{ProductSort{Field: "title"}, []uint{1, 2, 3, 4, 5}},
+		{ProductSort{Field: "title", Desc: true}, []uint{5, 4, 3, 2, 1}},
+		{ProductSort{Field: "updated"}, []uint{1, 2, 3, 4, 5}},
+	}
+
+	for _, test := range tests {
+		var got []uint
+		var cursor *Cursor
+		for {
+			products, next, err := db.GetProducts(ProductQuery{Limit: 2, Cursor: cursor, Sort: test.sort})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range *products {
+				got = append(got, p.ID)
+			}
+			if next == nil {
+				break
+			}
+
+			// Cursors go through URLs as strings
+			cursor, err = ParseCursor(next.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.sort.Name(), got, test.want)
+		}
+	}
+
+	// Deleting a product already paged through doesn't skip the next one
+	products, next, err := db.GetProducts(ProductQuery{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.DeleteProductByID((*products)[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	products, _, err = db.GetProducts(ProductQuery{Limit: 2, Cursor: next})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*products) != 2 || (*products)[0].ID != 3 {
+		t.Errorf("Got %v after delete, want products 3 and 4", *products)
+	}
+
+	// A cursor is only valid for the sort it was made for
+	_, _, err = db.GetProducts(ProductQuery{Limit: 2, Cursor: next, Sort: ProductSort{Field: "price"}})
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Got %v for a cursor of another sort, want ErrInvalidCursor", err)
+	}
+
+	_, err = ParseCursor("not a cursor")
+	if !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Got %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestGetPopularProductsCursor(t *testing.T) {
+	db := newTestSQL(t)
+
+	for i := 0; i < 3; i++ {
+		p, err := db.UpdateOrCreateProduct(testProduct("elko.is", fmt.Sprintf("vara-%d", i), 1000))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.SetProductViewCount(p.ID, 10-i)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	products, next, err := db.GetPopularProducts(2, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*products) != 2 || next == nil {
+		t.Fatalf("Got %d products and cursor %v, want 2 and a cursor", len(*products), next)
+	}
+
+	products, next, err = db.GetPopularProducts(2, 0, next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*products) != 1 || (*products)[0].ID != 3 || next != nil {
+		t.Errorf("Got %v and cursor %v, want product 3 and no cursor", *products, next)
+	}
+}
+
+func TestGetSuspiciousSaleProductsCursor(t *testing.T) {
+	db := newTestSQL(t)
+
+	for _, p := range []*Product{testProduct("ht.is", "ofn", 5000), testProduct("elko.is", "ofn", 5000), testProduct("elko.is", "ryksuga", 5000)} {
+		stored, err := db.UpdateOrCreateProduct(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.UpdateProductSuspiciousSale(stored.ID, true, 5000)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	products, next, err := db.GetSuspiciousSaleProducts(2, 0, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*products) != 2 || (*products)[0].ID != 3 || (*products)[1].ID != 2 || next == nil {
+		t.Fatalf("Got %v and cursor %v, want products 3 and 2 and a cursor", *products, next)
+	}
+
+	products, next, err = db.GetSuspiciousSaleProducts(2, 0, next, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*products) != 1 || (*products)[0].ID != 1 || next != nil {
+		t.Errorf("Got %v and cursor %v, want product 1 and no cursor", *products, next)
+	}
+
+	_, _, err = db.GetSuspiciousSaleProducts(2, 2, &Cursor{Sort: "source asc", Text: "elko.is", ID: 2}, nil)
+	if !errors.Is(err, ErrCursorOffset) {
+		t.Errorf("Got %v, want ErrCursorOffset", err)
+	}
+}
+
+func TestUpdateAllProductsShipping(t *testing.T) {
+	db := newTestSQL(t)
+
+	for _, p := range []*Product{testProduct("elko.is", "ofn", 5000), testProduct("ht.is", "ofn", 5000)} {
+		_, err := db.UpdateOrCreateProduct(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := db.UpdateOrCreateShippingRule(&ShippingRule{Source: "elko.is", FlatFee: 1990, FreeAbove: 10000, FreePickup: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.UpdateAllProductsShipping()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	products, _, err := db.GetProducts(ProductQuery{Limit: 10, Sort: ProductSort{Field: "total_price", Desc: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*products) != 2 {
+		t.Fatalf("Got %d products, want 2", len(*products))
+	}
+	elko, ht := (*products)[0], (*products)[1]
+	if elko.Source != "elko.is" || elko.TotalPrice != 6990 || !elko.FreePickup {
+		t.Errorf("Got %s %d %v, want elko.is 6990 true", elko.Source, elko.TotalPrice, elko.FreePickup)
+	}
+	if ht.TotalPrice != 5000 || ht.FreePickup {
+		t.Errorf("Got %d %v, want 5000 false", ht.TotalPrice, ht.FreePickup)
+	}
+}
@@ -0,0 +1,57 @@
+package scraper
+
+// stockStates returns the locations of stocks in order and if each is in stock,
+// a location listed more than once is in stock if any of its rows is
+func stockStates(stocks []Stock) ([]string, map[string]bool) {
+	var locations []string
+	states := make(map[string]bool)
+	for _, s := range stocks {
+		inStock, ok := states[s.Location]
+		if !ok {
+			locations = append(locations, s.Location)
+		}
+		states[s.Location] = inStock || s.InStock
+	}
+
+	return locations, states
+}
+
+// DiffStockChanges returns the changes of product from stored to scraped stocks.
+// New locations are recorded as they are, and locations that were in stock
+// but are no longer listed go out of stock
+func DiffStockChanges(productID uint, stored, scraped []Stock) []StockChange {
+	storedLocations, storedStates := stockStates(stored)
+	scrapedLocations, scrapedStates := stockStates(scraped)
+
+	var changes []StockChange
+	for _, location := range scrapedLocations {
+		inStock, ok := storedStates[location]
+		if ok && inStock == scrapedStates[location] {
+			continue
+		}
+		changes = append(changes, StockChange{Location: location, InStock: scrapedStates[location], ProductID: productID})
+	}
+
+	for _, location := range storedLocations {
+		if _, ok := scrapedStates[location]; ok || !storedStates[location] {
+			continue
+		}
+		changes = append(changes, StockChange{Location: location, InStock: false, ProductID: productID})
+	}
+
+	return changes
+}
+
+// inStockAt returns the locations stocks are in stock at
+func inStockAt(stocks []Stock) []string {
+	locations, states := stockStates(stocks)
+
+	var at []string
+	for _, location := range locations {
+		if states[location] {
+			at = append(at, location)
+		}
+	}
+
+	return at
+}
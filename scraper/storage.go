@@ -17,11 +17,60 @@ type Storage interface {
 	Clear() error
 }
 
-// StoreProduct saves product to database and elasticsearch
+// StoreProduct saves product to database and elasticsearch, through the batch Writer if set
 func (s *Scraper) StoreProduct(product *Product) error {
 	// MySQL
 	product.URL = formatters.GetCleanURL(formatters.GetURLWithoutWWW(product.URL), []string{"ProductID"})
 
+	// List price is the same as price when the store doesn't show a regular price
+	if product.ListPrice < product.Price {
+		product.ListPrice = product.Price
+	}
+	product.Discount = formatters.GetDiscountPercent(product.ListPrice, product.Price)
+	for i := range product.Prices {
+		if product.Prices[i].ListPrice < product.Prices[i].Price {
+			product.Prices[i].ListPrice = product.Prices[i].Price
+		}
+	}
+
+	// Stores that don't set a currency show ISK prices with VAT
+	if product.Currency == "" {
+		product.Currency = BaseCurrency
+		product.VATIncluded = true
+	}
+	priceISK, err := s.Currencies.ToISK(product.Price, product.Currency, product.VATIncluded)
+	if err != nil {
+		return fmt.Errorf("error converting price for %s: %w", product.URL, err)
+	}
+	product.PriceISK = priceISK
+	for i := range product.Prices {
+		product.Prices[i].Currency = product.Currency
+		product.Prices[i].VATIncluded = product.VATIncluded
+		product.Prices[i].PriceISK, err = s.Currencies.ToISK(product.Prices[i].Price, product.Currency, product.VATIncluded)
+		if err != nil {
+			return fmt.Errorf("error converting price for %s: %w", product.URL, err)
+		}
+	}
+
+	// Price to door
+	shippingRule, err := s.getShippingRule(product.Source)
+	if err != nil {
+		return fmt.Errorf("error getting shipping rule for %s: %w", product.Source, err)
+	}
+	setProductShipping(product, shippingRule)
+
+	// Price per kg, l, ...
+	setProductUnitPrice(product)
+
+	// Brand from specs, for filtering
+	setProductBrand(product)
+
+	// Batched when the writer is running
+	if s.Writer != nil {
+		s.Writer.Write(product)
+		return nil
+	}
+
 	storedProduct, err := s.DB.UpdateOrCreateProduct(product)
 	if err != nil {
 		return fmt.Errorf("error storing product %s in database: %w", product.URL, err)
@@ -30,6 +79,18 @@ func (s *Scraper) StoreProduct(product *Product) error {
 	metrics.ProductStoredCount.Inc()
 
 	// Elasticsearch
+	err = s.ES.UpdateOrIndexSearchProduct(newSearchProduct(storedProduct.ID, product))
+	if err != nil {
+		return fmt.Errorf("error storing search product in Elasticsearch: %w", err)
+	}
+
+	metrics.ProductStoredESCount.Inc()
+
+	return nil
+}
+
+// newSearchProduct returns the searchable fields of product stored with id
+func newSearchProduct(id uint, product *Product) *SearchProduct {
 	categories := make([]string, len(product.Categories))
 	for i, c := range product.Categories {
 		categories[i] = c.Name
@@ -40,26 +101,73 @@ func (s *Scraper) StoreProduct(product *Product) error {
 		formatters.GetCleanURL(formatters.GetURLWithWWW(product.URL), []string{"ProductID"}),
 	}
 
-	searchProduct := &SearchProduct{
-		ID:          storedProduct.ID,
-		ScrapedAt:   time.Now().UTC().Format("2006-01-02T15:04:05Z"),
-		Source:      product.Source,
-		ProductCode: product.ProductCode,
-		Slug:        product.Slug,
-		URL:         urls,
-		Title:       product.Title,
-		Categories:  categories,
-		Description: product.Description,
-		MainImgURL:  product.MainImgURL,
-		Price:       product.Price,
-		OnSale:      product.OnSale,
-	}
-	err = s.ES.UpdateOrIndexSearchProduct(searchProduct)
-	if err != nil {
-		return fmt.Errorf("error storing search product in Elasticsearch: %w", err)
+	return &SearchProduct{
+		ID:           id,
+		ScrapedAt:    time.Now().UTC().Format("2006-01-02T15:04:05Z"),
+		Source:       product.Source,
+		ProductCode:  product.ProductCode,
+		Slug:         product.Slug,
+		URL:          urls,
+		Title:        product.Title,
+		Categories:   categories,
+		Description:  product.Description,
+		MainImgURL:   product.MainImgURL,
+		Price:        product.Price,
+		PriceISK:     product.PriceISK,
+		Currency:     product.Currency,
+		ListPrice:    product.ListPrice,
+		ShippingCost: product.ShippingCost,
+		TotalPrice:   product.TotalPrice,
+		OnSale:       product.OnSale,
+		InStock:      len(inStockAt(product.Stocks)) > 0,
+		InStockAt:    inStockAt(product.Stocks),
 	}
+}
 
-	metrics.ProductStoredESCount.Inc()
+// ReindexSearchProducts indexes every stored product again with its stocks and categories,
+// for search fields that documents indexed before they were added don't have, ex. InStock.
+// Returns how many products were indexed
+func (s *Scraper) ReindexSearchProducts() (int, error) {
+	limit := 100
+	var cursor *Cursor
+	indexed := 0
 
-	return nil
+	for {
+		products, next, err := s.DB.GetProducts(ProductQuery{Limit: limit, Cursor: cursor})
+		if err != nil {
+			return indexed, err
+		}
+
+		searchProducts := make([]*SearchProduct, len(*products))
+		for i := range *products {
+			product := &(*products)[i].Product
+
+			stocks, err := s.DB.GetProductStocks(product.ID)
+			if err != nil {
+				return indexed, err
+			}
+			product.Stocks = *stocks
+
+			categories, err := s.DB.GetProductCategories(product.ID)
+			if err != nil {
+				return indexed, err
+			}
+			product.Categories = *categories
+
+			searchProducts[i] = newSearchProduct(product.ID, product)
+		}
+
+		err = s.ES.BulkIndexSearchProducts(searchProducts)
+		if err != nil {
+			return indexed, err
+		}
+		indexed += len(searchProducts)
+
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+
+	return indexed, nil
 }
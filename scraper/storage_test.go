@@ -0,0 +1,40 @@
+package scraper
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReindexSearchProducts(t *testing.T) {
+	db := newTestSQL(t)
+
+	inStock := testProduct("elko.is", "ryksuga", 9995)
+	outOfStock := testProduct("elko.is", "brauðrist", 4995)
+	outOfStock.Stocks = []Stock{{Location: "Lindir", InStock: false}}
+	for _, product := range []*Product{inStock, outOfStock} {
+		_, err := db.UpdateOrCreateProduct(product)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := &testSearch{}
+	s := &Scraper{DB: db, ES: search}
+	indexed, err := s.ReindexSearchProducts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 2 || search.count() != 2 {
+		t.Fatalf("Got %d indexed products, %d in the index, want 2", indexed, search.count())
+	}
+
+	for _, product := range search.products {
+		want := product.Title == "ryksuga"
+		if product.InStock != want {
+			t.Errorf("Got %s in stock %t, want %t", product.Title, product.InStock, want)
+		}
+		if !reflect.DeepEqual(product.Categories, []string{"Tölvur"}) {
+			t.Errorf("Got %s categories %v, want [Tölvur]", product.Title, product.Categories)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package scraper
+
+import (
+	"strings"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+)
+
+// unitSpecKeys are spec keys that might have the package quantity, lowercase
+var unitSpecKeys = []string{
+	"magn",
+	"rúmmál",
+	"innihald",
+	"nettóþyngd",
+	"lengd",
+	"stærð pakkningar",
+}
+
+// setProductUnitPrice finds the package quantity in the title, or specs if not in the title,
+// and sets the unit and ISK price per unit on the product
+func setProductUnitPrice(product *Product) {
+	product.Unit = ""
+	product.UnitQuantity = 0
+	product.UnitPrice = 0
+
+	quantity, unit, ok := formatters.ParseQuantity(product.Title)
+	if !ok {
+		for _, spec := range product.Specs {
+			key := strings.ToLower(strings.TrimSpace(spec.Key))
+			if !containsAny(key, unitSpecKeys) {
+				continue
+			}
+
+			quantity, unit, ok = formatters.ParseQuantity(spec.Value)
+			if ok {
+				break
+			}
+		}
+	}
+
+	if !ok {
+		return
+	}
+
+	product.Unit = unit
+	product.UnitQuantity = quantity
+	product.UnitPrice = formatters.GetUnitPrice(product.PriceISK, quantity)
+}
+
+// containsAny checks if str contains any of the items in lst
+func containsAny(str string, lst []string) bool {
+	for _, s := range lst {
+		if strings.Contains(str, s) {
+			return true
+		}
+	}
+
+	return false
+}
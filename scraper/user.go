@@ -0,0 +1,51 @@
+package scraper
+
+import (
+	"fmt"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+)
+
+// LoginLinkTTL is how long a login link works after it's sent
+const LoginLinkTTL = time.Hour
+
+// SessionTTL is how long a session lasts after login
+const SessionTTL = 30 * 24 * time.Hour
+
+// SetLoginHash starts a login at now and returns the token for the login link,
+// only its hash is stored
+func (u *User) SetLoginHash(now time.Time) (string, error) {
+	token, err := formatters.GetRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	u.LoginHash = formatters.HashToken(token)
+	u.LoginSentAt = &now
+
+	return token, nil
+}
+
+// Login finishes a login at now with the link sent by SetLoginHash and returns a new session
+// and its bearer token, only the hash of the token is in the session. The link only works once
+func (u *User) Login(now time.Time) (*Session, string, error) {
+	if u.LoginHash == "" || u.LoginSentAt == nil || now.Sub(*u.LoginSentAt) > LoginLinkTTL {
+		return nil, "", fmt.Errorf("login link has expired")
+	}
+
+	token, err := formatters.GetRandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	u.LoginHash = ""
+	u.LoginSentAt = nil
+	u.LastLoginAt = &now
+
+	return &Session{
+		UserID:    u.ID,
+		Token:     formatters.HashToken(token),
+		ExpiresAt: now.Add(SessionTTL),
+	}, token, nil
+}
@@ -0,0 +1,116 @@
+package scraper
+
+import (
+	"testing"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+)
+
+func TestUserLogin(t *testing.T) {
+	db := newTestSQL(t)
+
+	user := &User{Email: "a@b.is"}
+	err := db.CreateUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	loginToken, err := user.SetLoginHash(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.UpdateUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err = db.GetUserByLoginHash(formatters.HashToken(loginToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = user.Login(now.Add(LoginLinkTTL + time.Minute))
+	if err == nil {
+		t.Error("Got no error for an expired login link")
+	}
+
+	session, token, err := user.Login(now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Token != formatters.HashToken(token) {
+		t.Errorf("Got session token %q, want the hash of %q", session.Token, token)
+	}
+	if user.LoginHash != "" || user.LastLoginAt == nil {
+		t.Errorf("Got login hash %q and last login %v after login", user.LoginHash, user.LastLoginAt)
+	}
+	if _, _, err := user.Login(now.Add(time.Minute)); err == nil {
+		t.Error("Got no error for a used login link")
+	}
+
+	err = db.LoginUser(user, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := db.GetUserBySessionToken(session.Token, now)
+	if err != nil || found.ID != user.ID {
+		t.Fatalf("Got %v, %v for the session, want user %d", found, err, user.ID)
+	}
+
+	_, err = db.GetUserBySessionToken(session.Token, session.ExpiresAt.Add(time.Second))
+	if err == nil {
+		t.Error("Got a user for an expired session")
+	}
+
+	err = db.DeleteSession(session.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.GetUserBySessionToken(session.Token, now)
+	if err == nil {
+		t.Error("Got a user for a deleted session")
+	}
+}
+
+func TestLoginUserRollback(t *testing.T) {
+	db := newTestSQL(t)
+
+	user := &User{Email: "a@b.is"}
+	err := db.CreateUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	loginToken, err := user.SetLoginHash(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.UpdateUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, _, err := user.Login(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The session token is taken, so the login link isn't used up
+	err = db.CreateSession(&Session{UserID: user.ID, Token: session.Token, ExpiresAt: session.ExpiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.LoginUser(user, session)
+	if err == nil {
+		t.Fatal("Got no error for a taken session token")
+	}
+
+	_, err = db.GetUserByLoginHash(formatters.HashToken(loginToken))
+	if err != nil {
+		t.Errorf("Got %v, want the login link to still work", err)
+	}
+}
@@ -16,14 +16,14 @@ func (s *Scraper) StartViewCounter() error {
 		defer metrics.ViewCountersRunning.Dec()
 
 		limit := 100
-		offset := 0
+		var afterID uint
 
 		// Before will be deleted
 		now := time.Now()
 		before := now.Add(time.Duration(-336) * time.Hour) // 2 weeks
 
 		for {
-			productViewCounts, err := s.DB.GetProductViewCounts(limit, offset)
+			productViewCounts, err := s.DB.GetProductViewCounts(limit, afterID)
 			if err != nil {
 				log.Print(err)
 				break
@@ -43,7 +43,7 @@ func (s *Scraper) StartViewCounter() error {
 				}
 			}
 
-			offset = offset + limit
+			afterID = (*productViewCounts)[len(*productViewCounts)-1].ID
 		}
 	})
 	c.Start()
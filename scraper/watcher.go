@@ -1,22 +1,145 @@
 package scraper
 
 import (
-	"bytes"
 	"fmt"
-	"html/template"
 	"log"
-	"os"
-	"strconv"
 	"sync"
 	"time"
 
+	"bitbucket.org/hilmarp/price-scraper/formatters"
 	"bitbucket.org/hilmarp/price-scraper/metrics"
+	"bitbucket.org/hilmarp/price-scraper/notify"
+	"bitbucket.org/hilmarp/price-scraper/templates"
 	"github.com/robfig/cron/v3"
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
-// StartWatcher will send price alerts
+// Alert types a watcher can choose
+const (
+	AlertPriceDrop   string = "price_drop"
+	AlertBackInStock string = "back_in_stock"
+	AlertInStockAt   string = "in_stock_at" // back in stock at a location
+)
+
+// SetAlerts sets which alerts the watcher gets, price drops when alerts is empty.
+// location is required with AlertInStockAt and not allowed without it
+func (w *WatchProduct) SetAlerts(alerts []string, location string) error {
+	if len(alerts) == 0 {
+		alerts = []string{AlertPriceDrop}
+	}
+
+	w.PriceDrop = false
+	w.BackInStock = false
+	w.Location = ""
+
+	for _, alert := range alerts {
+		switch alert {
+		case AlertPriceDrop:
+			w.PriceDrop = true
+		case AlertBackInStock:
+			w.BackInStock = true
+		case AlertInStockAt:
+			if location == "" {
+				return fmt.Errorf("%s alert needs a location", AlertInStockAt)
+			}
+			w.BackInStock = true
+			w.Location = location
+		default:
+			return fmt.Errorf("unknown alert %s, use %s, %s or %s", alert, AlertPriceDrop, AlertBackInStock, AlertInStockAt)
+		}
+	}
+
+	if location != "" && w.Location == "" {
+		return fmt.Errorf("location is only used with the %s alert", AlertInStockAt)
+	}
+
+	return nil
+}
+
+// SetThresholds sets the price drops the watcher wants, 0 is no threshold.
+// They're only used with AlertPriceDrop, so SetAlerts should be called first
+func (w *WatchProduct) SetThresholds(targetPrice, minDrop, minDropPercent uint) error {
+	if !w.PriceDrop && (targetPrice > 0 || minDrop > 0 || minDropPercent > 0) {
+		return fmt.Errorf("price thresholds are only used with the %s alert", AlertPriceDrop)
+	}
+
+	if minDropPercent > 100 {
+		return fmt.Errorf("minimum drop can't be more than 100%%")
+	}
+
+	w.TargetPrice = targetPrice
+	w.MinDrop = minDrop
+	w.MinDropPercent = minDropPercent
+
+	return nil
+}
+
+// DefaultVerifyTTL is how long verify links work when it isn't configured
+const DefaultVerifyTTL = 48 * time.Hour
+
+// DefaultUnverifiedWatchTTL is how long unverified watches are kept when it isn't configured
+const DefaultUnverifiedWatchTTL = 7 * 24 * time.Hour
+
+// SetVerifyHash sets a new verify token sent at now and returns it for the verify link,
+// only its hash is stored
+func (w *WatchProduct) SetVerifyHash(now time.Time) (string, error) {
+	token, err := formatters.GetRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	w.VerifyHash = formatters.HashToken(token)
+	w.VerifySentAt = &now
+
+	return token, nil
+}
+
+// NewUnsubscribeToken returns a new token for the unsubscribe link in an alert about the watch with ID,
+// and its hash to store, like the verify token only the hash is stored
+func NewUnsubscribeToken(watchProductID uint) (*UnsubscribeToken, string, error) {
+	token, err := formatters.GetRandomToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &UnsubscribeToken{WatchProductID: watchProductID, Hash: formatters.HashToken(token)}, token, nil
+}
+
+// unsubscribeToken stores the hash of a new unsubscribe token for the watch with ID and returns the token
+func (s *Scraper) unsubscribeToken(watchProductID uint) (string, error) {
+	unsubscribe, token, err := NewUnsubscribeToken(watchProductID)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.DB.CreateUnsubscribeToken(unsubscribe)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Verify verifies the watch at now if the verify link was sent at most ttl before
+func (w *WatchProduct) Verify(now time.Time, ttl time.Duration) error {
+	if w.Verified {
+		return nil
+	}
+
+	sent := w.CreatedAt
+	if w.VerifySentAt != nil {
+		sent = *w.VerifySentAt
+	}
+
+	if now.Sub(sent) > ttl {
+		return fmt.Errorf("verify link has expired")
+	}
+
+	w.Verified = true
+
+	return nil
+}
+
+// StartWatcher will send price and stock alerts
 func (s *Scraper) StartWatcher() error {
 	c := cron.New()
 	c.AddFunc("20 */2 * * *", func() { // At minute 20 past every 2nd hour
@@ -24,18 +147,16 @@ func (s *Scraper) StartWatcher() error {
 		defer metrics.WatchersRunning.Dec()
 
 		limit := 100
-		offset := 0
+		var afterID uint
 
 		// From is 3 days
 		now := time.Now()
 		from := now.Add(time.Duration(-72) * time.Hour)
 
-		absPath := os.Getenv("PRICE_ABS_PATH")
-
 		var wg sync.WaitGroup
 
 		for {
-			watchProducts, err := s.DB.GetWatchProducts(limit, offset)
+			watchProducts, err := s.DB.GetWatchProducts(limit, afterID)
 			if err != nil {
 				log.Print(err)
 				break
@@ -46,115 +167,79 @@ func (s *Scraper) StartWatcher() error {
 			}
 
 			for _, watchProduct := range *watchProducts {
-				prices, err := s.DB.GetProductPrices(watchProduct.ProductID, from, "id desc")
-				if err != nil {
-					log.Print(err)
-					continue
+				var dropped bool
+				var currentPrice, price Price
+				if watchProduct.PriceDrop {
+					prices, err := s.DB.GetProductPrices(watchProduct.ProductID, from, true)
+					if err != nil {
+						log.Print(err)
+						continue
+					}
+					currentPrice, price, dropped = priceDrop(watchProduct, *prices)
 				}
 
-				if len(*prices) == 0 {
+				var change *StockChange
+				if watchProduct.BackInStock {
+					// All of it, the stock before the watcher was created is needed to see what changed after
+					changes, err := s.DB.GetProductStockHistory(watchProduct.ProductID, time.Time{})
+					if err != nil {
+						log.Print(err)
+						continue
+					}
+					change = backInStock(watchProduct, *changes)
+				}
+
+				if !dropped && change == nil {
 					continue
 				}
 
-				currentPrice := (*prices)[0]
+				// Both alerts are sent in the same goroutine so the watcher is updated once
+				wg.Add(1)
+				go func(watchProduct WatchProduct, dropped bool, currentPrice, price Price, change *StockChange) {
+					defer wg.Done()
 
-				for _, price := range *prices {
-					// If the price is older than the watcher, we break
-					if price.CreatedAt.Before(watchProduct.CreatedAt) {
-						break
+					product, err := s.DB.GetProductByID(watchProduct.ProductID)
+					if err != nil {
+						log.Println(err)
+						return
 					}
 
-					// Same price, keep going
-					if price.Price == currentPrice.Price {
-						continue
+					digest, err := s.emailDigest(watchProduct.Email)
+					if err != nil {
+						log.Println(err)
+						return
 					}
 
-					// Lower price found which means the product now has a higher price,
-					// will be handled later, for now keep going
-					if price.Price < currentPrice.Price {
-						continue
-					}
-
-					// Higher price found which means the product has dropped in price, so notify the watcher
-					if price.Price > currentPrice.Price {
-						// If en email has already been sent about this price entry, break here
-						if watchProduct.Sent != nil && *watchProduct.PriceIDSent == price.ID {
-							break
+					if dropped {
+						err := s.sendPriceDropAlert(watchProduct, digest, product, currentPrice, price)
+						if err != nil {
+							log.Println(err)
+						} else {
+							metrics.WatcherEmailsSent.Inc()
+							watchProduct.Sent = &now
+							watchProduct.PriceIDSent = &price.ID
 						}
+					}
 
-						wg.Add(1)
-						go func(watchProduct WatchProduct, currentPrice, price Price) {
-							defer wg.Done()
-
-							type email struct {
-								UnsubscribeHash string
-								PriceOld        string
-								PriceNew        string
-								PriceDiff       string
-								ProductURL      string
-								ProductTitle    string
-								Date            string
-							}
-
-							product, err := s.DB.GetProductByID(watchProduct.ProductID)
-							if err != nil {
-								log.Println(err)
-								return
-							}
-
-							emailTxt := email{
-								UnsubscribeHash: watchProduct.UnsubscribeHash,
-								PriceOld:        strconv.Itoa(int(price.Price)),
-								PriceNew:        strconv.Itoa(int(currentPrice.Price)),
-								PriceDiff:       strconv.Itoa(int(price.Price - currentPrice.Price)),
-								ProductURL:      fmt.Sprintf("https://verdfra.is/product/%v", product.Slug),
-								ProductTitle:    product.Title,
-								Date:            currentPrice.Date.Format("02/01/2006 15:04"),
-							}
-
-							tmpl, err := template.ParseFiles(fmt.Sprintf("%s/templates/watch-product.html", absPath))
-							if err != nil {
-								log.Println(err)
-								return
-							}
-
-							var tmplBuffer bytes.Buffer
-							err = tmpl.Execute(&tmplBuffer, emailTxt)
-							if err != nil {
-								log.Println(err)
-								return
-							}
-
-							from := mail.NewEmail("Verð frá", os.Getenv("PRICE_EMAIL_FROM"))
-							subject := fmt.Sprintf("Verðlækkun - %v", product.Title)
-							to := mail.NewEmail(watchProduct.Email, watchProduct.Email)
-							tmplStr := tmplBuffer.String()
-							message := mail.NewSingleEmail(from, subject, to, tmplStr, tmplStr)
-							client := sendgrid.NewSendClient(os.Getenv("PRICE_EMAIL_API_KEY"))
-							_, err = client.Send(message)
-							if err != nil {
-								log.Println(err)
-								return
-							}
-
+					if change != nil {
+						err := s.sendBackInStockAlert(watchProduct, digest, product, change)
+						if err != nil {
+							log.Println(err)
+						} else {
 							metrics.WatcherEmailsSent.Inc()
+							watchProduct.StockChangeIDSent = &change.ID
+						}
+					}
 
-							watchProduct.Sent = &now
-							watchProduct.PriceIDSent = &price.ID
-							err = s.DB.UpdateWatchProduct(&watchProduct)
-							if err != nil {
-								log.Println(err)
-								return
-							}
-						}(watchProduct, currentPrice, price)
-
-						// No need to check any more
-						break
+					err = s.DB.UpdateWatchProduct(&watchProduct)
+					if err != nil {
+						log.Println(err)
+						return
 					}
-				}
+				}(watchProduct, dropped, currentPrice, price, change)
 			}
 
-			offset = offset + limit
+			afterID = (*watchProducts)[len(*watchProducts)-1].ID
 		}
 
 		wg.Wait()
@@ -163,3 +248,222 @@ func (s *Scraper) StartWatcher() error {
 
 	return nil
 }
+
+// priceDrop checks prices of the watched product, newest first, and returns the current price,
+// the highest price since the watcher was created or last sent a price alert, and true if the price
+// dropped from it by as much as the watcher wants
+func priceDrop(watchProduct WatchProduct, prices []Price) (Price, Price, bool) {
+	if len(prices) == 0 {
+		return Price{}, Price{}, false
+	}
+
+	currentPrice := prices[0]
+
+	var highest *Price
+	for i, price := range prices {
+		// If the price is older than the watcher, we break
+		if price.CreatedAt.Before(watchProduct.CreatedAt) {
+			break
+		}
+
+		if highest == nil || price.Price > highest.Price {
+			highest = &prices[i]
+		}
+
+		// The price when the last alert was sent is the last one that counts,
+		// so drops are from the price the watcher was told about
+		if watchProduct.Sent != nil && !price.CreatedAt.After(*watchProduct.Sent) {
+			break
+		}
+	}
+
+	if highest == nil || highest.Price <= currentPrice.Price {
+		return Price{}, Price{}, false
+	}
+
+	// If an email has already been sent about this price entry
+	if watchProduct.PriceIDSent != nil && *watchProduct.PriceIDSent == highest.ID {
+		return Price{}, Price{}, false
+	}
+
+	// Thresholds are in ISK like the alert, at the current rate so it's only the price that dropped
+	if !watchProduct.wantsDrop(priceISKAt(*highest, currentPrice), currentPrice.PriceISK) {
+		return Price{}, Price{}, false
+	}
+
+	return currentPrice, *highest, true
+}
+
+// wantsDrop returns if a drop from oldPrice to newPrice in ISK passes the watcher thresholds
+func (w WatchProduct) wantsDrop(oldPrice, newPrice uint) bool {
+	if w.TargetPrice > 0 && newPrice > w.TargetPrice {
+		return false
+	}
+
+	drop := oldPrice - newPrice
+	if w.MinDrop > 0 && drop < w.MinDrop {
+		return false
+	}
+
+	if w.MinDropPercent > 0 && drop*100 < w.MinDropPercent*oldPrice {
+		return false
+	}
+
+	return true
+}
+
+// backInStock checks the stock history of the watched product, oldest first, and returns the change
+// that brought it back in stock at the watched location, or any location, after the watcher was created.
+// It's nil if that didn't happen, the product is out of stock again or the watcher was already sent it
+func backInStock(watchProduct WatchProduct, changes []StockChange) *StockChange {
+	states := make(map[string]bool)
+	inStock := func() bool {
+		if watchProduct.Location != "" {
+			return states[watchProduct.Location]
+		}
+		for _, s := range states {
+			if s {
+				return true
+			}
+		}
+		return false
+	}
+
+	var backIn *StockChange
+	for i, change := range changes {
+		before := inStock()
+		states[change.Location] = change.InStock
+		after := inStock()
+
+		if !before && after && !change.CreatedAt.Before(watchProduct.CreatedAt) {
+			backIn = &changes[i]
+		}
+		if before && !after {
+			backIn = nil
+		}
+	}
+
+	if backIn == nil || watchProduct.StockChangeIDSent != nil && *watchProduct.StockChangeIDSent == backIn.ID {
+		return nil
+	}
+
+	return backIn
+}
+
+// priceISKAt returns price in ISK at the rate current was converted with, so a drop in the product currency
+// is a drop in ISK too when the rate changed in between
+func priceISKAt(price, current Price) uint {
+	if current.Price == 0 {
+		return price.PriceISK
+	}
+
+	return uint((uint64(price.Price)*uint64(current.PriceISK) + uint64(current.Price)/2) / uint64(current.Price))
+}
+
+// sendPriceDropAlert tells the watcher the product dropped from price to currentPrice, in ISK,
+// or saves it for the digest when the watcher email doesn't want alerts immediately
+func (s *Scraper) sendPriceDropAlert(watchProduct WatchProduct, digest string, product *Product, currentPrice, price Price) error {
+	type email struct {
+		UnsubscribeToken string
+		PriceOld         uint
+		PriceNew         uint
+		PriceDiff        uint
+		ProductURL       string
+		ProductTitle     string
+		Date             time.Time
+	}
+
+	priceOld := priceISKAt(price, currentPrice)
+	priceNew := currentPrice.PriceISK
+	emailTxt := email{
+		PriceOld:     priceOld,
+		PriceNew:     priceNew,
+		PriceDiff:    priceOld - priceNew,
+		ProductURL:   fmt.Sprintf("https://verdfra.is/product/%v", product.Slug),
+		ProductTitle: product.Title,
+		Date:         currentPrice.Date,
+	}
+
+	// The digest gets its unsubscribe tokens when it's sent
+	if digest != DigestImmediate {
+		return s.DB.CreateWatchEvent(&WatchEvent{
+			Email:          watchProduct.Email,
+			WatchProductID: watchProduct.ID,
+			ProductID:      product.ID,
+			Kind:           AlertPriceDrop,
+			ProductTitle:   product.Title,
+			ProductURL:     emailTxt.ProductURL,
+			PriceOld:       priceOld,
+			PriceNew:       priceNew,
+			Locale:         watchProduct.Locale,
+			Date:           currentPrice.Date,
+		})
+	}
+
+	token, err := s.unsubscribeToken(watchProduct.ID)
+	if err != nil {
+		return err
+	}
+	emailTxt.UnsubscribeToken = token
+
+	return s.notifyWatcher(watchProduct, AlertPriceDrop, emailTxt.ProductURL, "watch-product", emailTxt)
+}
+
+// sendBackInStockAlert tells the watcher the product is back in stock,
+// or saves it for the digest when the watcher email doesn't want alerts immediately
+func (s *Scraper) sendBackInStockAlert(watchProduct WatchProduct, digest string, product *Product, change *StockChange) error {
+	type email struct {
+		UnsubscribeToken string
+		Location         string
+		ProductURL       string
+		ProductTitle     string
+		Date             time.Time
+	}
+
+	emailTxt := email{
+		Location:     watchProduct.Location,
+		ProductURL:   fmt.Sprintf("https://verdfra.is/product/%v", product.Slug),
+		ProductTitle: product.Title,
+		Date:         change.CreatedAt,
+	}
+
+	// The digest gets its unsubscribe tokens when it's sent
+	if digest != DigestImmediate {
+		return s.DB.CreateWatchEvent(&WatchEvent{
+			Email:          watchProduct.Email,
+			WatchProductID: watchProduct.ID,
+			ProductID:      product.ID,
+			Kind:           AlertBackInStock,
+			ProductTitle:   product.Title,
+			ProductURL:     emailTxt.ProductURL,
+			Location:       watchProduct.Location,
+			Locale:         watchProduct.Locale,
+			Date:           change.CreatedAt,
+		})
+	}
+
+	token, err := s.unsubscribeToken(watchProduct.ID)
+	if err != nil {
+		return err
+	}
+	emailTxt.UnsubscribeToken = token
+
+	return s.notifyWatcher(watchProduct, AlertBackInStock, emailTxt.ProductURL, "watch-stock", emailTxt)
+}
+
+// notifyWatcher renders the email template name, ex. watch-product, with data in the watch locale and adds it to the outbox as kind,
+// StartNotificationSender delivers it to the watcher
+func (s *Scraper) notifyWatcher(watchProduct WatchProduct, kind, URL, name string, data interface{}) error {
+	email, err := templates.Render(watchProduct.Locale, name, data)
+	if err != nil {
+		return err
+	}
+
+	return s.queueNotifications(kind, notify.Message{
+		To:      watchProduct.Email,
+		Subject: email.Subject,
+		HTML:    email.HTML,
+		Text:    email.Text,
+		URL:     URL,
+	})
+}
@@ -0,0 +1,341 @@
+package scraper
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+	"bitbucket.org/hilmarp/price-scraper/notify"
+	"gorm.io/gorm"
+)
+
+func TestWatchProductSetAlerts(t *testing.T) {
+	tests := []struct {
+		alerts      []string
+		location    string
+		priceDrop   bool
+		backInStock bool
+		err         bool
+	}{
+		{nil, "", true, false, false},
+		{[]string{AlertBackInStock}, "", false, true, false},
+		{[]string{AlertPriceDrop, AlertInStockAt}, "Lindir", true, true, false},
+		{[]string{AlertInStockAt}, "", false, false, true},
+		{[]string{AlertBackInStock}, "Lindir", false, false, true},
+		{[]string{"sale"}, "", false, false, true},
+	}
+
+	for _, test := range tests {
+		var w WatchProduct
+		err := w.SetAlerts(test.alerts, test.location)
+		if (err != nil) != test.err {
+			t.Errorf("%v %q: got error %v", test.alerts, test.location, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if w.PriceDrop != test.priceDrop || w.BackInStock != test.backInStock || w.Location != test.location {
+			t.Errorf("%v %q: got %+v", test.alerts, test.location, w)
+		}
+	}
+}
+
+func TestWatchProductSetThresholds(t *testing.T) {
+	priceDrop := WatchProduct{PriceDrop: true}
+	stock := WatchProduct{BackInStock: true}
+
+	tests := []struct {
+		watch          WatchProduct
+		targetPrice    uint
+		minDrop        uint
+		minDropPercent uint
+		err            bool
+	}{
+		{priceDrop, 5000, 500, 10, false},
+		{priceDrop, 0, 0, 100, false},
+		{priceDrop, 0, 0, 101, true},
+		{stock, 0, 0, 0, false},
+		{stock, 5000, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		err := test.watch.SetThresholds(test.targetPrice, test.minDrop, test.minDropPercent)
+		if (err != nil) != test.err {
+			t.Errorf("%+v: got error %v", test, err)
+		}
+	}
+}
+
+func TestPriceDrop(t *testing.T) {
+	created := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	price := func(id, value uint, days int) Price {
+		return Price{Model: gorm.Model{ID: id, CreatedAt: created.AddDate(0, 0, days)}, Price: value, PriceISK: value, Currency: BaseCurrency}
+	}
+	// Euro cents at 150 ISK a euro
+	eur := func(id, cents uint, days int) Price {
+		return Price{Model: gorm.Model{ID: id, CreatedAt: created.AddDate(0, 0, days)}, Price: cents, PriceISK: cents * 3 / 2, Currency: "EUR"}
+	}
+	sent := uint(2)
+	sentAt := created.AddDate(0, 0, 2).Add(time.Hour)
+
+	tests := []struct {
+		name    string
+		watch   WatchProduct
+		prices  []Price
+		dropped bool
+		from    uint
+	}{
+		{"dropped", WatchProduct{}, []Price{price(3, 800, 2), price(2, 1000, 1)}, true, 2},
+		{"already sent", WatchProduct{PriceIDSent: &sent}, []Price{price(3, 800, 2), price(2, 1000, 1)}, false, 0},
+		{"highest since watcher", WatchProduct{}, []Price{price(4, 800, 3), price(3, 900, 2), price(2, 1000, 1)}, true, 2},
+		{"since last alert", WatchProduct{Sent: &sentAt}, []Price{price(4, 850, 3), price(3, 900, 2), price(2, 1000, 1)}, true, 3},
+		{"nothing since last alert", WatchProduct{Sent: &sentAt}, []Price{price(3, 900, 2), price(2, 1000, 1)}, false, 0},
+		{"target price", WatchProduct{TargetPrice: 800}, []Price{price(3, 800, 2), price(2, 1000, 1)}, true, 2},
+		{"above target price", WatchProduct{TargetPrice: 799}, []Price{price(3, 800, 2), price(2, 1000, 1)}, false, 0},
+		{"min drop", WatchProduct{MinDrop: 200}, []Price{price(3, 800, 2), price(2, 1000, 1)}, true, 2},
+		{"below min drop", WatchProduct{MinDrop: 201}, []Price{price(3, 800, 2), price(2, 1000, 1)}, false, 0},
+		{"min drop percent", WatchProduct{MinDropPercent: 20}, []Price{price(3, 800, 2), price(2, 1000, 1)}, true, 2},
+		{"below min drop percent", WatchProduct{MinDropPercent: 21}, []Price{price(3, 800, 2), price(2, 1000, 1)}, false, 0},
+		{"gradual drop percent", WatchProduct{MinDropPercent: 10}, []Price{price(4, 900, 3), price(3, 950, 2), price(2, 1000, 1)}, true, 2},
+		{"target price in ISK", WatchProduct{TargetPrice: 1200}, []Price{eur(3, 800, 2), eur(2, 1000, 1)}, true, 2},
+		{"above target price in ISK", WatchProduct{TargetPrice: 800}, []Price{eur(3, 800, 2), eur(2, 1000, 1)}, false, 0},
+		{"min drop in ISK", WatchProduct{MinDrop: 300}, []Price{eur(3, 800, 2), eur(2, 1000, 1)}, true, 2},
+		{"below min drop in ISK", WatchProduct{MinDrop: 301}, []Price{eur(3, 800, 2), eur(2, 1000, 1)}, false, 0},
+		{"raised", WatchProduct{}, []Price{price(3, 1200, 2), price(2, 1000, 1)}, false, 0},
+		{"before watcher", WatchProduct{}, []Price{price(3, 800, 2), price(2, 1000, -1)}, false, 0},
+		{"no prices", WatchProduct{}, nil, false, 0},
+	}
+
+	for _, test := range tests {
+		test.watch.CreatedAt = created
+		_, from, dropped := priceDrop(test.watch, test.prices)
+		if dropped != test.dropped || from.ID != test.from {
+			t.Errorf("%s: got %t from %d, want %t from %d", test.name, dropped, from.ID, test.dropped, test.from)
+		}
+	}
+}
+
+func TestBackInStock(t *testing.T) {
+	created := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	change := func(id uint, location string, inStock bool, days int) StockChange {
+		return StockChange{Model: gorm.Model{ID: id, CreatedAt: created.AddDate(0, 0, days)}, Location: location, InStock: inStock}
+	}
+	sent := uint(3)
+
+	tests := []struct {
+		name    string
+		watch   WatchProduct
+		changes []StockChange
+		want    uint // change ID, 0 for none
+	}{
+		{
+			"back in stock",
+			WatchProduct{},
+			[]StockChange{change(1, "Lindir", false, -1), change(2, "Lindir", true, 1)},
+			2,
+		},
+		{
+			"in stock before watcher",
+			WatchProduct{},
+			[]StockChange{change(1, "Lindir", false, -2), change(2, "Lindir", true, -1)},
+			0,
+		},
+		{
+			"another location already in stock",
+			WatchProduct{},
+			[]StockChange{change(1, "Lindir", true, -1), change(2, "Smáralind", false, -1), change(3, "Smáralind", true, 1)},
+			0,
+		},
+		{
+			"at watched location",
+			WatchProduct{Location: "Smáralind"},
+			[]StockChange{change(1, "Lindir", true, -1), change(2, "Smáralind", false, -1), change(3, "Smáralind", true, 1)},
+			3,
+		},
+		{
+			"out of stock again",
+			WatchProduct{},
+			[]StockChange{change(1, "Lindir", false, -1), change(2, "Lindir", true, 1), change(3, "Lindir", false, 2)},
+			0,
+		},
+		{
+			"already sent",
+			WatchProduct{StockChangeIDSent: &sent},
+			[]StockChange{change(1, "Lindir", false, -1), change(2, "Smáralind", false, -1), change(3, "Lindir", true, 1), change(4, "Smáralind", true, 2)},
+			0,
+		},
+		{
+			"back in stock again after sent",
+			WatchProduct{StockChangeIDSent: &sent},
+			[]StockChange{change(1, "Lindir", false, -1), change(3, "Lindir", true, 1), change(4, "Lindir", false, 2), change(5, "Lindir", true, 3)},
+			5,
+		},
+	}
+
+	for _, test := range tests {
+		test.watch.CreatedAt = created
+		got := backInStock(test.watch, test.changes)
+		var id uint
+		if got != nil {
+			id = got.ID
+		}
+		if id != test.want {
+			t.Errorf("%s: got change %d, want %d", test.name, id, test.want)
+		}
+	}
+}
+
+func TestWatchProductVerify(t *testing.T) {
+	now := time.Now()
+
+	w := WatchProduct{}
+	w.CreatedAt = now.Add(-time.Hour)
+	token, err := w.SetVerifyHash(now.Add(-3 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.VerifyHash == token || len(w.VerifyHash) != 64 {
+		t.Errorf("Got verify hash %q for token %q, want the SHA-256", w.VerifyHash, token)
+	}
+
+	// From when the link was sent, not created
+	if err := w.Verify(now, 2*time.Hour); err == nil || w.Verified {
+		t.Error("Got a verified watch with an expired link")
+	}
+	if err := w.Verify(now, 4*time.Hour); err != nil || !w.Verified {
+		t.Errorf("Got %v, want a verified watch", err)
+	}
+
+	// Verified watches stay verified
+	if err := w.Verify(now.Add(24*time.Hour), time.Hour); err != nil {
+		t.Errorf("Got %v verifying again", err)
+	}
+}
+
+func TestCleanUnverifiedWatches(t *testing.T) {
+	db := newTestSQL(t)
+	s := &Scraper{DB: db, UnverifiedTTL: 24 * time.Hour}
+
+	now := time.Now()
+	old := now.Add(-48 * time.Hour)
+	for i, w := range []WatchProduct{
+		{Email: "a@b.is", VerifySentAt: &old},
+		{Email: "c@d.is", VerifySentAt: &old, Verified: true},
+		{Email: "e@f.is", VerifySentAt: &now},
+	} {
+		w := w
+		w.VerifyHash = string(rune('a' + i))
+		err := db.CreateWatchProduct(&w)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := s.CleanUnverifiedWatches(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for email, want := range map[string]int{"a@b.is": 0, "c@d.is": 1, "e@f.is": 1} {
+		watches, err := db.GetWatchProductsByEmail(email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(*watches) != want {
+			t.Errorf("Got %d watches of %s, want %d", len(*watches), email, want)
+		}
+	}
+}
+
+func TestPriceISKAt(t *testing.T) {
+	tests := []struct {
+		price, current Price
+		want           uint
+	}{
+		// ISK prices are as they are
+		{Price{Price: 5000, PriceISK: 5000}, Price{Price: 4000, PriceISK: 4000}, 5000},
+		// The rate went up from 150 to 160 as the price dropped from 100 to 90 EUR
+		{Price{Price: 100, PriceISK: 15000}, Price{Price: 90, PriceISK: 14400}, 16000},
+		{Price{Price: 100, PriceISK: 15000}, Price{}, 15000},
+	}
+
+	for _, test := range tests {
+		if got := priceISKAt(test.price, test.current); got != test.want {
+			t.Errorf("Got %d for %+v at the rate of %+v, want %d", got, test.price, test.current, test.want)
+		}
+	}
+}
+
+func TestSendPriceDropAlertISK(t *testing.T) {
+	db := newTestSQL(t)
+	s := &Scraper{DB: db}
+
+	watch := WatchProduct{Email: "a@b.is", ProductID: 1}
+	product := &Product{Title: "Sjónvarp", Slug: "amazon-1", Currency: "EUR"}
+	product.ID = 1
+	now := time.Now()
+	err := s.sendPriceDropAlert(watch, DigestDaily, product, Price{Price: 90, PriceISK: 13500, Currency: "EUR", Date: now}, Price{Price: 100, PriceISK: 15000, Currency: "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := db.GetWatchEventsByEmail("a@b.is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*events) != 1 || (*events)[0].PriceOld != 15000 || (*events)[0].PriceNew != 13500 {
+		t.Errorf("Got events %+v, want a drop from 15000 to 13500 ISK", *events)
+	}
+}
+
+func TestSendBackInStockAlertUnsubscribe(t *testing.T) {
+	db := newTestSQL(t)
+	s := &Scraper{DB: db, Channels: []notify.Channel{{Name: "email", Notifier: &notify.Log{}}}}
+
+	watch := WatchProduct{Email: "a@b.is", ProductID: 1, BackInStock: true}
+	err := db.CreateWatchProduct(&watch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	product := &Product{Title: "Ofn", Slug: "elko-ofn"}
+	product.ID = 1
+
+	// Every alert has its own link, so the one in the first keeps working
+	for i := 0; i < 2; i++ {
+		err = s.sendBackInStockAlert(watch, DigestImmediate, product, &StockChange{Location: "Lindir", InStock: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	notifications, err := db.GetDueNotifications(10, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*notifications) != 2 {
+		t.Fatalf("Got %d notifications, want 2", len(*notifications))
+	}
+	link := regexp.MustCompile(`/watch/unsubscribe/([\w-]+)`).FindStringSubmatch((*notifications)[0].Text)
+	if link == nil {
+		t.Fatalf("Got no unsubscribe link in %q", (*notifications)[0].Text)
+	}
+
+	var count int64
+	err = db.Model(&UnsubscribeToken{}).Where("hash = ?", link[1]).Count(&count).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Error("Got the plain unsubscribe token stored")
+	}
+
+	found, err := db.GetWatchProductByUnsubscribeHash(formatters.HashToken(link[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != watch.ID {
+		t.Errorf("Got watch %d for the unsubscribe link, want %d", found.ID, watch.ID)
+	}
+}
@@ -0,0 +1,233 @@
+package scraper
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/metrics"
+	"gorm.io/gorm"
+)
+
+// ProductWriter buffers scraped products and writes them in batches, one MySQL transaction
+// and one Elasticsearch bulk request per batch. Write blocks when the buffer is full,
+// which slows down the collectors until the writer catches up
+type ProductWriter struct {
+	DB            Repository
+	ES            SearchRepository
+	BatchSize     int           // Flush when this many products are buffered
+	FlushInterval time.Duration // Flush at least this often when something is buffered
+	RetryDelay    time.Duration // Wait before trying a failed batch again, a second by default
+	products      chan *Product
+	stop          chan struct{}
+	stopped       sync.WaitGroup
+}
+
+// Start starts writing buffered products in the background
+func (w *ProductWriter) Start() {
+	if w.BatchSize <= 0 {
+		w.BatchSize = 50
+	}
+	if w.FlushInterval <= 0 {
+		w.FlushInterval = 2 * time.Second
+	}
+	if w.RetryDelay <= 0 {
+		w.RetryDelay = time.Second
+	}
+
+	w.products = make(chan *Product, w.BatchSize*2)
+	w.stop = make(chan struct{})
+
+	w.stopped.Add(1)
+	go w.run()
+}
+
+// Stop writes what's left in the buffer and waits for it to finish
+func (w *ProductWriter) Stop() {
+	close(w.stop)
+	w.stopped.Wait()
+}
+
+// Write adds product to the buffer, blocks while the buffer is full
+func (w *ProductWriter) Write(product *Product) {
+	metrics.WriterBuffered.Inc()
+	w.products <- product
+}
+
+func (w *ProductWriter) run() {
+	defer w.stopped.Done()
+
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Product, 0, w.BatchSize)
+	for {
+		select {
+		case product := <-w.products:
+			metrics.WriterBuffered.Dec()
+			batch = append(batch, product)
+			if len(batch) >= w.BatchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch = batch[:0]
+		case <-w.stop:
+			// Drain what's already buffered
+			for {
+				select {
+				case product := <-w.products:
+					metrics.WriterBuffered.Dec()
+					batch = append(batch, product)
+				default:
+					w.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flushAttempts is how many times a batch is tried before its products are written one at a time
+const flushAttempts int = 3
+
+// flush writes batch to MySQL and then Elasticsearch. A batch that fails is tried again,
+// and then product by product so one bad product doesn't lose the others, errors are logged
+func (w *ProductWriter) flush(batch []*Product) {
+	if len(batch) == 0 {
+		return
+	}
+
+	start := time.Now()
+	defer func() {
+		metrics.WriterFlushSeconds.Observe(time.Since(start).Seconds())
+	}()
+	metrics.WriterBatchSize.Observe(float64(len(batch)))
+
+	searchProducts, err := w.store(batch)
+	if err != nil {
+		log.Print(err)
+
+		// The transaction was rolled back, so nothing in the batch was stored
+		searchProducts = nil
+		for _, product := range batch {
+			stored, err := storeProducts(w.DB, []*Product{product})
+			if err != nil {
+				log.Print(err)
+				metrics.WriterDropped.Inc()
+				continue
+			}
+			searchProducts = append(searchProducts, stored...)
+		}
+	}
+
+	if len(searchProducts) == 0 {
+		return
+	}
+
+	err = w.retry(func() error {
+		return w.ES.BulkIndexSearchProducts(searchProducts)
+	})
+	if err != nil {
+		log.Printf("Error storing %d search products in Elasticsearch: %s\n", len(searchProducts), err)
+		return
+	}
+
+	metrics.ProductStoredESCount.Add(float64(len(searchProducts)))
+}
+
+// store stores batch in MySQL, trying again when the transaction fails
+func (w *ProductWriter) store(batch []*Product) ([]*SearchProduct, error) {
+	var searchProducts []*SearchProduct
+	err := w.retry(func() error {
+		var err error
+		searchProducts, err = storeProducts(w.DB, batch)
+		return err
+	})
+
+	return searchProducts, err
+}
+
+// retry calls fn up to flushAttempts times until it succeeds, with RetryDelay in between
+func (w *ProductWriter) retry(fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= flushAttempts; attempt++ {
+		err = fn()
+		if err == nil {
+			return nil
+		}
+
+		if attempt < flushAttempts {
+			metrics.WriterRetries.Inc()
+			time.Sleep(w.RetryDelay)
+		}
+	}
+
+	return err
+}
+
+// storeProducts stores products in MySQL in one transaction and returns the stored ones as search products,
+// products that fail on their own are logged and left out. Copies of the products are stored, so the IDs
+// of a transaction that is rolled back aren't left on them for the next try
+func storeProducts(db Repository, products []*Product) ([]*SearchProduct, error) {
+	copies := make([]*Product, len(products))
+	for i, product := range products {
+		copies[i] = unsavedCopy(product)
+	}
+
+	storedProducts, errs, err := db.UpdateOrCreateProducts(copies)
+	if err != nil {
+		return nil, fmt.Errorf("error storing %d products in database: %w", len(products), err)
+	}
+
+	searchProducts := make([]*SearchProduct, 0, len(products))
+	for i, product := range copies {
+		if errs[i] != nil {
+			log.Printf("Error storing product %s in database: %s\n", product.URL, errs[i])
+			metrics.WriterDropped.Inc()
+			continue
+		}
+
+		metrics.ProductStoredCount.Inc()
+		searchProducts = append(searchProducts, newSearchProduct(storedProducts[i].ID, product))
+	}
+
+	return searchProducts, nil
+}
+
+// unsavedCopy returns a copy of product and its child rows without IDs, as it was scraped
+func unsavedCopy(product *Product) *Product {
+	c := *product
+	c.Model = gorm.Model{}
+
+	c.Specs = make([]Spec, len(product.Specs))
+	for i, spec := range product.Specs {
+		c.Specs[i] = Spec{Key: spec.Key, Value: spec.Value}
+	}
+
+	c.Stocks = make([]Stock, len(product.Stocks))
+	for i, stock := range product.Stocks {
+		c.Stocks[i] = Stock{Location: stock.Location, InStock: stock.InStock}
+	}
+
+	c.AllImgURLs = make([]Image, len(product.AllImgURLs))
+	for i, image := range product.AllImgURLs {
+		c.AllImgURLs[i] = Image{URL: image.URL, OriginalURL: image.OriginalURL}
+	}
+
+	c.Prices = make([]Price, len(product.Prices))
+	for i, price := range product.Prices {
+		price.Model = gorm.Model{}
+		price.ProductID = 0
+		c.Prices[i] = price
+	}
+
+	c.Categories = make([]Category, len(product.Categories))
+	for i, category := range product.Categories {
+		c.Categories[i] = Category{Name: category.Name, Slug: category.Slug, Parent: category.Parent}
+	}
+
+	return &c
+}
@@ -0,0 +1,232 @@
+package scraper
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// testSearch is a SearchRepository that keeps bulk indexed products
+type testSearch struct {
+	mu       sync.Mutex
+	products []*SearchProduct
+}
+
+func (s *testSearch) SearchForProduct(value string, filter SearchFilter, limit, offset int) (*[]SearchProduct, error) {
+	return &[]SearchProduct{}, nil
+}
+
+func (s *testSearch) UpdateOrIndexSearchProduct(product *SearchProduct) error {
+	return s.BulkIndexSearchProducts([]*SearchProduct{product})
+}
+
+func (s *testSearch) BulkIndexSearchProducts(products []*SearchProduct) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.products = append(s.products, products...)
+	return nil
+}
+
+func (s *testSearch) DeleteSearchProductByID(id uint) error {
+	return nil
+}
+
+func (s *testSearch) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.products)
+}
+
+// waitFor waits up to a second for the search repository to have n products
+func (s *testSearch) waitFor(t *testing.T, n int) {
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(5 * time.Millisecond) {
+		if s.count() == n {
+			return
+		}
+	}
+	t.Fatalf("Got %d indexed products, want %d", s.count(), n)
+}
+
+// writerDB is the SQLite repository, blocked while block is open and failing batches of more than one product when failBatches.
+// With rollbackOnce the first batch is stored and rolled back, and interleave is then stored as if by another writer
+type writerDB struct {
+	*SQL
+	block        chan struct{}
+	failBatches  bool
+	rollbackOnce bool
+	interleave   *Product
+}
+
+func (db *writerDB) UpdateOrCreateProducts(products []*Product) ([]*Product, []error, error) {
+	if db.block != nil {
+		<-db.block
+	}
+	if db.failBatches && len(products) > 1 {
+		return nil, nil, errors.New("batch failed")
+	}
+	if db.rollbackOnce {
+		db.rollbackOnce = false
+		err := db.SQL.DB.Transaction(func(tx *gorm.DB) error {
+			if _, _, err := (&SQL{DB: tx}).UpdateOrCreateProducts(products); err != nil {
+				return err
+			}
+			return errors.New("batch rolled back")
+		})
+		if db.interleave != nil {
+			if _, err := db.SQL.UpdateOrCreateProduct(db.interleave); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, err
+	}
+
+	return db.SQL.UpdateOrCreateProducts(products)
+}
+
+func writeTestProducts(w *ProductWriter, n int) {
+	for i := 0; i < n; i++ {
+		w.Write(testProduct("elko.is", fmt.Sprintf("vara-%d", i), 1000))
+	}
+}
+
+func TestProductWriterBatchSize(t *testing.T) {
+	es := &testSearch{}
+	w := &ProductWriter{DB: newTestSQL(t), ES: es, BatchSize: 2, FlushInterval: time.Hour}
+	w.Start()
+	defer w.Stop()
+
+	writeTestProducts(w, 2)
+	es.waitFor(t, 2)
+}
+
+func TestProductWriterFlushInterval(t *testing.T) {
+	es := &testSearch{}
+	w := &ProductWriter{DB: newTestSQL(t), ES: es, BatchSize: 10, FlushInterval: 10 * time.Millisecond}
+	w.Start()
+	defer w.Stop()
+
+	writeTestProducts(w, 1)
+	es.waitFor(t, 1)
+}
+
+func TestProductWriterStop(t *testing.T) {
+	db := newTestSQL(t)
+	es := &testSearch{}
+	w := &ProductWriter{DB: db, ES: es, BatchSize: 10, FlushInterval: time.Hour}
+	w.Start()
+
+	writeTestProducts(w, 3)
+	w.Stop()
+
+	if es.count() != 3 {
+		t.Errorf("Got %d indexed products after Stop, want 3", es.count())
+	}
+	for i := 0; i < 3; i++ {
+		_, err := db.GetProductByURL(fmt.Sprintf("https://elko.is/vara-%d", i))
+		if err != nil {
+			t.Errorf("Product %d not stored: %s", i, err)
+		}
+	}
+}
+
+func TestProductWriterBackpressure(t *testing.T) {
+	db := &writerDB{SQL: newTestSQL(t), block: make(chan struct{})}
+	es := &testSearch{}
+	w := &ProductWriter{DB: db, ES: es, BatchSize: 1, FlushInterval: time.Hour}
+	w.Start()
+
+	// The first product is being flushed and the next two fill the buffer
+	writeTestProducts(w, 3)
+
+	written := make(chan struct{})
+	go func() {
+		w.Write(testProduct("elko.is", "vara-3", 1000))
+		close(written)
+	}()
+
+	select {
+	case <-written:
+		t.Fatal("Write didn't block with a full buffer")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(db.block)
+	<-written
+	w.Stop()
+
+	if es.count() != 4 {
+		t.Errorf("Got %d indexed products, want 4", es.count())
+	}
+}
+
+func TestProductWriterFailedBatch(t *testing.T) {
+	db := &writerDB{SQL: newTestSQL(t), failBatches: true}
+	es := &testSearch{}
+	w := &ProductWriter{DB: db, ES: es, BatchSize: 3, FlushInterval: time.Hour, RetryDelay: time.Millisecond}
+	w.Start()
+
+	// The batch fails every time, so the products are stored one by one
+	writeTestProducts(w, 3)
+	w.Stop()
+
+	if es.count() != 3 {
+		t.Errorf("Got %d indexed products, want 3", es.count())
+	}
+	for i := 0; i < 3; i++ {
+		_, err := db.GetProductByURL(fmt.Sprintf("https://elko.is/vara-%d", i))
+		if err != nil {
+			t.Errorf("Product %d not stored: %s", i, err)
+		}
+	}
+}
+
+func TestProductWriterRolledBackBatch(t *testing.T) {
+	db := &writerDB{SQL: newTestSQL(t), rollbackOnce: true, interleave: testProduct("tl.is", "vara", 1000)}
+	es := &testSearch{}
+	w := &ProductWriter{DB: db, ES: es, BatchSize: 2, FlushInterval: time.Hour, RetryDelay: time.Millisecond}
+	w.Start()
+
+	// The IDs from the rolled back batch are taken by the interleaved product before the retry
+	products := []*Product{testProduct("elko.is", "vara-0", 1000), testProduct("elko.is", "vara-1", 1000)}
+	for _, product := range products {
+		w.Write(product)
+	}
+	w.Stop()
+
+	if es.count() != 2 {
+		t.Fatalf("Got %d indexed products, want 2", es.count())
+	}
+	ids := map[uint]bool{}
+	for _, url := range []string{"https://tl.is/vara", "https://elko.is/vara-0", "https://elko.is/vara-1"} {
+		product, err := db.GetProductByURL(url)
+		if err != nil {
+			t.Fatalf("Product %s not stored: %s", url, err)
+		}
+		if ids[product.ID] {
+			t.Errorf("Product %s stored with the ID %d of another product", url, product.ID)
+		}
+		ids[product.ID] = true
+
+		children := []struct {
+			model interface{}
+			want  int64
+		}{{&Spec{}, 2}, {&Stock{}, 1}, {&Category{}, 1}, {&Price{}, 1}}
+		for _, child := range children {
+			var count int64
+			db.DB.Model(child.model).Where("product_id = ?", product.ID).Count(&count)
+			if count != child.want {
+				t.Errorf("Got %d %T rows for %s, want %d", count, child.model, url, child.want)
+			}
+		}
+	}
+
+	var specs int64
+	db.DB.Model(&Spec{}).Count(&specs)
+	if specs != 6 {
+		t.Errorf("Got %d specs, want 6", specs)
+	}
+}
@@ -0,0 +1,78 @@
+package templates
+
+import (
+	"fmt"
+	"time"
+)
+
+// sampleDate is the date in sample data
+var sampleDate = time.Date(2021, 11, 26, 14, 30, 0, 0, time.UTC)
+
+// samples is sample data for each email template, with the fields the senders give it
+var samples = map[string]interface{}{
+	"contact": map[string]interface{}{
+		"From":    "jon@example.com",
+		"Message": "Halló, verðið á Sjónvarpi hjá Elko er rangt.\nKveðja, Jón",
+	},
+	"user-login": map[string]interface{}{
+		"LoginHash": "sample-login-token",
+	},
+	"watch-verify": map[string]interface{}{
+		"VerifyHash":   "sample-verify-token",
+		"ProductTitle": "Samsung 55\" QLED sjónvarp",
+	},
+	"watch-product": map[string]interface{}{
+		"UnsubscribeToken": "sample-unsubscribe",
+		"PriceOld":         uint(129990),
+		"PriceNew":         uint(99990),
+		"PriceDiff":        uint(30000),
+		"ProductURL":       "https://verdfra.is/product/samsung-55-qled",
+		"ProductTitle":     "Samsung 55\" QLED sjónvarp",
+		"Date":             sampleDate,
+	},
+	"watch-stock": map[string]interface{}{
+		"UnsubscribeToken": "sample-unsubscribe",
+		"Location":         "Lindir",
+		"ProductURL":       "https://verdfra.is/product/samsung-55-qled",
+		"ProductTitle":     "Samsung 55\" QLED sjónvarp",
+		"Date":             sampleDate,
+	},
+	"watch-digest": map[string]interface{}{
+		"Digest": "daily",
+		"Events": []map[string]interface{}{
+			{
+				"Kind":             "price_drop",
+				"ProductTitle":     "Samsung 55\" QLED sjónvarp",
+				"ProductURL":       "https://verdfra.is/product/samsung-55-qled",
+				"PriceOld":         uint(129990),
+				"PriceNew":         uint(99990),
+				"PriceDiff":        uint(30000),
+				"Location":         "",
+				"UnsubscribeToken": "sample-unsubscribe-1",
+				"Date":             sampleDate,
+			},
+			{
+				"Kind":             "back_in_stock",
+				"ProductTitle":     "Dyson V15 ryksuga",
+				"ProductURL":       "https://verdfra.is/product/dyson-v15",
+				"PriceOld":         uint(0),
+				"PriceNew":         uint(0),
+				"PriceDiff":        uint(0),
+				"Location":         "Skeifan",
+				"UnsubscribeToken": "sample-unsubscribe-2",
+				"Date":             sampleDate.Add(-3 * time.Hour),
+			},
+		},
+	},
+}
+
+// Preview renders the email template name in locale with sample data,
+// so changes to templates can be reviewed without sending email
+func Preview(locale, name string) (Email, error) {
+	data, ok := samples[name]
+	if !ok {
+		return Email{}, fmt.Errorf("no email template %q", name)
+	}
+
+	return Render(locale, name, data)
+}
@@ -0,0 +1,171 @@
+// Package templates renders the emails, the templates are embedded in the binary and parsed once
+package templates
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+)
+
+// Supported locales
+const (
+	LocaleIcelandic = "is"
+	LocaleEnglish   = "en"
+)
+
+// DefaultLocale is used when a locale is empty or not supported
+const DefaultLocale = LocaleIcelandic
+
+// Locales is every supported locale, the default first
+var Locales = []string{LocaleIcelandic, LocaleEnglish}
+
+//go:embed is/* en/*
+var files embed.FS
+
+// funcs are the template functions of each locale, isk formats a price and date a time
+var funcs = map[string]map[string]interface{}{
+	LocaleIcelandic: {
+		"isk": func(price uint) string {
+			return formatters.GroupThousands(price, ".") + " kr."
+		},
+		"date": func(date time.Time) string {
+			return date.Format("02.01.2006 kl. 15:04")
+		},
+	},
+	LocaleEnglish: {
+		"isk": func(price uint) string {
+			return "ISK " + formatters.GroupThousands(price, ",")
+		},
+		"date": func(date time.Time) string {
+			return date.Format("2 Jan 2006 15:04")
+		},
+	},
+}
+
+// email is an email template, name.txt is the plain text version and defines the "subject" template,
+// name.html is the HTML version
+type email struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// parsed has the templates by locale and name
+var parsed = mustParse()
+
+func mustParse() map[string]map[string]email {
+	parsed := map[string]map[string]email{}
+	for _, locale := range Locales {
+		textFiles, err := fs.Glob(files, locale+"/*.txt")
+		if err != nil {
+			panic(err)
+		}
+
+		parsed[locale] = map[string]email{}
+		for _, textFile := range textFiles {
+			base := path.Base(textFile)
+			name := strings.TrimSuffix(base, ".txt")
+			parsed[locale][name] = email{
+				html: htmltemplate.Must(htmltemplate.New(name+".html").Funcs(funcs[locale]).ParseFS(files, locale+"/"+name+".html")),
+				text: texttemplate.Must(texttemplate.New(base).Funcs(funcs[locale]).ParseFS(files, textFile)),
+			}
+		}
+	}
+
+	return parsed
+}
+
+// Email is a rendered email
+type Email struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// Render renders the email template name, ex. watch-product, with data in locale,
+// the default locale is used when locale has no such template
+func Render(locale, name string, data interface{}) (Email, error) {
+	tmpl, ok := parsed[locale][name]
+	if !ok {
+		tmpl, ok = parsed[DefaultLocale][name]
+	}
+	if !ok {
+		return Email{}, fmt.Errorf("no email template %q", name)
+	}
+
+	var subject bytes.Buffer
+	err := tmpl.text.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return Email{}, err
+	}
+
+	var text bytes.Buffer
+	err = tmpl.text.Execute(&text, data)
+	if err != nil {
+		return Email{}, err
+	}
+
+	var html bytes.Buffer
+	err = tmpl.html.Execute(&html, data)
+	if err != nil {
+		return Email{}, err
+	}
+
+	return Email{
+		Subject: strings.TrimSpace(subject.String()),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
+
+// Names returns the names of the email templates, sorted
+func Names() []string {
+	var names []string
+	for name := range parsed[DefaultLocale] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Locale returns the supported locale the Accept-Language header value prefers,
+// ex. en for "en-US,en;q=0.9,is;q=0.8", the default locale when it prefers none of them
+func Locale(acceptLanguage string) string {
+	best := DefaultLocale
+	bestQ := 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.Index(tag, "-"); i != -1 {
+			tag = tag[:i]
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				parsedQ, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					q = parsedQ
+				}
+			}
+		}
+
+		if _, ok := parsed[tag]; ok && q > bestQ {
+			best = tag
+			bestQ = q
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,119 @@
+package templates
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplates(t *testing.T) {
+	for _, locale := range Locales {
+		if len(parsed[locale]) != len(parsed[DefaultLocale]) {
+			t.Errorf("%s has %d templates, want %d", locale, len(parsed[locale]), len(parsed[DefaultLocale]))
+		}
+
+		for name := range parsed[DefaultLocale] {
+			if _, ok := parsed[locale][name]; !ok {
+				t.Errorf("%s has no %s", locale, name)
+			}
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	data := struct {
+		ProductTitle     string
+		UnsubscribeToken string
+		ProductURL       string
+		PriceOld         uint
+		PriceNew         uint
+		PriceDiff        uint
+		Date             time.Time
+	}{
+		ProductTitle: "Sjónvarp & skápur",
+		PriceOld:     129990,
+		PriceNew:     99990,
+		PriceDiff:    30000,
+		Date:         time.Date(2021, 3, 4, 8, 5, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		locale  string
+		subject string
+		html    []string
+		text    string
+	}{
+		{"is", "Verðlækkun - Sjónvarp & skápur", []string{"129.990 kr.", "99.990 kr.", "30.000 kr.", "04.03.2021 kl. 08:05", "Afskrá", "Sjónvarp &amp; skápur"}, "Sjónvarp & skápur hefur lækkað úr 129.990 kr. í 99.990 kr.\nLækkun um 30.000 kr."},
+		{"en", "Price drop - Sjónvarp & skápur", []string{"ISK 129,990", "ISK 99,990", "ISK 30,000", "4 Mar 2021 08:05", "Unsubscribe"}, "Sjónvarp & skápur has dropped from ISK 129,990 to ISK 99,990.\nDown by ISK 30,000."},
+		{"de", "Verðlækkun - Sjónvarp & skápur", []string{"129.990 kr."}, "Lækkun um 30.000 kr."},
+	}
+
+	for _, test := range tests {
+		email, err := Render(test.locale, "watch-product", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if email.Subject != test.subject {
+			t.Errorf("%s subject = %q, want %q", test.locale, email.Subject, test.subject)
+		}
+		for _, want := range test.html {
+			if !strings.Contains(email.HTML, want) {
+				t.Errorf("%s HTML has no %q", test.locale, want)
+			}
+		}
+		if !strings.Contains(email.Text, test.text) {
+			t.Errorf("%s text = %q, want %q in it", test.locale, email.Text, test.text)
+		}
+	}
+
+	_, err := Render("is", "nope", data)
+	if err == nil {
+		t.Error("Render of a missing template, want error")
+	}
+}
+
+func TestPreview(t *testing.T) {
+	for _, locale := range Locales {
+		for _, name := range Names() {
+			email, err := Preview(locale, name)
+			if err != nil {
+				t.Errorf("Preview(%q, %q) error = %v", locale, name, err)
+				continue
+			}
+			if email.Subject == "" || email.HTML == "" || email.Text == "" {
+				t.Errorf("Preview(%q, %q) = %+v, want a subject, HTML and text", locale, name, email)
+			}
+			if strings.Contains(email.Text, "<") || strings.Contains(email.Text, "&") {
+				t.Errorf("Preview(%q, %q) text has HTML: %q", locale, name, email.Text)
+			}
+		}
+	}
+
+	_, err := Preview("is", "nope")
+	if err == nil {
+		t.Error("Preview of a missing template, want error")
+	}
+}
+
+func TestLocale(t *testing.T) {
+	tests := []struct {
+		acceptLanguage string
+		want           string
+	}{
+		{"", "is"},
+		{"is", "is"},
+		{"en", "en"},
+		{"en-US,en;q=0.9", "en"},
+		{"is-IS,is;q=0.9,en;q=0.8", "is"},
+		{"de-DE,de;q=0.9,en;q=0.8,is;q=0.7", "en"},
+		{"is;q=0.5,EN-gb;q=0.8", "en"},
+		{"de, fr", "is"},
+		{"*", "is"},
+	}
+
+	for _, test := range tests {
+		if got := Locale(test.acceptLanguage); got != test.want {
+			t.Errorf("Locale(%q) = %q, want %q", test.acceptLanguage, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package web
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/scraper"
+	"bitbucket.org/hilmarp/price-scraper/templates"
+	"github.com/go-chi/chi"
+)
+
+// adminMiddleware only lets through requests with the admin token as a bearer token,
+// all admin routes are disabled when no token is configured
+func (s *APIServer) adminMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if s.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *APIServer) adminCurrencyRatesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Currencies.Rates())
+}
+
+func (s *APIServer) adminUpdateCurrencyRatesHandler(w http.ResponseWriter, r *http.Request) {
+	var rates map[string]float64
+	err := json.NewDecoder(r.Body).Decode(&rates)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = s.Currencies.Set(rates)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Currencies.Rates())
+}
+
+func (s *APIServer) adminShippingRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := s.DB.GetShippingRules()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+func (s *APIServer) adminUpdateShippingRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var rule scraper.ShippingRule
+	err := json.NewDecoder(r.Body).Decode(&rule)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	rule.ID = 0
+	rule.Source = chi.URLParam(r, "source")
+
+	err = s.DB.UpdateOrCreateShippingRule(&rule)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+func (s *APIServer) adminNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	limitQ := r.URL.Query().Get("limit")
+	offsetQ := r.URL.Query().Get("offset")
+
+	// Failed deliveries by default, "all" for every status
+	switch status {
+	case "":
+		status = scraper.NotificationFailed
+	case "all":
+		status = ""
+	case scraper.NotificationPending, scraper.NotificationSent, scraper.NotificationFailed:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid status"))
+		return
+	}
+
+	maxLimit := 100
+	limit := maxLimit
+	offset := 0
+
+	if limitQ != "" {
+		num, err := strconv.Atoi(limitQ)
+		if err == nil && num > 0 && num <= maxLimit {
+			limit = num
+		}
+	}
+
+	if offsetQ != "" {
+		num, err := strconv.Atoi(offsetQ)
+		if err == nil && num >= 0 {
+			offset = num
+		}
+	}
+
+	notifications, err := s.DB.GetNotifications(status, limit, offset)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+func (s *APIServer) adminRetryNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = s.DB.RetryNotification(uint(id), time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Write([]byte("Retrying!"))
+}
+
+// adminEmailPreviewHandler renders an email template with sample data, as HTML by default
+// or as the plain text version with ?format=text, in ?locale= or the default locale
+func (s *APIServer) adminEmailPreviewHandler(w http.ResponseWriter, r *http.Request) {
+	locale := r.URL.Query().Get("locale")
+	format := r.URL.Query().Get("format")
+
+	if locale == "" {
+		locale = templates.DefaultLocale
+	}
+
+	supported := false
+	for _, l := range templates.Locales {
+		if l == locale {
+			supported = true
+		}
+	}
+	if !supported {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid locale"))
+		return
+	}
+
+	email, err := templates.Preview(locale, chi.URLParam(r, "template"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	switch format {
+	case "", "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(email.HTML))
+	case "text":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte("Subject: " + email.Subject + "\n\n" + email.Text))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Invalid format"))
+	}
+}
@@ -1,10 +1,8 @@
 package web
 
 import (
-	"bytes"
 	"encoding/json"
 	"fmt"
-	"html/template"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -15,10 +13,10 @@ import (
 
 	"bitbucket.org/hilmarp/price-scraper/formatters"
 	"bitbucket.org/hilmarp/price-scraper/metrics"
+	"bitbucket.org/hilmarp/price-scraper/notify"
 	"bitbucket.org/hilmarp/price-scraper/scraper"
+	"bitbucket.org/hilmarp/price-scraper/templates"
 	"github.com/go-chi/chi"
-	"github.com/sendgrid/sendgrid-go"
-	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
 func (s *APIServer) rootHandler(w http.ResponseWriter, r *http.Request) {
@@ -40,6 +38,13 @@ func (s *APIServer) productHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Error increment product view counter: %s", err.Error())
 	}
 
+	err = s.Currencies.SetDisplayPrice(product, r.URL.Query().Get("currency"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(product)
 }
@@ -52,41 +57,42 @@ func (s *APIServer) productPricesHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	orderByDirQ := r.URL.Query().Get("order_by_dir")
-	orderByDir := "asc"
-	if orderByDirQ != "" && orderByDirQ == "desc" {
-		orderByDir = orderByDirQ
+	desc := r.URL.Query().Get("order_by_dir") == "desc"
+
+	from, err := getFromDate(r.URL.Query().Get("from"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
 	}
-	orderBy := fmt.Sprintf("id %s", orderByDir)
 
-	// From date
-	var from time.Time
-	fromQ := r.URL.Query().Get("from")
-	now := time.Now()
-	if fromQ == "" {
-		// Default to 30 days
-		from = now.Add(time.Duration(-720) * time.Hour)
-	} else {
-		parsed, err := time.Parse("2006-01-02", fromQ)
-		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
-			return
-		}
+	prices, err := s.DB.GetProductPrices(uint(id), from, desc)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
-		// Set max date, 1 year
-		max := now.Add(time.Duration(-8760) * time.Hour)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prices)
+}
 
-		if parsed.Before(max) {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Date set to far back in time"))
-			return
-		}
+func (s *APIServer) productPriceStatsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
-		from = parsed
+	from, err := getFromDate(r.URL.Query().Get("from"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
 	}
 
-	prices, err := s.DB.GetProductPrices(uint(id), from, orderBy)
+	stats, err := s.DB.GetProductPriceStats(uint(id), from)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -94,7 +100,115 @@ func (s *APIServer) productPricesHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(prices)
+	json.NewEncoder(w).Encode(stats)
+}
+
+// setSearchProductsShipping sets shipping cost and total price on search products from rules
+func setSearchProductsShipping(products []scraper.SearchProduct, rules []scraper.ShippingRule) {
+	bySource := make(map[string]scraper.ShippingRule, len(rules))
+	for _, rule := range rules {
+		bySource[rule.Source] = rule
+	}
+
+	for i, p := range products {
+		products[i].ShippingCost = 0
+		if rule, ok := bySource[p.Source]; ok {
+			products[i].ShippingCost = rule.Cost(p.PriceISK)
+		}
+		products[i].TotalPrice = p.PriceISK + products[i].ShippingCost
+	}
+}
+
+// setNextCursor tells the client where the next page starts, in the X-Next-Cursor header
+func setNextCursor(w http.ResponseWriter, cursor *scraper.Cursor) {
+	if cursor != nil {
+		w.Header().Set("X-Next-Cursor", cursor.String())
+	}
+}
+
+// getProductQuery parses the product listing filters and sort from query params, lists are
+// separated by comma, ex. sources=elko.is,ht.is&price_to=10000&order_by=price&order_by_dir=desc
+func getProductQuery(r *http.Request) (scraper.ProductQuery, error) {
+	q := r.URL.Query()
+	query := scraper.ProductQuery{
+		Sources:    getList(q.Get("sources")),
+		Categories: getList(q.Get("categories")),
+		Brands:     getList(q.Get("brands")),
+		Unit:       q.Get("unit"),
+		OnSale:     getBool(q.Get("on_sale")),
+		InStock:    getBool(q.Get("in_stock")),
+		Location:   q.Get("location"),
+		// Newest first by default
+		Sort: scraper.ProductSort{Field: "id", Desc: true},
+	}
+
+	numbers := map[string]*uint{
+		"price_from":      &query.PriceFrom,
+		"price_to":        &query.PriceTo,
+		"unit_price_from": &query.UnitPriceFrom,
+		"unit_price_to":   &query.UnitPriceTo,
+	}
+	for name, number := range numbers {
+		num, err := strconv.Atoi(q.Get(name))
+		if err == nil && num > 0 {
+			*number = uint(num)
+		}
+	}
+
+	if orderBy := q.Get("order_by"); orderBy != "" {
+		query.Sort = scraper.ProductSort{Field: orderBy, Desc: q.Get("order_by_dir") == "desc"}
+	}
+
+	cursor, err := scraper.ParseCursor(q.Get("cursor"))
+	if err != nil {
+		return query, err
+	}
+	query.Cursor = cursor
+
+	return query, nil
+}
+
+// getList splits a comma separated query param, empty is nil
+func getList(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	return strings.Split(value, ",")
+}
+
+// getBool parses a true or false query param, anything else is nil
+func getBool(value string) *bool {
+	if value != "true" && value != "false" {
+		return nil
+	}
+
+	b := value == "true"
+	return &b
+}
+
+// getFromDate parses the from query param, ex. 2021-01-28,
+// defaults to 30 days ago and can't be more than 1 year back
+func getFromDate(fromQ string) (time.Time, error) {
+	now := time.Now()
+	if fromQ == "" {
+		// Default to 30 days
+		return now.Add(time.Duration(-720) * time.Hour), nil
+	}
+
+	parsed, err := time.Parse("2006-01-02", fromQ)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Set max date, 1 year
+	max := now.Add(time.Duration(-8760) * time.Hour)
+
+	if parsed.Before(max) {
+		return time.Time{}, fmt.Errorf("Date set to far back in time")
+	}
+
+	return parsed, nil
 }
 
 func (s *APIServer) productSpecsHandler(w http.ResponseWriter, r *http.Request) {
@@ -135,6 +249,32 @@ func (s *APIServer) productStocksHandler(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(stocks)
 }
 
+func (s *APIServer) productStockHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	from, err := getFromDate(r.URL.Query().Get("from"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	changes, err := s.DB.GetProductStockHistory(uint(id), from)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(changes)
+}
+
 func (s *APIServer) productImagesHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
@@ -174,93 +314,57 @@ func (s *APIServer) productCategoriesHandler(w http.ResponseWriter, r *http.Requ
 }
 
 func (s *APIServer) productsHandler(w http.ResponseWriter, r *http.Request) {
+	currency := r.URL.Query().Get("currency")
 	limitQ := r.URL.Query().Get("limit")
 	offsetQ := r.URL.Query().Get("offset")
-	orderBy := r.URL.Query().Get("order_by")
-	orderByDir := r.URL.Query().Get("order_by_dir")
-	sourcesQ := r.URL.Query().Get("sources")
-	categorySlugsQ := r.URL.Query().Get("categories")
-	priceFromQ := r.URL.Query().Get("price_from")
-	priceToQ := r.URL.Query().Get("price_to")
-	onSaleQ := r.URL.Query().Get("on_sale")
-
-	// Set defaults, then override if needed
-	maxLimit := 100
-	limit := maxLimit
-	offset := 0
-	priceFrom := 0
-	priceTo := 0
-	onSale := ""
 
-	if priceFromQ != "" {
-		num, err := strconv.Atoi(priceFromQ)
-		if err == nil {
-			priceFrom = num
-		}
+	query, err := getProductQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
 	}
 
-	if priceToQ != "" {
-		num, err := strconv.Atoi(priceToQ)
-		if err == nil {
-			priceTo = num
-		}
-	}
+	// Set defaults, then override if needed
+	maxLimit := 100
+	query.Limit = maxLimit
 
 	if limitQ != "" {
 		num, err := strconv.Atoi(limitQ)
 		if err == nil {
-			limit = num
+			query.Limit = num
 		}
 	}
 
 	if offsetQ != "" {
 		num, err := strconv.Atoi(offsetQ)
 		if err == nil {
-			offset = num
-		}
-	}
-
-	if onSaleQ != "" {
-		if onSaleQ == "true" || onSaleQ == "false" {
-			onSale = onSaleQ
+			query.Offset = num
 		}
 	}
 
-	// Final order by combining orderBy and orderByDir
-	// ex. "price desc"
-	order := "id desc"
-	if orderBy != "" && orderBy == "price" {
-		ordDir := "asc"
-		if orderByDir != "" && orderByDir == "desc" {
-			ordDir = orderByDir
-		}
-		order = fmt.Sprintf("%s %s", orderBy, ordDir)
-	}
-
 	// Don't want to crash the server by returning too many products
-	if limit > maxLimit {
-		limit = maxLimit
-	}
-
-	// Sources, separated by comma
-	var sources []string
-	if sourcesQ != "" {
-		sources = strings.Split(sourcesQ, ",")
+	if query.Limit > maxLimit {
+		query.Limit = maxLimit
 	}
 
-	// Category slugs, separated by comma
-	var categorySlugs []string
-	if categorySlugsQ != "" {
-		categorySlugs = strings.Split(categorySlugsQ, ",")
-	}
-
-	products, err := s.DB.GetProducts(limit, offset, priceFrom, priceTo, order, onSale, sources, categorySlugs)
+	products, next, err := s.DB.GetProducts(query)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
+	for i := range *products {
+		err = s.Currencies.SetDisplayPrice(&(*products)[i].Product, currency)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(products)
 }
@@ -326,7 +430,12 @@ func (s *APIServer) searchHandler(w http.ResponseWriter, r *http.Request) {
 		limit = maxLimit
 	}
 
-	esProducts, err := s.ES.SearchForProduct(value, limit, offset)
+	filter := scraper.SearchFilter{
+		InStock:  getBool(r.URL.Query().Get("in_stock")),
+		Location: r.URL.Query().Get("location"),
+	}
+
+	esProducts, err := s.ES.SearchForProduct(value, filter, limit, offset)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -335,72 +444,104 @@ func (s *APIServer) searchHandler(w http.ResponseWriter, r *http.Request) {
 
 	metrics.TotalSearches.Inc()
 
+	// Shipping rules may have changed since the products were indexed
+	rules, err := s.DB.GetShippingRules()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	setSearchProductsShipping(*esProducts, *rules)
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(esProducts)
 }
 
 func (s *APIServer) productsCountHandler(w http.ResponseWriter, r *http.Request) {
-	sourcesQ := r.URL.Query().Get("sources")
-	categorySlugsQ := r.URL.Query().Get("categories")
-	priceFromQ := r.URL.Query().Get("price_from")
-	priceToQ := r.URL.Query().Get("price_to")
-	onSaleQ := r.URL.Query().Get("on_sale")
+	query, err := getProductQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
-	// Set defaults
-	priceFrom := 0
-	priceTo := 0
-	onSale := ""
+	count, err := s.DB.GetProductsCount(query)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 
-	if priceFromQ != "" {
-		num, err := strconv.Atoi(priceFromQ)
-		if err == nil {
-			priceFrom = num
-		}
+	type countJSON struct {
+		Count int
 	}
 
-	if priceToQ != "" {
-		num, err := strconv.Atoi(priceToQ)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(countJSON{Count: count})
+}
+
+func (s *APIServer) productsPopularHandler(w http.ResponseWriter, r *http.Request) {
+	currency := r.URL.Query().Get("currency")
+	limitQ := r.URL.Query().Get("limit")
+	offsetQ := r.URL.Query().Get("offset")
+
+	// Set defaults
+	maxLimit := 100
+	limit := maxLimit
+	offset := 0
+
+	if limitQ != "" {
+		num, err := strconv.Atoi(limitQ)
 		if err == nil {
-			priceTo = num
+			limit = num
 		}
 	}
 
-	if onSaleQ != "" {
-		if onSaleQ == "true" || onSaleQ == "false" {
-			onSale = onSaleQ
+	if offsetQ != "" {
+		num, err := strconv.Atoi(offsetQ)
+		if err == nil {
+			offset = num
 		}
 	}
 
-	// Sources, separated by comma
-	var sources []string
-	if sourcesQ != "" {
-		sources = strings.Split(sourcesQ, ",")
+	cursor, err := scraper.ParseCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
 	}
 
-	// Category slugs, separated by comma
-	var categorySlugs []string
-	if categorySlugsQ != "" {
-		categorySlugs = strings.Split(categorySlugsQ, ",")
+	// Don't go over max limit
+	if limit > maxLimit {
+		limit = maxLimit
 	}
 
-	count, err := s.DB.GetProductsCount(0, 0, priceFrom, priceTo, "", onSale, sources, categorySlugs)
+	products, next, err := s.DB.GetPopularProducts(limit, offset, cursor)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	type countJSON struct {
-		Count int
+	for i := range *products {
+		err = s.Currencies.SetDisplayPrice(&(*products)[i].Product, currency)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
 	}
 
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(countJSON{Count: count})
+	json.NewEncoder(w).Encode(products)
 }
 
-func (s *APIServer) productsPopularHandler(w http.ResponseWriter, r *http.Request) {
+func (s *APIServer) productsPriceChangesHandler(w http.ResponseWriter, r *http.Request) {
+	currency := r.URL.Query().Get("currency")
 	limitQ := r.URL.Query().Get("limit")
 	offsetQ := r.URL.Query().Get("offset")
+	lower := r.URL.Query().Get("lower")
 
 	// Set defaults
 	maxLimit := 100
@@ -421,26 +562,44 @@ func (s *APIServer) productsPopularHandler(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
+	cursor, err := scraper.ParseCursor(r.URL.Query().Get("cursor"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
 	// Don't go over max limit
 	if limit > maxLimit {
 		limit = maxLimit
 	}
 
-	products, err := s.DB.GetPopularProducts(limit, offset)
+	products, next, err := s.DB.GetProductsPriceChanges(limit, offset, cursor, lower)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
+	for i := range *products {
+		err = s.Currencies.SetDisplayPrice(&(*products)[i].Product, currency)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(products)
 }
 
-func (s *APIServer) productsPriceChangesHandler(w http.ResponseWriter, r *http.Request) {
+func (s *APIServer) productsSuspiciousSalesHandler(w http.ResponseWriter, r *http.Request) {
+	currency := r.URL.Query().Get("currency")
 	limitQ := r.URL.Query().Get("limit")
 	offsetQ := r.URL.Query().Get("offset")
-	lower := r.URL.Query().Get("lower")
+	sourcesQ := r.URL.Query().Get("sources")
 
 	// Set defaults
 	maxLimit := 100
@@ -466,13 +625,36 @@ func (s *APIServer) productsPriceChangesHandler(w http.ResponseWriter, r *http.R
 		limit = maxLimit
 	}
 
-	products, err := s.DB.GetProductsPriceChanges(limit, offset, lower)
+	// Sources, separated by comma
+	var sources []string
+	if sourcesQ != "" {
+		sources = strings.Split(sourcesQ, ",")
+	}
+
+	cursor, err := scraper.ParseCursor(r.URL.Query().Get("cursor"))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
+	products, next, err := s.DB.GetSuspiciousSaleProducts(limit, offset, cursor, sources)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	for i := range *products {
+		err = s.Currencies.SetDisplayPrice(&(*products)[i], currency)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	setNextCursor(w, next)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(products)
 }
@@ -521,7 +703,13 @@ func (s *APIServer) watchProductHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	type input struct {
-		Email string
+		Email    string
+		Alerts   []string // Price drops by default
+		Location string   // For the in_stock_at alert
+		// Price drop thresholds in ISK, all optional
+		TargetPrice    uint
+		MinDrop        uint
+		MinDropPercent uint
 	}
 
 	var in input
@@ -533,57 +721,115 @@ func (s *APIServer) watchProductHandler(w http.ResponseWriter, r *http.Request)
 	}
 
 	if in.Email == "" {
+		reject(w, "watch", "no_email", http.StatusBadRequest, "no email")
+		return
+	}
+
+	in.Email = formatters.NormalizeEmail(in.Email)
+	if !formatters.IsValidEmail(in.Email) {
+		reject(w, "watch", "invalid_email", http.StatusBadRequest, "invalid email")
+		return
+	}
+
+	if !s.allow(r, "watch:ip:"+s.clientIP(r), watchIPLimit) {
+		reject(w, "watch", "ip_rate_limit", http.StatusTooManyRequests, "too many requests")
+		return
+	}
+
+	// A second signup for the same product updates the watch and sends the verify email again
+	watchProducts, err := s.DB.GetWatchProductsByEmail(in.Email)
+	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("no email"))
+		w.Write([]byte(err.Error()))
 		return
 	}
 
-	verifyHash := formatters.GetRandomStringWithTimestamp(15)
-	unsubscribeHash := formatters.GetRandomStringWithTimestamp(15)
+	var watchProduct *scraper.WatchProduct
+	for i, existing := range *watchProducts {
+		if existing.ProductID == product.ID {
+			watchProduct = &(*watchProducts)[i]
+		}
+	}
 
-	err = s.DB.CreateWatchProduct(&scraper.WatchProduct{
-		Email:           in.Email,
-		ProductID:       product.ID,
-		Sent:            nil,
-		PriceIDSent:     nil,
-		Verified:        false,
-		VerifyHash:      verifyHash,
-		UnsubscribeHash: unsubscribeHash,
-	})
+	if watchProduct != nil && watchProduct.Verified {
+		reject(w, "watch", "duplicate", http.StatusBadRequest, "already watching this product")
+		return
+	}
+
+	if watchProduct == nil {
+		watchProduct = &scraper.WatchProduct{
+			Email:       in.Email,
+			ProductID:   product.ID,
+			Sent:        nil,
+			PriceIDSent: nil,
+			Verified:    false,
+		}
+	}
+
+	// Emails about the watch are in the language of the browser it was made in
+	watchProduct.Locale = templates.Locale(r.Header.Get("Accept-Language"))
+
+	err = watchProduct.SetAlerts(in.Alerts, in.Location)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	type email struct {
-		VerifyHash   string
-		ProductTitle string
+	err = watchProduct.SetThresholds(in.TargetPrice, in.MinDrop, in.MinDropPercent)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
 	}
 
-	absPath := os.Getenv("PRICE_ABS_PATH")
-	tmpl, err := template.ParseFiles(fmt.Sprintf("%s/templates/watch-verify.html", absPath))
+	if !s.allow(r, fmt.Sprintf("watch:verify:%s:%d", in.Email, product.ID), verifyCooldown) {
+		reject(w, "watch", "verify_cooldown", http.StatusTooManyRequests, "verify email was just sent")
+		return
+	}
+
+	if !s.allow(r, "recipient:"+in.Email, recipientLimit) {
+		reject(w, "watch", "recipient_rate_limit", http.StatusTooManyRequests, "too many emails to this address")
+		return
+	}
+
+	// A new link every time, only the hash of the last one is stored
+	verifyToken, err := watchProduct.SetVerifyHash(time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if watchProduct.ID == 0 {
+		err = s.DB.CreateWatchProduct(watchProduct)
+	} else {
+		err = s.DB.UpdateWatchProduct(watchProduct)
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	var tmplBuffer bytes.Buffer
-	err = tmpl.Execute(&tmplBuffer, email{VerifyHash: verifyHash, ProductTitle: product.Title})
+	type email struct {
+		VerifyHash   string
+		ProductTitle string
+	}
+
+	rendered, err := templates.Render(watchProduct.Locale, "watch-verify", email{VerifyHash: verifyToken, ProductTitle: product.Title})
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	from := mail.NewEmail("Verð frá", os.Getenv("PRICE_EMAIL_FROM"))
-	subject := "Staðfesta netfang"
-	to := mail.NewEmail(in.Email, in.Email)
-	tmplStr := tmplBuffer.String()
-	message := mail.NewSingleEmail(from, subject, to, tmplStr, tmplStr)
-	client := sendgrid.NewSendClient(os.Getenv("PRICE_EMAIL_API_KEY"))
-	_, err = client.Send(message)
+	err = s.Notifier.Notify(r.Context(), notify.Message{
+		To:      in.Email,
+		Subject: rendered.Subject,
+		HTML:    rendered.HTML,
+		Text:    rendered.Text,
+	})
 	if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -604,14 +850,24 @@ func (s *APIServer) watchVerifyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	watchProduct, err := s.DB.GetWatchProductByVerifyHash(hash)
+	watchProduct, err := s.DB.GetWatchProductByVerifyHash(formatters.HashToken(hash))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	watchProduct.Verified = true
+	verifyTTL := s.VerifyTTL
+	if verifyTTL <= 0 {
+		verifyTTL = scraper.DefaultVerifyTTL
+	}
+
+	err = watchProduct.Verify(time.Now(), verifyTTL)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	err = s.DB.UpdateWatchProduct(watchProduct)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -632,7 +888,7 @@ func (s *APIServer) watchUnsubscribeHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err := s.DB.DeleteWatchProductByUnsubscribeHash(hash)
+	err := s.DB.DeleteWatchProductByUnsubscribeHash(formatters.HashToken(hash))
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
@@ -644,6 +900,53 @@ func (s *APIServer) watchUnsubscribeHandler(w http.ResponseWriter, r *http.Reque
 	w.Write([]byte("Unsubscribed!"))
 }
 
+// watchDigestHandler sets how often the email of a watch gets alerts, the unsubscribe token
+// of any of its watches is proof of the email
+func (s *APIServer) watchDigestHandler(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+	if hash == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no hash"))
+		return
+	}
+
+	type input struct {
+		Digest string // immediate, daily or weekly
+	}
+
+	var in input
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	watchProduct, err := s.DB.GetWatchProductByUnsubscribeHash(formatters.HashToken(hash))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	preference := &scraper.EmailPreference{Email: watchProduct.Email}
+	err = preference.SetDigest(in.Digest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = s.DB.UpdateOrCreateEmailPreference(preference)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Write([]byte("Saved!"))
+}
+
 func (s *APIServer) contactHandler(w http.ResponseWriter, r *http.Request) {
 	type email struct {
 		From    string
@@ -658,29 +961,36 @@ func (s *APIServer) contactHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	absPath := os.Getenv("PRICE_ABS_PATH")
-	tmpl, err := template.ParseFiles(fmt.Sprintf("%s/templates/contact.html", absPath))
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(err.Error()))
+	e.From = formatters.NormalizeEmail(e.From)
+	if !formatters.IsValidEmail(e.From) {
+		reject(w, "contact", "invalid_email", http.StatusBadRequest, "invalid email")
 		return
 	}
 
-	var tmplBuffer bytes.Buffer
-	err = tmpl.Execute(&tmplBuffer, e)
+	if strings.TrimSpace(e.Message) == "" || len(e.Message) > maxContactLength {
+		reject(w, "contact", "invalid_message", http.StatusBadRequest, fmt.Sprintf("message has to be 1 to %d characters", maxContactLength))
+		return
+	}
+
+	if !s.allow(r, "contact:ip:"+s.clientIP(r), contactIPLimit) {
+		reject(w, "contact", "ip_rate_limit", http.StatusTooManyRequests, "too many requests")
+		return
+	}
+
+	rendered, err := templates.Render(templates.DefaultLocale, "contact", e)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error()))
 		return
 	}
 
-	from := mail.NewEmail("Verð frá", os.Getenv("PRICE_EMAIL_FROM"))
-	subject := "Hafa samband"
-	to := mail.NewEmail("Hilmar", "hilmar@hilmarp.com")
-	tmplStr := tmplBuffer.String()
-	message := mail.NewSingleEmail(from, subject, to, tmplStr, tmplStr)
-	client := sendgrid.NewSendClient(os.Getenv("PRICE_EMAIL_API_KEY"))
-	_, err = client.Send(message)
+	err = s.Notifier.Notify(r.Context(), notify.Message{
+		To:      "hilmar@hilmarp.com",
+		ToName:  "Hilmar",
+		Subject: rendered.Subject,
+		HTML:    rendered.HTML,
+		Text:    rendered.Text,
+	})
 	if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusBadRequest)
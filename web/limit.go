@@ -0,0 +1,78 @@
+package web
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/metrics"
+)
+
+// Limiter counts requests to keys in fixed windows, implemented by Redis
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error)
+}
+
+// rateLimit is at most Limit requests in Window
+type rateLimit struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Limits of the endpoints that send email
+var (
+	watchIPLimit   = rateLimit{Limit: 10, Window: time.Hour}
+	contactIPLimit = rateLimit{Limit: 5, Window: time.Hour}
+	loginIPLimit   = rateLimit{Limit: 10, Window: time.Hour}
+	recipientLimit = rateLimit{Limit: 10, Window: 24 * time.Hour}  // Verify and login emails to one address
+	verifyCooldown = rateLimit{Limit: 1, Window: 10 * time.Minute} // Verify emails for the same watch
+	loginCooldown  = rateLimit{Limit: 1, Window: time.Minute}
+)
+
+// maxContactLength is the longest contact message allowed
+const maxContactLength int = 5000
+
+// allow returns if a request to key is within limit, everything is allowed without a limiter
+// and when it fails, so Redis being down doesn't take the endpoints with it
+func (s *APIServer) allow(r *http.Request, key string, limit rateLimit) bool {
+	if s.Limiter == nil {
+		return true
+	}
+
+	ok, err := s.Limiter.Allow(r.Context(), "limit:"+key, limit.Limit, limit.Window)
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+
+	return ok
+}
+
+// reject responds with status and message and counts the rejected request to endpoint for reason
+func reject(w http.ResponseWriter, endpoint, reason string, status int, message string) {
+	metrics.RejectedRequests.WithLabelValues(endpoint, reason).Inc()
+
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
+
+// clientIP returns the IP of the client, from X-Forwarded-For when the server is behind a proxy
+func (s *APIServer) clientIP(r *http.Request) string {
+	if s.TrustProxy {
+		// The proxy appends the address it got the request from, the rest is up to the client
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -64,3 +64,18 @@ func (r *Redis) MGet(ctx context.Context, keys ...string) ([]interface{}, error)
 
 	return values, nil
 }
+
+// Allow counts a request to key and returns if there have been at most limit requests
+// in the window, which starts with the first request. The key is created with its expiry in the same
+// transaction as it's counted, so it can't be left without one
+func (r *Redis) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	pipe := r.Client.TxPipeline()
+	pipe.SetNX(ctx, key, 0, window)
+	incr := pipe.Incr(ctx, key)
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return incr.Val() <= int64(limit), nil
+}
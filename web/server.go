@@ -3,7 +3,9 @@ package web
 import (
 	"fmt"
 	"net/http"
+	"time"
 
+	"bitbucket.org/hilmarp/price-scraper/notify"
 	"bitbucket.org/hilmarp/price-scraper/scraper"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/cors"
@@ -12,14 +14,32 @@ import (
 
 // APIServer is the /api web server
 type APIServer struct {
-	DB    *scraper.SQL
-	ES    *scraper.Elasticsearch
-	Redis *Redis
-	Port  string
+	DB         scraper.Repository
+	ES         scraper.SearchRepository
+	Redis      *Redis
+	Currencies *scraper.CurrencyRates
+	Port       string
+	AdminToken string
+	Notifier   notify.Notifier // Watch verification, login and contact emails
+	Limiter    Limiter         // Rate limits of endpoints that send email, none when nil
+	TrustProxy bool            // Client IPs are from X-Forwarded-For
+	VerifyTTL  time.Duration   // How long watch verify links work, scraper.DefaultVerifyTTL when 0
+	Dev        bool            // Enables dev only routes, ex. email previews
+	PushKey    string          // VAPID public key browsers subscribe to Web Push with, Web Push is off when empty
 }
 
 // StartServer will start the web server at localhost:port
 func (s *APIServer) StartServer() error {
+	err := http.ListenAndServe(fmt.Sprintf(":%s", s.Port), s.Routes())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Routes returns the router with all API routes
+func (s *APIServer) Routes() http.Handler {
 	r := chi.NewRouter()
 
 	// Middleware
@@ -30,7 +50,7 @@ func (s *APIServer) StartServer() error {
 		AllowedOrigins: []string{"https://verdfra.is", "https://www.verdfra.is", "http://localhost:3004"},
 		AllowedMethods: []string{"GET", "HEAD", "PUT", "PATCH", "POST", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders: []string{"Link"},
+		ExposedHeaders: []string{"Link", "X-Next-Cursor"},
 		MaxAge:         600,
 	}))
 
@@ -40,8 +60,10 @@ func (s *APIServer) StartServer() error {
 	// Products
 	r.Get("/product/{slug}", s.productHandler)
 	r.Get("/product/{id}/prices", s.productPricesHandler)
+	r.Get("/product/{id}/price-stats", s.productPriceStatsHandler)
 	r.Get("/product/{id}/specs", s.productSpecsHandler)
 	r.Get("/product/{id}/stocks", s.productStocksHandler)
+	r.Get("/product/{id}/stocks/history", s.productStockHistoryHandler)
 	r.Get("/product/{id}/images", s.productImagesHandler)
 	r.Get("/product/{id}/categories", s.productCategoriesHandler)
 	r.Get("/product/{id}/price-change", s.productPriceChangeHandler)
@@ -50,6 +72,7 @@ func (s *APIServer) StartServer() error {
 	r.Get("/products/popular", s.productsPopularHandler)
 	r.Get("/products/price-changes", s.productsPriceChangesHandler)
 	r.Get("/products/last-updated", s.productsLastUpdatedHandler)
+	r.Get("/products/suspicious-sales", s.productsSuspiciousSalesHandler)
 
 	// Categories
 	r.Get("/categories", s.categoriesHandler)
@@ -62,6 +85,24 @@ func (s *APIServer) StartServer() error {
 	r.Post("/watch/product/{id}", s.watchProductHandler)
 	r.Post("/watch/verify/{hash}", s.watchVerifyHandler)
 	r.Post("/watch/unsubscribe/{hash}", s.watchUnsubscribeHandler)
+	r.Post("/watch/digest/{hash}", s.watchDigestHandler)
+
+	// User
+	r.Post("/user/login", s.userLoginHandler)
+	r.Post("/user/login/{hash}", s.userLoginVerifyHandler)
+	r.Group(func(r chi.Router) {
+		r.Use(s.userMiddleware)
+		r.Post("/user/logout", s.userLogoutHandler)
+		r.Get("/user", s.userHandler)
+		r.Get("/user/watches", s.userWatchesHandler)
+		r.Put("/user/watches/{id}", s.userUpdateWatchHandler)
+		r.Delete("/user/watches/{id}", s.userDeleteWatchHandler)
+		r.Get("/user/preferences", s.userPreferencesHandler)
+		r.Put("/user/preferences", s.userUpdatePreferencesHandler)
+		r.Get("/user/push", s.userPushKeyHandler)
+		r.Post("/user/push", s.userPushSubscribeHandler)
+		r.Delete("/user/push", s.userPushUnsubscribeHandler)
+	})
 
 	// Go to
 	r.Get("/goto/{id}", s.gotoHandler)
@@ -70,10 +111,19 @@ func (s *APIServer) StartServer() error {
 	r.Get("/search", s.searchHandler)
 	r.Post("/contact", s.contactHandler)
 
-	err := http.ListenAndServe(fmt.Sprintf(":%s", s.Port), r)
-	if err != nil {
-		return err
-	}
+	// Admin
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(s.adminMiddleware)
+		r.Get("/currency-rates", s.adminCurrencyRatesHandler)
+		r.Put("/currency-rates", s.adminUpdateCurrencyRatesHandler)
+		r.Get("/shipping-rules", s.adminShippingRulesHandler)
+		r.Put("/shipping-rules/{source}", s.adminUpdateShippingRuleHandler)
+		r.Get("/notifications", s.adminNotificationsHandler)
+		r.Post("/notifications/{id}/retry", s.adminRetryNotificationHandler)
+		if s.Dev {
+			r.Get("/email-preview/{template}", s.adminEmailPreviewHandler)
+		}
+	})
 
-	return nil
+	return r
 }
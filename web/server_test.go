@@ -0,0 +1,959 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+	"bitbucket.org/hilmarp/price-scraper/metrics"
+	"bitbucket.org/hilmarp/price-scraper/notify"
+	"bitbucket.org/hilmarp/price-scraper/scraper"
+	"bitbucket.org/hilmarp/price-scraper/scraper/scrapertest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testAdminToken = "secret"
+
+// testNotifier keeps the messages it's sent
+type testNotifier struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (n *testNotifier) Notify(ctx context.Context, message notify.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+// sent returns the messages sent by s
+func sent(s *APIServer) []notify.Message {
+	n := s.Notifier.(*testNotifier)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]notify.Message{}, n.messages...)
+}
+
+// testLimiter counts requests in memory, windows never end
+type testLimiter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (l *testLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.counts == nil {
+		l.counts = make(map[string]int)
+	}
+	l.counts[key]++
+
+	return l.counts[key] <= limit, nil
+}
+
+// newTestServer returns a server with in-memory repositories and two stored products,
+// elko-1 (id 1, on sale, price changed) and tl-2 (id 2)
+func newTestServer(t *testing.T) (*APIServer, *scrapertest.Repository) {
+	db := &scrapertest.Repository{}
+	es := &scrapertest.Search{}
+
+	currencies := &scraper.CurrencyRates{Path: filepath.Join(t.TempDir(), "currency-rates.json")}
+	err := currencies.Set(map[string]float64{"EUR": 150})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for _, p := range []*scraper.Product{
+		{
+			Source:         "elko.is",
+			Slug:           "elko-1",
+			URL:            "https://elko.is/1",
+			Title:          "Sjónvarp",
+			Price:          3000,
+			PriceISK:       3000,
+			TotalPrice:     3000,
+			OnSale:         true,
+			SuspiciousSale: true,
+			Prices:         []scraper.Price{{Price: 3000, PriceISK: 3000, Date: now}},
+			Specs:          []scraper.Spec{{Key: "Litur", Value: "Svartur"}},
+			Stocks:         []scraper.Stock{{Location: "Lindir", InStock: true}},
+			AllImgURLs:     []scraper.Image{{URL: "https://elko.is/1.jpg"}},
+			Categories:     []scraper.Category{{Name: "Sjónvörp", Slug: "sjonvorp"}},
+		},
+		{
+			Source:     "tl.is",
+			Slug:       "tl-2",
+			URL:        "https://tl.is/2",
+			Title:      "Tölva",
+			Price:      1500,
+			PriceISK:   1500,
+			TotalPrice: 1500,
+			Prices:     []scraper.Price{{Price: 1500, PriceISK: 1500, Date: now}},
+		},
+	} {
+		stored, err := db.UpdateOrCreateProduct(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var inStockAt []string
+		for _, stock := range stored.Stocks {
+			if stock.InStock {
+				inStockAt = append(inStockAt, stock.Location)
+			}
+		}
+
+		err = es.UpdateOrIndexSearchProduct(&scraper.SearchProduct{
+			ID:        stored.ID,
+			Source:    stored.Source,
+			Slug:      stored.Slug,
+			URL:       []string{stored.URL},
+			Title:     stored.Title,
+			PriceISK:  stored.PriceISK,
+			InStock:   len(inStockAt) > 0,
+			InStockAt: inStockAt,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = db.UpdateOrCreateProductPriceChange(&scraper.ProductPriceChange{ProductID: 1, PriceDiff: 500, PriceLower: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.CreateWatchProduct(&scraper.WatchProduct{Email: "a@b.is", ProductID: 1, PriceDrop: true, VerifyHash: formatters.HashToken("verify")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CreateUnsubscribeToken(&scraper.UnsubscribeToken{WatchProductID: 1, Hash: formatters.HashToken("unsubscribe")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &APIServer{DB: db, ES: es, Currencies: currencies, AdminToken: testAdminToken, Notifier: &testNotifier{}}, db
+}
+
+// serve sends a request to the server routes, with the admin token when admin is set
+func serve(s *APIServer, method, target, body string, admin bool) *httptest.ResponseRecorder {
+	token := ""
+	if admin {
+		token = testAdminToken
+	}
+
+	return serveWithToken(s, method, target, body, token)
+}
+
+// serveWithToken sends a request to the server routes with token as a bearer token, if any
+func serveWithToken(s *APIServer, method, target, body, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.Routes().ServeHTTP(w, r)
+
+	return w
+}
+
+// linkToken returns the token at the end of the first link with path in html
+func linkToken(html, path string) string {
+	i := strings.Index(html, path)
+	if i < 0 {
+		return ""
+	}
+
+	token := html[i+len(path):]
+	return token[:strings.IndexAny(token, `"<`)]
+}
+
+// decode unmarshals the response body into v
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("Content-Type = %q, want application/json", ct)
+	}
+
+	err := json.Unmarshal(w.Body.Bytes(), v)
+	if err != nil {
+		t.Fatalf("invalid JSON %q: %s", w.Body.String(), err)
+	}
+}
+
+func TestRoutesStatus(t *testing.T) {
+	tests := []struct {
+		method string
+		target string
+		body   string
+		admin  bool
+		status int
+	}{
+		{"GET", "/", "", false, http.StatusOK},
+		{"GET", "/prometheus/metrics", "", false, http.StatusOK},
+		{"GET", "/product/elko-1", "", false, http.StatusOK},
+		{"GET", "/product/missing", "", false, http.StatusBadRequest},
+		{"GET", "/product/elko-1?currency=XYZ", "", false, http.StatusBadRequest},
+		{"GET", "/product/1/prices", "", false, http.StatusOK},
+		{"GET", "/product/x/prices", "", false, http.StatusBadRequest},
+		{"GET", "/product/1/prices?from=2000-01-01", "", false, http.StatusBadRequest},
+		{"GET", "/product/1/price-stats", "", false, http.StatusOK},
+		{"GET", "/product/x/price-stats", "", false, http.StatusBadRequest},
+		{"GET", "/product/1/specs", "", false, http.StatusOK},
+		{"GET", "/product/x/specs", "", false, http.StatusBadRequest},
+		{"GET", "/product/1/stocks", "", false, http.StatusOK},
+		{"GET", "/product/x/stocks", "", false, http.StatusBadRequest},
+		{"GET", "/product/1/stocks/history", "", false, http.StatusOK},
+		{"GET", "/product/x/stocks/history", "", false, http.StatusBadRequest},
+		{"GET", "/product/1/stocks/history?from=2000-01-01", "", false, http.StatusBadRequest},
+		{"GET", "/product/1/images", "", false, http.StatusOK},
+		{"GET", "/product/x/images", "", false, http.StatusBadRequest},
+		{"GET", "/product/1/categories", "", false, http.StatusOK},
+		{"GET", "/product/x/categories", "", false, http.StatusBadRequest},
+		{"GET", "/product/1/price-change", "", false, http.StatusOK},
+		{"GET", "/product/2/price-change", "", false, http.StatusBadRequest},
+		{"GET", "/products", "", false, http.StatusOK},
+		{"GET", "/products?currency=XYZ", "", false, http.StatusBadRequest},
+		{"GET", "/products?cursor=x", "", false, http.StatusBadRequest},
+		{"GET", "/products?order_by=price_isk", "", false, http.StatusBadRequest},
+		{"GET", "/products/popular?cursor=x", "", false, http.StatusBadRequest},
+		{"GET", "/products/price-changes?cursor=x", "", false, http.StatusBadRequest},
+		{"GET", "/products/suspicious-sales?cursor=x", "", false, http.StatusBadRequest},
+		{"GET", "/products/count", "", false, http.StatusOK},
+		{"GET", "/products/popular", "", false, http.StatusOK},
+		{"GET", "/products/price-changes", "", false, http.StatusOK},
+		{"GET", "/products/last-updated", "", false, http.StatusOK},
+		{"GET", "/products/suspicious-sales", "", false, http.StatusOK},
+		{"GET", "/categories", "", false, http.StatusOK},
+		{"GET", "/category/sjonvorp", "", false, http.StatusOK},
+		{"GET", "/category/missing", "", false, http.StatusBadRequest},
+		{"POST", "/watch/product/x", `{"Email":"a@b.is"}`, false, http.StatusBadRequest},
+		{"POST", "/watch/product/99", `{"Email":"a@b.is"}`, false, http.StatusBadRequest},
+		{"POST", "/watch/product/1", `{`, false, http.StatusBadRequest},
+		{"POST", "/watch/product/1", `{"Email":""}`, false, http.StatusBadRequest},
+		{"POST", "/watch/product/1", `{"Email":"a@b"}`, false, http.StatusBadRequest},
+		{"POST", "/watch/product/1", `{"Email":"Jón <a@b.is>"}`, false, http.StatusBadRequest},
+		{"POST", "/watch/product/1", `{"Email":"a@b.is","Alerts":["sale"]}`, false, http.StatusBadRequest},
+		{"POST", "/watch/product/1", `{"Email":"a@b.is","Alerts":["in_stock_at"]}`, false, http.StatusBadRequest},
+		{"POST", "/watch/product/1", `{"Email":"a@b.is","Location":"Lindir"}`, false, http.StatusBadRequest},
+		{"POST", "/watch/product/1", `{"Email":"a@b.is","MinDropPercent":101}`, false, http.StatusBadRequest},
+		{"POST", "/watch/product/1", `{"Email":"a@b.is","Alerts":["back_in_stock"],"TargetPrice":1000}`, false, http.StatusBadRequest},
+		{"POST", "/watch/verify/verify", "", false, http.StatusOK},
+		{"POST", "/watch/verify/missing", "", false, http.StatusBadRequest},
+		{"POST", "/watch/digest/unsubscribe", `{"Digest":"daily"}`, false, http.StatusOK},
+		{"POST", "/watch/digest/unsubscribe", `{"Digest":"monthly"}`, false, http.StatusBadRequest},
+		{"POST", "/watch/digest/missing", `{"Digest":"daily"}`, false, http.StatusBadRequest},
+		{"POST", "/user/login", `{"Email":"a@b.is"}`, false, http.StatusOK},
+		{"POST", "/user/login", `{"Email":""}`, false, http.StatusBadRequest},
+		{"POST", "/user/login", `{"Email":"a@b.is\r\nBcc: c@d.is"}`, false, http.StatusBadRequest},
+		{"POST", "/contact", `{"From":"a@b.is","Message":"Halló"}`, false, http.StatusOK},
+		{"POST", "/contact", `{"From":"nobody","Message":"Halló"}`, false, http.StatusBadRequest},
+		{"POST", "/contact", `{"From":"a@b.is","Message":" "}`, false, http.StatusBadRequest},
+		{"POST", "/user/login/missing", "", false, http.StatusBadRequest},
+		{"GET", "/user", "", false, http.StatusUnauthorized},
+		{"GET", "/user/watches", "", true, http.StatusUnauthorized},
+		{"DELETE", "/user/watches/1", "", false, http.StatusUnauthorized},
+		{"POST", "/watch/unsubscribe/unsubscribe", "", false, http.StatusOK},
+		{"GET", "/goto/1", "", false, http.StatusSeeOther},
+		{"GET", "/goto/99", "", false, http.StatusBadRequest},
+		{"GET", "/search?value=sjón", "", false, http.StatusOK},
+		{"GET", "/search", "", false, http.StatusBadRequest},
+		{"POST", "/contact", `{`, false, http.StatusBadRequest},
+		{"GET", "/admin/currency-rates", "", false, http.StatusUnauthorized},
+		{"GET", "/admin/currency-rates", "", true, http.StatusOK},
+		{"PUT", "/admin/currency-rates", `{"EUR":-1}`, true, http.StatusBadRequest},
+		{"GET", "/admin/shipping-rules", "", false, http.StatusUnauthorized},
+		{"GET", "/admin/shipping-rules", "", true, http.StatusOK},
+		{"PUT", "/admin/shipping-rules/elko.is", `{`, true, http.StatusBadRequest},
+		{"GET", "/admin/notifications", "", false, http.StatusUnauthorized},
+		{"GET", "/admin/notifications", "", true, http.StatusOK},
+		{"GET", "/admin/notifications?status=lost", "", true, http.StatusBadRequest},
+		{"POST", "/admin/notifications/1/retry", "", true, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		s, _ := newTestServer(t)
+		w := serve(s, test.method, test.target, test.body, test.admin)
+		if w.Code != test.status {
+			t.Errorf("%s %s = %d, want %d: %s", test.method, test.target, w.Code, test.status, w.Body.String())
+		}
+	}
+}
+
+func TestProductRoute(t *testing.T) {
+	s, db := newTestServer(t)
+
+	var product scraper.Product
+	decode(t, serve(s, "GET", "/product/elko-1?currency=eur", "", false), &product)
+	if product.ID != 1 || product.DisplayPrice != 20 || product.DisplayCurrency != "EUR" {
+		t.Errorf("product = %d %v %s, want 1 20 EUR", product.ID, product.DisplayPrice, product.DisplayCurrency)
+	}
+
+	var popular []scraper.ProductPriceDiff
+	decode(t, serve(s, "GET", "/products/popular", "", false), &popular)
+	if len(popular) != 1 || popular[0].ID != 1 {
+		t.Errorf("popular = %v, want product 1 after one view", popular)
+	}
+
+	w := serve(s, "GET", "/goto/1", "", false)
+	if loc := w.Header().Get("Location"); loc != "https://elko.is/1?utm_source=verdfra.is" {
+		t.Errorf("goto Location = %q", loc)
+	}
+	if db.ClickCount(1) != 1 {
+		t.Errorf("click count = %d, want 1", db.ClickCount(1))
+	}
+}
+
+func TestProductChildRoutes(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	var prices []scraper.Price
+	decode(t, serve(s, "GET", "/product/1/prices", "", false), &prices)
+	if len(prices) != 1 || prices[0].PriceISK != 3000 {
+		t.Errorf("prices = %v, want one price of 3000", prices)
+	}
+
+	var stats scraper.PriceStats
+	decode(t, serve(s, "GET", "/product/1/price-stats", "", false), &stats)
+	if stats.ProductID != 1 {
+		t.Errorf("price stats product = %d, want 1", stats.ProductID)
+	}
+
+	var specs []scraper.Spec
+	decode(t, serve(s, "GET", "/product/1/specs", "", false), &specs)
+	if len(specs) != 1 || specs[0].Key != "Litur" {
+		t.Errorf("specs = %v", specs)
+	}
+
+	var stocks []scraper.Stock
+	decode(t, serve(s, "GET", "/product/1/stocks", "", false), &stocks)
+	if len(stocks) != 1 || !stocks[0].InStock {
+		t.Errorf("stocks = %v", stocks)
+	}
+
+	var history []scraper.StockChange
+	decode(t, serve(s, "GET", "/product/1/stocks/history", "", false), &history)
+	if len(history) != 1 || history[0].Location != "Lindir" || !history[0].InStock {
+		t.Errorf("stock history = %v, want Lindir in stock", history)
+	}
+
+	var images []scraper.Image
+	decode(t, serve(s, "GET", "/product/1/images", "", false), &images)
+	if len(images) != 1 {
+		t.Errorf("images = %v", images)
+	}
+
+	var categories []scraper.Category
+	decode(t, serve(s, "GET", "/product/1/categories", "", false), &categories)
+	if len(categories) != 1 || categories[0].Slug != "sjonvorp" {
+		t.Errorf("categories = %v", categories)
+	}
+
+	var priceChange scraper.ProductPriceChange
+	decode(t, serve(s, "GET", "/product/1/price-change", "", false), &priceChange)
+	if priceChange.PriceDiff != 500 || !priceChange.PriceLower {
+		t.Errorf("price change = %v", priceChange)
+	}
+}
+
+func TestProductsRoutes(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		target string
+		ids    []uint
+	}{
+		{"/products", []uint{2, 1}},
+		{"/products?order_by=price", []uint{2, 1}},
+		{"/products?order_by=price&order_by_dir=desc", []uint{1, 2}},
+		{"/products?limit=1&offset=1", []uint{1}},
+		{"/products?price_from=2000", []uint{1}},
+		{"/products?price_to=2000", []uint{2}},
+		{"/products?on_sale=true", []uint{1}},
+		{"/products?sources=tl.is", []uint{2}},
+		{"/products?categories=sjonvorp", []uint{1}},
+		{"/products?in_stock=true", []uint{1}},
+		{"/products?in_stock=false", []uint{2}},
+		{"/products?location=Lindir", []uint{1}},
+		{"/products?location=Smáralind", nil},
+		{"/products?location=Lindir&in_stock=false", []uint{2}},
+		{"/products?brands=Bosch", nil},
+		{"/products?order_by=title", []uint{1, 2}},
+		{"/products?order_by=title&order_by_dir=desc", []uint{2, 1}},
+		{"/products/price-changes", []uint{1}},
+		{"/products/price-changes?lower=false", nil},
+	}
+
+	for _, test := range tests {
+		var products []scraper.ProductPriceDiff
+		decode(t, serve(s, "GET", test.target, "", false), &products)
+
+		var ids []uint
+		for _, p := range products {
+			ids = append(ids, p.ID)
+		}
+
+		if len(ids) != len(test.ids) {
+			t.Errorf("%s = %v, want %v", test.target, ids, test.ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.ids[i] {
+				t.Errorf("%s = %v, want %v", test.target, ids, test.ids)
+				break
+			}
+		}
+	}
+
+	var count struct{ Count int }
+	decode(t, serve(s, "GET", "/products/count?on_sale=false", "", false), &count)
+	if count.Count != 1 {
+		t.Errorf("count = %d, want 1", count.Count)
+	}
+
+	var suspicious []scraper.Product
+	decode(t, serve(s, "GET", "/products/suspicious-sales?sources=elko.is", "", false), &suspicious)
+	if len(suspicious) != 1 || suspicious[0].ID != 1 {
+		t.Errorf("suspicious sales = %v", suspicious)
+	}
+
+	var last scraper.Product
+	decode(t, serve(s, "GET", "/products/last-updated", "", false), &last)
+	if last.ID != 2 {
+		t.Errorf("last updated = %d, want 2", last.ID)
+	}
+}
+
+func TestProductsCursor(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	w := serve(s, "GET", "/products?order_by=price&limit=1", "", false)
+	var products []scraper.ProductPriceDiff
+	decode(t, w, &products)
+	cursor := w.Header().Get("X-Next-Cursor")
+	if len(products) != 1 || products[0].ID != 2 || cursor == "" {
+		t.Fatalf("first page = %v with cursor %q, want product 2 and a cursor", products, cursor)
+	}
+
+	w = serve(s, "GET", "/products?order_by=price&limit=1&cursor="+cursor, "", false)
+	decode(t, w, &products)
+	if len(products) != 1 || products[0].ID != 1 {
+		t.Errorf("second page = %v, want product 1", products)
+	}
+
+	// The cursor is for another sort
+	w = serve(s, "GET", "/products?order_by=total_price&limit=1&cursor="+cursor, "", false)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("cursor for another sort = %d, want 400", w.Code)
+	}
+
+	// Pages after a cursor aren't counted with an offset
+	w = serve(s, "GET", "/products?order_by=price&limit=1&offset=1&cursor="+cursor, "", false)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("cursor with offset = %d, want 400", w.Code)
+	}
+}
+
+func TestCategoriesRoutes(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	var categories []scraper.UniqueCategory
+	decode(t, serve(s, "GET", "/categories", "", false), &categories)
+	if len(categories) != 1 || categories[0].Slug != "sjonvorp" {
+		t.Errorf("categories = %v", categories)
+	}
+
+	var category scraper.UniqueCategory
+	decode(t, serve(s, "GET", "/category/sjonvorp", "", false), &category)
+	if category.Name != "Sjónvörp" {
+		t.Errorf("category = %v", category)
+	}
+}
+
+func TestWatchRoutes(t *testing.T) {
+	s, db := newTestServer(t)
+
+	w := serve(s, "POST", "/watch/product/2", `{"Email":"c@d.is","Alerts":["back_in_stock"]}`, false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("watch = %d: %s", w.Code, w.Body.String())
+	}
+	watches := db.WatchProducts()
+	if len(watches) != 2 || watches[1].ProductID != 2 || !watches[1].BackInStock || watches[1].PriceDrop {
+		t.Errorf("watches = %v, want a back in stock watch of product 2", watches)
+	}
+	messages := sent(s)
+	if len(messages) != 1 || messages[0].To != "c@d.is" || formatters.HashToken(linkToken(messages[0].HTML, "/watch/verify/")) != watches[1].VerifyHash {
+		t.Errorf("messages = %v, want a verify email to c@d.is", messages)
+	}
+	db.DeleteWatchProductByID(watches[1].ID)
+
+	serve(s, "POST", "/watch/verify/verify", "", false)
+	if watches := db.WatchProducts(); len(watches) != 1 || !watches[0].Verified {
+		t.Errorf("watch not verified: %v", watches)
+	}
+
+	serve(s, "POST", "/watch/digest/unsubscribe", `{"Digest":"weekly"}`, false)
+	preference, err := db.GetEmailPreference("a@b.is")
+	if err != nil || preference.Digest != scraper.DigestWeekly {
+		t.Errorf("preference = %v, %v, want weekly", preference, err)
+	}
+
+	serve(s, "POST", "/watch/unsubscribe/unsubscribe", "", false)
+	if watches := db.WatchProducts(); len(watches) != 0 {
+		t.Errorf("watch not deleted: %v", watches)
+	}
+}
+
+func TestWatchLocale(t *testing.T) {
+	s, db := newTestServer(t)
+
+	r := httptest.NewRequest("POST", "/watch/product/2", strings.NewReader(`{"Email":"c@d.is"}`))
+	r.Header.Set("Accept-Language", "en-GB,en;q=0.9,is;q=0.8")
+	w := httptest.NewRecorder()
+	s.Routes().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("watch = %d: %s", w.Code, w.Body.String())
+	}
+
+	watches := db.WatchProducts()
+	if len(watches) != 2 || watches[1].Locale != "en" {
+		t.Errorf("watches = %v, want an English watch of product 2", watches)
+	}
+	messages := sent(s)
+	if len(messages) != 1 || messages[0].Subject != "Verify your email" || !strings.Contains(messages[0].HTML, "Verify</a>") || !strings.Contains(messages[0].Text, "https://verdfra.is/watch/verify/") {
+		t.Errorf("messages = %v, want an English verify email", messages)
+	}
+}
+
+func TestWatchVerifyExpiry(t *testing.T) {
+	s, db := newTestServer(t)
+
+	watch := db.WatchProducts()[0]
+	sent := time.Now().Add(-72 * time.Hour)
+	watch.VerifySentAt = &sent
+	err := db.UpdateWatchProduct(&watch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(s, "POST", "/watch/verify/verify", "", false)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "expired") {
+		t.Errorf("verify = %d %s, want expired", w.Code, w.Body.String())
+	}
+
+	s.VerifyTTL = 96 * time.Hour
+	w = serve(s, "POST", "/watch/verify/verify", "", false)
+	if w.Code != http.StatusOK || !db.WatchProducts()[0].Verified {
+		t.Errorf("verify = %d, want verified with a longer TTL", w.Code)
+	}
+}
+
+func TestContactRoute(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	w := serve(s, "POST", "/contact", `{"From":"a@b.is","Message":"Halló"}`, false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("contact = %d: %s", w.Code, w.Body.String())
+	}
+
+	messages := sent(s)
+	if len(messages) != 1 || messages[0].Subject != "Hafa samband" || !strings.Contains(messages[0].HTML, "Halló") {
+		t.Errorf("messages = %v, want the contact message", messages)
+	}
+}
+
+func TestSearchRoute(t *testing.T) {
+	s, db := newTestServer(t)
+
+	err := db.UpdateOrCreateShippingRule(&scraper.ShippingRule{Source: "elko.is", FlatFee: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var products []scraper.SearchProduct
+	decode(t, serve(s, "GET", "/search?value=sjón", "", false), &products)
+	if len(products) != 1 || products[0].ID != 1 {
+		t.Fatalf("search = %v, want product 1", products)
+	}
+	if products[0].ShippingCost != 1000 || products[0].TotalPrice != 4000 {
+		t.Errorf("search shipping = %d total %d, want 1000 and 4000", products[0].ShippingCost, products[0].TotalPrice)
+	}
+
+	decode(t, serve(s, "GET", "/search?value=https://tl.is/2", "", false), &products)
+	if len(products) != 1 || products[0].ID != 2 {
+		t.Errorf("search by URL = %v, want product 2", products)
+	}
+
+	decode(t, serve(s, "GET", "/search?value=sjón&in_stock=false", "", false), &products)
+	if len(products) != 0 {
+		t.Errorf("search out of stock = %v, want none", products)
+	}
+
+	decode(t, serve(s, "GET", "/search?value=sjón&location=Lindir", "", false), &products)
+	if len(products) != 1 || products[0].ID != 1 {
+		t.Errorf("search at Lindir = %v, want product 1", products)
+	}
+
+	decode(t, serve(s, "GET", "/search?value=https://tl.is/2&in_stock=true", "", false), &products)
+	if len(products) != 0 {
+		t.Errorf("search by URL in stock = %v, want none", products)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	s, db := newTestServer(t)
+
+	var rates map[string]float64
+	decode(t, serve(s, "PUT", "/admin/currency-rates", `{"usd":130}`, true), &rates)
+	if len(rates) != 1 || rates["USD"] != 130 {
+		t.Errorf("rates = %v, want USD 130", rates)
+	}
+
+	w := serve(s, "PUT", "/admin/shipping-rules/elko.is", `{"FlatFee":990,"FreeAbove":5000}`, false)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("shipping rule without token = %d, want 401", w.Code)
+	}
+
+	var rule scraper.ShippingRule
+	decode(t, serve(s, "PUT", "/admin/shipping-rules/elko.is", `{"FlatFee":990,"FreeAbove":5000}`, true), &rule)
+	if rule.Source != "elko.is" || rule.FlatFee != 990 {
+		t.Errorf("rule = %v", rule)
+	}
+
+	var rules []scraper.ShippingRule
+	decode(t, serve(s, "GET", "/admin/shipping-rules", "", true), &rules)
+	if len(rules) != 1 {
+		t.Errorf("rules = %v, want 1", rules)
+	}
+
+	product, err := db.GetProductByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if product.ShippingCost != 990 || product.TotalPrice != 3990 {
+		t.Errorf("product shipping = %d total %d, want 990 and 3990", product.ShippingCost, product.TotalPrice)
+	}
+}
+
+func TestAdminNotificationRoutes(t *testing.T) {
+	s, db := newTestServer(t)
+
+	for _, status := range []string{scraper.NotificationSent, scraper.NotificationFailed} {
+		n := scraper.NewNotification(scraper.AlertPriceDrop, "sendgrid", notify.Message{To: "a@b.is", Subject: "Verðlækkun"})
+		err := db.CreateNotifications([]*scraper.Notification{n})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Status = status
+		n.Attempts = 8
+		n.LastError = "down"
+		err = db.UpdateNotification(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var notifications []scraper.Notification
+	decode(t, serve(s, "GET", "/admin/notifications", "", true), &notifications)
+	if len(notifications) != 1 || notifications[0].ID != 2 || notifications[0].LastError != "down" {
+		t.Errorf("failed notifications = %v, want 2", notifications)
+	}
+
+	decode(t, serve(s, "GET", "/admin/notifications?status=all", "", true), &notifications)
+	if len(notifications) != 2 {
+		t.Errorf("all notifications = %v, want 2", notifications)
+	}
+
+	// Limits and offsets out of range get the defaults
+	for _, query := range []string{"limit=-1", "limit=0", "limit=101", "offset=-1"} {
+		w := serve(s, "GET", "/admin/notifications?status=all&"+query, "", true)
+		if w.Code != http.StatusOK {
+			t.Fatalf("notifications with %s = %d, want 200", query, w.Code)
+		}
+		decode(t, w, &notifications)
+		if len(notifications) != 2 {
+			t.Errorf("notifications with %s = %v, want 2", query, notifications)
+		}
+	}
+
+	w := serve(s, "POST", "/admin/notifications/2/retry", "", true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("retry = %d, want 200", w.Code)
+	}
+
+	due, err := db.GetDueNotifications(10, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*due) != 1 || (*due)[0].ID != 2 {
+		t.Errorf("due after retry = %v, want 2", *due)
+	}
+}
+
+func TestAdminEmailPreviewRoute(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	w := serve(s, "GET", "/admin/email-preview/watch-product", "", true)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("preview outside dev = %d, want 404", w.Code)
+	}
+
+	s.Dev = true
+	tests := []struct {
+		target string
+		admin  bool
+		status int
+		want   string
+	}{
+		{"/admin/email-preview/watch-product", false, http.StatusUnauthorized, ""},
+		{"/admin/email-preview/watch-product", true, http.StatusOK, "129.990 kr."},
+		{"/admin/email-preview/watch-digest?locale=en", true, http.StatusOK, "Daily summary"},
+		{"/admin/email-preview/watch-stock?locale=en&format=text", true, http.StatusOK, "Subject: Back in stock - "},
+		{"/admin/email-preview/missing", true, http.StatusNotFound, ""},
+		{"/admin/email-preview/watch-product?locale=de", true, http.StatusBadRequest, ""},
+		{"/admin/email-preview/watch-product?format=pdf", true, http.StatusBadRequest, ""},
+	}
+
+	for _, test := range tests {
+		w := serve(s, "GET", test.target, "", test.admin)
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.want) {
+			t.Errorf("GET %s = %d, want %d with %q", test.target, w.Code, test.status, test.want)
+		}
+	}
+}
+
+func TestUserRoutes(t *testing.T) {
+	s, db := newTestServer(t)
+
+	err := db.CreateWatchProduct(&scraper.WatchProduct{Email: "c@d.is", ProductID: 2, PriceDrop: true, VerifyHash: formatters.HashToken("verify2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(s, "POST", "/user/login", `{"Email":"a@b.is"}`, false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login = %d: %s", w.Code, w.Body.String())
+	}
+	user, err := db.GetUserByEmail("a@b.is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := sent(s)
+	if len(messages) != 1 || messages[0].To != "a@b.is" {
+		t.Fatalf("messages = %v, want a login email to a@b.is", messages)
+	}
+	loginToken := linkToken(messages[0].HTML, "/user/login/")
+	if formatters.HashToken(loginToken) != user.LoginHash {
+		t.Fatalf("login token %q isn't the stored hash", loginToken)
+	}
+
+	var session struct {
+		Token     string
+		ExpiresAt time.Time
+	}
+	decode(t, serve(s, "POST", "/user/login/"+loginToken, "", false), &session)
+	if session.Token == "" || session.ExpiresAt.Before(time.Now().Add(29*24*time.Hour)) {
+		t.Fatalf("session = %v, want a token for 30 days", session)
+	}
+
+	// The link only works once
+	w = serve(s, "POST", "/user/login/"+loginToken, "", false)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("second login = %d, want 400", w.Code)
+	}
+
+	var watches []scraper.WatchProduct
+	decode(t, serveWithToken(s, "GET", "/user/watches", "", session.Token), &watches)
+	if len(watches) != 1 || watches[0].ID != 1 {
+		t.Fatalf("watches = %v, want watch 1", watches)
+	}
+
+	var watch scraper.WatchProduct
+	decode(t, serveWithToken(s, "PUT", "/user/watches/1", `{"Alerts":["price_drop","back_in_stock"],"TargetPrice":2500}`, session.Token), &watch)
+	if !watch.PriceDrop || !watch.BackInStock || watch.TargetPrice != 2500 || !watch.Verified {
+		t.Errorf("watch = %v, want both alerts with target price 2500", watch)
+	}
+
+	// Watches of others can't be changed
+	w = serveWithToken(s, "PUT", "/user/watches/2", `{"Alerts":["back_in_stock"]}`, session.Token)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("update other watch = %d, want 400", w.Code)
+	}
+	w = serveWithToken(s, "DELETE", "/user/watches/2", "", session.Token)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("delete other watch = %d, want 400", w.Code)
+	}
+
+	var preferences struct {
+		Digest string
+	}
+	decode(t, serveWithToken(s, "GET", "/user/preferences", "", session.Token), &preferences)
+	if preferences.Digest != scraper.DigestImmediate {
+		t.Errorf("preferences = %v, want immediate", preferences)
+	}
+	decode(t, serveWithToken(s, "PUT", "/user/preferences", `{"Digest":"daily"}`, session.Token), &preferences)
+	if preferences.Digest != scraper.DigestDaily {
+		t.Errorf("preferences = %v, want daily", preferences)
+	}
+
+	w = serveWithToken(s, "DELETE", "/user/watches/1", "", session.Token)
+	if w.Code != http.StatusOK {
+		t.Errorf("delete watch = %d, want 200", w.Code)
+	}
+	if watches := db.WatchProducts(); len(watches) != 1 || watches[0].ID != 2 {
+		t.Errorf("watches = %v, want only watch 2", watches)
+	}
+
+	// Web Push is off without a key
+	subscription := `{"endpoint":"https://push.example.com/1","keys":{"p256dh":"key","auth":"auth"}}`
+	w = serveWithToken(s, "POST", "/user/push", subscription, session.Token)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("push subscribe without a key = %d, want 404", w.Code)
+	}
+	s.PushKey = "public"
+	var key struct {
+		PublicKey string
+	}
+	decode(t, serveWithToken(s, "GET", "/user/push", "", session.Token), &key)
+	if key.PublicKey != "public" {
+		t.Errorf("push key = %v, want public", key)
+	}
+	w = serveWithToken(s, "POST", "/user/push", `{"endpoint":"http://push.example.com/1","keys":{"p256dh":"key","auth":"auth"}}`, session.Token)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("push subscribe over http = %d, want 400", w.Code)
+	}
+	w = serveWithToken(s, "POST", "/user/push", subscription, session.Token)
+	if w.Code != http.StatusOK {
+		t.Errorf("push subscribe = %d: %s", w.Code, w.Body.String())
+	}
+	subscriptions, err := db.GetPushSubscriptionsByEmail("a@b.is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*subscriptions) != 1 || (*subscriptions)[0].Endpoint != "https://push.example.com/1" || (*subscriptions)[0].Auth != "auth" {
+		t.Errorf("subscriptions = %v, want push.example.com", *subscriptions)
+	}
+	serveWithToken(s, "DELETE", "/user/push", `{"endpoint":"https://push.example.com/1"}`, session.Token)
+	subscriptions, err = db.GetPushSubscriptionsByEmail("a@b.is")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*subscriptions) != 0 {
+		t.Errorf("subscriptions = %v after unsubscribing, want none", *subscriptions)
+	}
+
+	serveWithToken(s, "POST", "/user/logout", "", session.Token)
+	w = serveWithToken(s, "GET", "/user", "", session.Token)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("user after logout = %d, want 401", w.Code)
+	}
+}
+
+func TestWatchAbuseProtection(t *testing.T) {
+	s, db := newTestServer(t)
+	limiter := &testLimiter{}
+	s.Limiter = limiter
+
+	invalid := testutil.ToFloat64(metrics.RejectedRequests.WithLabelValues("watch", "invalid_email"))
+	w := serve(s, "POST", "/watch/product/2", `{"Email":"c@d"}`, false)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid email = %d, want 400", w.Code)
+	}
+	if got := testutil.ToFloat64(metrics.RejectedRequests.WithLabelValues("watch", "invalid_email")); got != invalid+1 {
+		t.Errorf("invalid email rejections = %v, want %v", got, invalid+1)
+	}
+
+	w = serve(s, "POST", "/watch/product/2", `{"Email":" C@d.is"}`, false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("watch = %d: %s", w.Code, w.Body.String())
+	}
+
+	// The same signup again updates the watch, but the verify email was just sent
+	w = serve(s, "POST", "/watch/product/2", `{"Email":"c@d.is","Alerts":["back_in_stock"]}`, false)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("second signup = %d, want 429", w.Code)
+	}
+	if watches := db.WatchProducts(); len(watches) != 2 || watches[1].Email != "c@d.is" {
+		t.Errorf("watches = %v, want one watch of c@d.is", watches)
+	}
+	if messages := sent(s); len(messages) != 1 {
+		t.Errorf("messages = %v, want one verify email", messages)
+	}
+
+	serve(s, "POST", "/watch/verify/verify", "", false)
+	w = serve(s, "POST", "/watch/product/1", `{"Email":"a@b.is"}`, false)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "already watching") {
+		t.Errorf("verified duplicate = %d %s, want 400", w.Code, w.Body.String())
+	}
+
+	limiter.counts["limit:recipient:e@f.is"] = recipientLimit.Limit
+	w = serve(s, "POST", "/watch/product/1", `{"Email":"e@f.is"}`, false)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("recipient over limit = %d, want 429", w.Code)
+	}
+
+	limiter.counts["limit:watch:ip:192.0.2.1"] = watchIPLimit.Limit
+	w = serve(s, "POST", "/watch/product/1", `{"Email":"g@h.is"}`, false)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("IP over limit = %d, want 429", w.Code)
+	}
+
+	if messages := sent(s); len(messages) != 1 {
+		t.Errorf("messages = %v, want no more emails", messages)
+	}
+}
+
+func TestContactAndLoginRateLimits(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.Limiter = &testLimiter{}
+
+	for i := 0; i < contactIPLimit.Limit; i++ {
+		serve(s, "POST", "/contact", `{"From":"a@b.is","Message":"Halló"}`, false)
+	}
+	w := serve(s, "POST", "/contact", `{"From":"a@b.is","Message":"Halló"}`, false)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("contact over limit = %d, want 429", w.Code)
+	}
+
+	w = serve(s, "POST", "/user/login", `{"Email":"a@b.is"}`, false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login = %d: %s", w.Code, w.Body.String())
+	}
+	w = serve(s, "POST", "/user/login", `{"Email":"a@b.is"}`, false)
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("second login = %d, want 429", w.Code)
+	}
+
+	if messages := sent(s); len(messages) != contactIPLimit.Limit+1 {
+		t.Errorf("Got %d messages, want %d", len(messages), contactIPLimit.Limit+1)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 203.0.113.7")
+
+	s := &APIServer{}
+	if ip := s.clientIP(r); ip != "192.0.2.1" {
+		t.Errorf("clientIP = %s, want the remote address without a trusted proxy", ip)
+	}
+
+	s.TrustProxy = true
+	if ip := s.clientIP(r); ip != "203.0.113.7" {
+		t.Errorf("clientIP = %s, want the address the proxy added", ip)
+	}
+}
@@ -0,0 +1,452 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/hilmarp/price-scraper/formatters"
+	"bitbucket.org/hilmarp/price-scraper/notify"
+	"bitbucket.org/hilmarp/price-scraper/scraper"
+	"bitbucket.org/hilmarp/price-scraper/templates"
+	"github.com/go-chi/chi"
+	"gorm.io/gorm"
+)
+
+// userContextKey is the request context key of the logged in user
+type userContextKey struct{}
+
+// userMiddleware only lets through requests with a session token as a bearer token,
+// the user is in the request context for the handlers
+func (s *APIServer) userMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+
+		user, err := s.DB.GetUserBySessionToken(formatters.HashToken(token), time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("Unauthorized"))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	})
+}
+
+// requestUser returns the user userMiddleware put in the request context
+func requestUser(r *http.Request) *scraper.User {
+	return r.Context().Value(userContextKey{}).(*scraper.User)
+}
+
+// userWatchProduct returns the watch with the id URL param if it's the user's
+func (s *APIServer) userWatchProduct(r *http.Request) (*scraper.WatchProduct, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, err
+	}
+
+	watchProduct, err := s.DB.GetWatchProductByID(uint(id))
+	if err != nil {
+		return nil, err
+	}
+
+	// Same error as when it doesn't exist, so IDs of others can't be found
+	if watchProduct.Email != requestUser(r).Email {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	return watchProduct, nil
+}
+
+func (s *APIServer) userLoginHandler(w http.ResponseWriter, r *http.Request) {
+	type input struct {
+		Email string
+	}
+
+	var in input
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if in.Email == "" {
+		reject(w, "login", "no_email", http.StatusBadRequest, "no email")
+		return
+	}
+
+	in.Email = formatters.NormalizeEmail(in.Email)
+	if !formatters.IsValidEmail(in.Email) {
+		reject(w, "login", "invalid_email", http.StatusBadRequest, "invalid email")
+		return
+	}
+
+	if !s.allow(r, "login:ip:"+s.clientIP(r), loginIPLimit) {
+		reject(w, "login", "ip_rate_limit", http.StatusTooManyRequests, "too many requests")
+		return
+	}
+
+	if !s.allow(r, "login:email:"+in.Email, loginCooldown) {
+		reject(w, "login", "login_cooldown", http.StatusTooManyRequests, "login email was just sent")
+		return
+	}
+
+	if !s.allow(r, "recipient:"+in.Email, recipientLimit) {
+		reject(w, "login", "recipient_rate_limit", http.StatusTooManyRequests, "too many emails to this address")
+		return
+	}
+
+	// The first login creates the user
+	user, err := s.DB.GetUserByEmail(in.Email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		user = &scraper.User{Email: in.Email}
+		err = s.DB.CreateUser(user)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+
+	loginToken, err := user.SetLoginHash(time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = s.DB.UpdateUser(user)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	type email struct {
+		LoginHash string
+	}
+
+	rendered, err := templates.Render(templates.Locale(r.Header.Get("Accept-Language")), "user-login", email{LoginHash: loginToken})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = s.Notifier.Notify(r.Context(), notify.Message{
+		To:      user.Email,
+		Subject: rendered.Subject,
+		HTML:    rendered.HTML,
+		Text:    rendered.Text,
+	})
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Write([]byte("Sent!"))
+}
+
+func (s *APIServer) userLoginVerifyHandler(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+	if hash == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no hash"))
+		return
+	}
+
+	user, err := s.DB.GetUserByLoginHash(formatters.HashToken(hash))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	session, token, err := user.Login(time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = s.DB.LoginUser(user, session)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	type output struct {
+		Token     string
+		ExpiresAt time.Time
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(output{Token: token, ExpiresAt: session.ExpiresAt})
+}
+
+func (s *APIServer) userLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	err := s.DB.DeleteSession(formatters.HashToken(token))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Write([]byte("Logged out!"))
+}
+
+func (s *APIServer) userHandler(w http.ResponseWriter, r *http.Request) {
+	user := requestUser(r)
+
+	type output struct {
+		Email       string
+		LastLoginAt *time.Time
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(output{Email: user.Email, LastLoginAt: user.LastLoginAt})
+}
+
+func (s *APIServer) userWatchesHandler(w http.ResponseWriter, r *http.Request) {
+	watchProducts, err := s.DB.GetWatchProductsByEmail(requestUser(r).Email)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(watchProducts)
+}
+
+func (s *APIServer) userUpdateWatchHandler(w http.ResponseWriter, r *http.Request) {
+	watchProduct, err := s.userWatchProduct(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Same as when watching a product, without the email
+	type input struct {
+		Alerts         []string
+		Location       string
+		TargetPrice    uint
+		MinDrop        uint
+		MinDropPercent uint
+	}
+
+	var in input
+	err = json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = watchProduct.SetAlerts(in.Alerts, in.Location)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = watchProduct.SetThresholds(in.TargetPrice, in.MinDrop, in.MinDropPercent)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	// Logging in proved the email
+	watchProduct.Verified = true
+
+	err = s.DB.UpdateWatchProduct(watchProduct)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(watchProduct)
+}
+
+func (s *APIServer) userDeleteWatchHandler(w http.ResponseWriter, r *http.Request) {
+	watchProduct, err := s.userWatchProduct(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = s.DB.DeleteWatchProductByID(watchProduct.ID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Write([]byte("Deleted!"))
+}
+
+func (s *APIServer) userPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	preference, err := s.DB.GetEmailPreference(requestUser(r).Email)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		preference = &scraper.EmailPreference{Email: requestUser(r).Email, Digest: scraper.DigestImmediate}
+	}
+
+	type output struct {
+		Digest string
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(output{Digest: preference.Digest})
+}
+
+func (s *APIServer) userUpdatePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	type input struct {
+		Digest string // immediate, daily or weekly
+	}
+
+	var in input
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	preference := &scraper.EmailPreference{Email: requestUser(r).Email}
+	err = preference.SetDigest(in.Digest)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = s.DB.UpdateOrCreateEmailPreference(preference)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	type output struct {
+		Digest string
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(output{Digest: preference.Digest})
+}
+
+func (s *APIServer) userPushKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if s.PushKey == "" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("web push is not enabled"))
+		return
+	}
+
+	type output struct {
+		PublicKey string
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(output{PublicKey: s.PushKey})
+}
+
+// userPushSubscribeHandler saves a browser push subscription, as returned by PushManager.subscribe,
+// alerts to the user are pushed to it too
+func (s *APIServer) userPushSubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	if s.PushKey == "" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("web push is not enabled"))
+		return
+	}
+
+	var in notify.PushSubscription
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	endpoint, err := url.Parse(in.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("invalid endpoint"))
+		return
+	}
+
+	if in.Keys.P256dh == "" || in.Keys.Auth == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no keys"))
+		return
+	}
+
+	err = s.DB.UpdateOrCreatePushSubscription(&scraper.PushSubscription{
+		UserID:   requestUser(r).ID,
+		Endpoint: in.Endpoint,
+		P256dh:   in.Keys.P256dh,
+		Auth:     in.Keys.Auth,
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Write([]byte("Subscribed!"))
+}
+
+func (s *APIServer) userPushUnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	type input struct {
+		Endpoint string `json:"endpoint"`
+	}
+
+	var in input
+	err := json.NewDecoder(r.Body).Decode(&in)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	err = s.DB.DeletePushSubscription(requestUser(r).ID, in.Endpoint)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.Write([]byte("Unsubscribed!"))
+}